@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+var apiTokenFlag = flag.String("api-token", "", "bearer token for the daemon's HTTP API: required by the daemon for changes, and sent by elgo ctl")
+var apiTokenFile = flag.String("api-token-file", "", "read -api-token from this file")
+
+// apiToken returns the token given by -api-token or -api-token-file, or ""
+// if neither is set.
+func apiToken() (string, error) {
+	if *apiTokenFile == "" {
+		return *apiTokenFlag, nil
+	}
+	if *apiTokenFlag != "" {
+		return "", errors.New("-api-token and -api-token-file are mutually exclusive")
+	}
+	b, err := ioutil.ReadFile(*apiTokenFile)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", errors.New(*apiTokenFile + ": empty token")
+	}
+	return token, nil
+}
+
+// authorized reports whether r carries the API token, as a bearer token or,
+// for clients that can only follow links, a token query parameter.
+func (a *api) authorized(r *http.Request) bool {
+	got := r.URL.Query().Get("token")
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		got = strings.TrimPrefix(h, "Bearer ")
+	}
+	return a.validToken(got)
+}
+
+// validToken reports whether got is the API token.
+func (a *api) validToken(got string) bool {
+	// Compare hashes, so that the time taken doesn't depend on the length
+	// of the token either.
+	want, have := sha256.Sum256([]byte(a.token)), sha256.Sum256([]byte(got))
+	return subtle.ConstantTimeCompare(want[:], have[:]) == 1
+}
+
+// guard requires the API token for requests to h that change anything (any
+// method but GET and HEAD, or any request if mutating) and, with -auth-reads,
+// for reads too. Without a token, every request is allowed.
+func (a *api) guard(mutating bool, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		changes := mutating || (r.Method != http.MethodGet && r.Method != http.MethodHead)
+		if a.token != "" && (changes || a.authReads) && !a.authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="elgo"`)
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
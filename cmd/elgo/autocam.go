@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"time"
+)
+
+// A cameraMonitor reports whether a camera is in use. Implementations are
+// per platform; see newCameraMonitor.
+type cameraMonitor interface {
+	inUse() (bool, error)
+}
+
+// autocam applies a preset to every device while a camera is in use, and
+// turns them off once it has been idle for the off delay.
+func autocam(args []string) {
+	fs := flag.NewFlagSet("autocam", flag.ExitOnError)
+	onFlag := fs.String("on", "on", "preset to apply when a camera becomes active")
+	offDelay := fs.Duration("off-delay", 30*time.Second, "turn off after the camera has been idle this long")
+	interval := fs.Duration("interval", time.Second, "camera polling interval")
+	fs.Parse(args)
+
+	on, err := parsePreset(*onFlag)
+	if err != nil {
+		fatal(err)
+	}
+	cam, err := newCameraMonitor()
+	if err != nil {
+		fatal(err)
+	}
+
+	found := discoverAll()
+	longRunning = true
+
+	active := false
+	var idleSince time.Time
+	for ok := true; ok; ok = sleepOrStop(*interval) {
+		inUse, err := cam.inUse()
+		if err != nil {
+			cmdLog.warnf("camera: %v", err)
+			continue
+		}
+		switch {
+		case inUse:
+			idleSince = time.Time{}
+			if !active {
+				cmdLog.infof("camera active, applying %s", on)
+				applyAll(found, on)
+				active = true
+			}
+		case active:
+			if idleSince.IsZero() {
+				idleSince = time.Now()
+			}
+			if time.Since(idleSince) >= *offDelay {
+				cmdLog.infof("camera idle for %s, turning off", *offDelay)
+				applyAll(found, preset{})
+				active = false
+			}
+		}
+	}
+}
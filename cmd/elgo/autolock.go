@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"flag"
+)
+
+// autolock applies a preset to every device when the screen locks, and
+// another (by default, whatever was set before locking) when it unlocks.
+func autolock(args []string) {
+	fs := flag.NewFlagSet("autolock", flag.ExitOnError)
+	lockFlag := fs.String("lock", "off", "preset to apply when the screen locks")
+	unlockFlag := fs.String("unlock", "restore", `preset to apply when the screen unlocks, or "restore" for the state before locking`)
+	fs.Parse(args)
+
+	lock, err := parsePreset(*lockFlag)
+	if err != nil {
+		fatal(err)
+	}
+	restore := *unlockFlag == "restore"
+	var unlock preset
+	if !restore {
+		if unlock, err = parsePreset(*unlockFlag); err != nil {
+			fatal(err)
+		}
+	}
+
+	events, err := lockEvents()
+	if err != nil {
+		fatal(err)
+	}
+
+	found := discoverAll()
+	longRunning = true
+
+	var saved snapshot // captured at lock time
+	for {
+		var locked bool
+		select {
+		case l, ok := <-events:
+			if !ok {
+				fatal(errors.New("lock monitoring stopped"))
+			}
+			locked = l
+		case <-interrupted.Done():
+			if saved != nil && restore {
+				saved.restore(found)
+			}
+			return
+		}
+		if locked {
+			if saved != nil {
+				continue // already locked
+			}
+			saved = snapshot{}
+			if restore {
+				saved = capture(found)
+			}
+			cmdLog.infof("screen locked, applying %s", lock)
+			applyAll(found, lock)
+			continue
+		}
+		if saved == nil {
+			continue // already unlocked
+		}
+		if restore {
+			cmdLog.infof("screen unlocked, restoring")
+			saved.restore(found)
+		} else {
+			cmdLog.infof("screen unlocked, applying %s", unlock)
+			applyAll(found, unlock)
+		}
+		saved = nil
+	}
+}
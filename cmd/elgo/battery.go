@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/vsekhar/elgo"
+)
+
+const batteryInfoEndpoint = "battery-info"
+
+// Values of batteryInfo.PowerSource.
+const (
+	powerSourceMains   = 1 // USB or wall power
+	powerSourceBattery = 2
+)
+
+// batteryCharging is the batteryInfo.Status of a charging battery.
+const batteryCharging = 2
+
+var errNoBattery = errors.New("this device has no battery")
+
+// batteryInfo is the battery-info of battery-powered lights such as the Key
+// Light Mini.
+type batteryInfo struct {
+	PowerSource int     `json:"powerSource"`
+	Level       float64 `json:"level"` // percent
+	Status      int     `json:"status"`
+}
+
+func (b batteryInfo) charging() string {
+	if b.Status == batteryCharging {
+		return "charging"
+	}
+	return "discharging"
+}
+
+func (b batteryInfo) source() string {
+	switch b.PowerSource {
+	case powerSourceMains:
+		return "mains"
+	case powerSourceBattery:
+		return "battery"
+	}
+	return fmt.Sprintf("unknown (%d)", b.PowerSource)
+}
+
+// hasBattery reports whether the model productName may run on battery.
+func hasBattery(productName string) bool {
+	return lookupModel(productName).Battery
+}
+
+// fetchBattery returns the battery-info of the device at hostName, or
+// errNoBattery if it has none.
+func fetchBattery(hostName string) (batteryInfo, error) {
+	b, err := request(http.MethodGet, deviceURL(hostName, batteryInfoEndpoint), nil)
+	var httpErr *elgo.HTTPError
+	if errors.As(err, &httpErr) && httpErr.Status == http.StatusNotFound {
+		return batteryInfo{}, errNoBattery
+	}
+	if err != nil {
+		return batteryInfo{}, err
+	}
+	var info batteryInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return batteryInfo{}, badJSON(hostName, "battery-info", b, err)
+	}
+	return info, nil
+}
+
+// battery prints the battery level, whether it is charging, and the power
+// source of a device, or with "settings", its energy-saving settings.
+func battery(args []string) {
+	fs := flag.NewFlagSet("battery", flag.ExitOnError)
+	asJSON := jsonFlag(fs, "print the battery information as JSON")
+	fs.Parse(args)
+	if fs.NArg() > 0 && fs.Arg(0) != "settings" && fs.Arg(0) != "bypass" {
+		fatal(errors.New("usage: elgo battery [-json] [settings [set NAME=VALUE...] | bypass [on|off|status]]"))
+	}
+
+	hostName := resolveHost()
+	if m := deviceModel(hostName); !m.Battery {
+		fatal(fmt.Errorf("%s: %s has no battery", hostName, m.Name))
+	}
+	switch fs.Arg(0) {
+	case "settings":
+		batterySettingsCommand(hostName, fs.Args()[1:])
+		return
+	case "bypass":
+		batteryBypassCommand(hostName, fs.Args()[1:])
+		return
+	}
+	b, err := fetchBattery(hostName)
+	if err == errNoBattery {
+		fatal(fmt.Errorf("%s: %v", hostName, err))
+	}
+	if err != nil {
+		fatal(err)
+	}
+	if *asJSON {
+		out := struct {
+			batteryInfo
+			Bypass *bool         `json:"bypass,omitempty"`
+			Timing *timingReport `json:"timing,omitempty"`
+		}{batteryInfo: b}
+		if s, err := fetchBatterySettings(hostName); err == nil {
+			if on, ok := s.bypass(); ok {
+				out.Bypass = &on
+			}
+		}
+		out.Timing = timingField()
+		if err := json.NewEncoder(os.Stdout).Encode(out); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	fmt.Printf("level: %.0f%%\n", b.Level)
+	fmt.Printf("status: %s\n", b.charging())
+	fmt.Printf("source: %s\n", b.source())
+}
+
+// fetchAllBatteries fetches the battery information of those devices whose
+// models have batteries, concurrently. That of other devices, and of those
+// that don't answer, is nil.
+func fetchAllBatteries(devices []device, infos []accessoryInfo) []*batteryInfo {
+	batteries := make([]*batteryInfo, len(devices))
+	var wg sync.WaitGroup
+	for i, d := range devices {
+		wg.Add(1)
+		go func(i int, d device) {
+			defer wg.Done()
+			batteries[i] = fetchBatteryOf(d, infos[i])
+		}(i, d)
+	}
+	wg.Wait()
+	return batteries
+}
+
+// fetchBatteryOf returns the battery information of d, whose accessory-info
+// is info, or nil if it has no battery or doesn't answer.
+func fetchBatteryOf(d device, info accessoryInfo) *batteryInfo {
+	if info.ProductName == "" || !hasBattery(info.ProductName) {
+		return nil
+	}
+	b, err := fetchBattery(d.HostName)
+	if err != nil {
+		discoveryLog.debugf("%s: %v", d.HostName, err)
+		return nil
+	}
+	return &b
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vsekhar/elgo"
+)
+
+const batterySettingsEndpoint = "battery-settings"
+
+// batterySettings holds the battery-settings of a device by dotted path
+// (e.g. energySaving.minimumBatteryLevel), as reported, so that fields elgo
+// doesn't know are written back unchanged.
+type batterySettings map[string]json.Number
+
+// flatten adds the numbers in v to s under their dotted paths from prefix.
+// Anything other than numbers and objects is skipped rather than written
+// back wrong.
+func (s batterySettings) flatten(prefix string, v interface{}) {
+	switch v := v.(type) {
+	case json.Number:
+		s[prefix] = v
+	case map[string]interface{}:
+		for k, e := range v {
+			if prefix != "" {
+				k = prefix + "." + k
+			}
+			s.flatten(k, e)
+		}
+	}
+}
+
+// nested returns s as the nested objects the device expects.
+func (s batterySettings) nested() map[string]interface{} {
+	out := map[string]interface{}{}
+	for k, v := range s {
+		m := out
+		parts := strings.Split(k, ".")
+		for _, p := range parts[:len(parts)-1] {
+			next, ok := m[p].(map[string]interface{})
+			if !ok {
+				next = map[string]interface{}{}
+				m[p] = next
+			}
+			m = next
+		}
+		m[parts[len(parts)-1]] = v
+	}
+	return out
+}
+
+func fetchBatterySettings(hostName string) (batterySettings, error) {
+	b, err := request(http.MethodGet, deviceURL(hostName, batterySettingsEndpoint), nil)
+	var httpErr *elgo.HTTPError
+	if errors.As(err, &httpErr) && httpErr.Status == http.StatusNotFound {
+		return nil, errNoBattery
+	}
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&raw); err != nil {
+		return nil, badJSON(hostName, "battery-settings", b, err)
+	}
+	s := batterySettings{}
+	s.flatten("", raw)
+	return s, nil
+}
+
+// putBatterySettings writes s to the device at hostName and returns the
+// settings read back.
+func putBatterySettings(hostName string, s batterySettings) (batterySettings, error) {
+	body, err := json.Marshal(s.nested())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := request(http.MethodPut, deviceURL(hostName, batterySettingsEndpoint), body); err != nil {
+		return nil, err
+	}
+	return fetchBatterySettings(hostName)
+}
+
+// bypass reports whether s has the light run from external power only,
+// which Elgato calls studio mode, and whether the device has the setting.
+func (s batterySettings) bypass() (on, ok bool) {
+	v, ok := s["bypass"]
+	return v.String() == "1", ok
+}
+
+// batteryBypassCommand prints whether the device at hostName bypasses its
+// battery, or with "on" or "off", changes it and confirms the change.
+func batteryBypassCommand(hostName string, args []string) {
+	if len(args) > 1 || len(args) == 1 && args[0] != "on" && args[0] != "off" && args[0] != "status" {
+		fatal(errors.New("usage: elgo battery bypass [on|off|status]"))
+	}
+	s, err := fetchBatterySettings(hostName)
+	if err == errNoBattery {
+		fatal(fmt.Errorf("%s: %v", hostName, err))
+	}
+	if err != nil {
+		fatal(err)
+	}
+	on, ok := s.bypass()
+	if !ok {
+		fatal(fmt.Errorf("%s: device has no bypass setting", hostName))
+	}
+	if len(args) == 1 && args[0] != "status" {
+		want := args[0] == "on"
+		s["bypass"] = "0"
+		if want {
+			s["bypass"] = "1"
+		}
+		if s, err = putBatterySettings(hostName, s); err != nil {
+			fatal(err)
+		}
+		if on, _ = s.bypass(); on != want {
+			fatal(fmt.Errorf("%s: device didn't apply bypass %s", hostName, args[0]))
+		}
+	}
+	if on {
+		fmt.Println("bypass: on (" + batterySwitches["bypass"] + ")")
+	} else {
+		fmt.Println("bypass: off")
+	}
+}
+
+// batterySwitches are the battery settings that are either 0 (off) or 1
+// (on).
+var batterySwitches = map[string]string{
+	"bypass":                               "run from external power only, bypassing the battery",
+	"energySaving.enable":                  "save energy when the battery is low",
+	"energySaving.disableWifi":             "turn off Wi-Fi when saving energy",
+	"energySaving.adjustBrightness.enable": "cap brightness when saving energy",
+}
+
+// describe returns the setting k for display.
+func (s batterySettings) describe(k string) string {
+	v := s[k].String()
+	switch k {
+	case "energySaving.minimumBatteryLevel":
+		return v + "%"
+	}
+	if what, ok := batterySwitches[k]; ok {
+		if v == "1" {
+			return v + " (" + what + ")"
+		}
+	}
+	return v
+}
+
+// set parses and validates a NAME=VALUE assignment for the device at
+// hostName and applies it to s.
+func (s batterySettings) set(hostName, assignment string) error {
+	i := strings.Index(assignment, "=")
+	if i < 0 {
+		return fmt.Errorf("%q: want NAME=VALUE", assignment)
+	}
+	k, v := assignment[:i], assignment[i+1:]
+	if _, ok := s[k]; !ok {
+		return fmt.Errorf("%s: device has no such battery setting", k)
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %q is not a number", k, v)
+	}
+	r := deviceRange(hostName)
+	switch {
+	case batterySwitches[k] != "":
+		if n != 0 && n != 1 {
+			return fmt.Errorf("%s must be 0 or 1", k)
+		}
+	case k == "energySaving.minimumBatteryLevel":
+		if n < 0 || n > 100 {
+			return fmt.Errorf("%s must be between 0 and 100", k)
+		}
+	case k == "energySaving.adjustBrightness.brightness":
+		b, err := limitValue(hostName, k, int(math.Round(n)), r.BrightnessMin, r.BrightnessMax)
+		if err != nil {
+			return fmt.Errorf("%w: %v", elgo.ErrInvalidBrightness, err)
+		}
+		n = float64(b)
+	}
+	s[k] = json.Number(strconv.FormatFloat(n, 'f', -1, 64))
+	return nil
+}
+
+// batterySettingsCommand prints the energy-saving settings of the device at
+// hostName, or with "set NAME=VALUE...", changes them.
+func batterySettingsCommand(hostName string, args []string) {
+	if len(args) > 0 && (args[0] != "set" || len(args) == 1) {
+		fatal(errors.New("usage: elgo battery settings [set NAME=VALUE...]"))
+	}
+	s, err := fetchBatterySettings(hostName)
+	if err == errNoBattery {
+		fatal(fmt.Errorf("%s: %v", hostName, err))
+	}
+	if err != nil {
+		fatal(err)
+	}
+	if len(args) > 0 {
+		for _, a := range args[1:] {
+			if err := s.set(hostName, a); err != nil {
+				fatal(err)
+			}
+		}
+		if s, err = putBatterySettings(hostName, s); err != nil {
+			fatal(err)
+		}
+	}
+	var keys []string
+	for k := range s {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Printf("%s: %s\n", k, s.describe(k))
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// benchResult is the output of bench. Durations are in milliseconds.
+type benchResult struct {
+	Host        string   `json:"host"`
+	DiscoveryMs *float64 `json:"discoveryMs,omitempty"` // nil if -host was given
+	N           int      `json:"n"`
+	MinMs       float64  `json:"minMs"`
+	AvgMs       float64  `json:"avgMs"`
+	P95Ms       float64  `json:"p95Ms"`
+	MaxMs       float64  `json:"maxMs"`
+	Connections int      `json:"connections"` // opened for the requests
+
+	Timing *timingReport `json:"timing,omitempty"` // with -timing and -json
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// percentile returns the p-th percentile of sorted using the nearest-rank
+// method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(float64(len(sorted))*p/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// bench times discovery and a series of sequential state requests.
+func bench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	benchHost := fs.String("host", *host, "address of the light, skipping discovery")
+	n := fs.Int("n", 50, "number of requests")
+	asJSON := jsonFlag(fs, "print results as JSON")
+	fs.Parse(args)
+	if *n < 1 {
+		fatal(errors.New("-n must be at least 1"))
+	}
+
+	r := benchResult{N: *n}
+	if *benchHost != "" {
+		*host = *benchHost
+		r.Host = hostAddr()
+	} else {
+		t := time.Now()
+		hostName, err := getMDNS()
+		if err != nil {
+			fatal(err)
+		}
+		d := ms(time.Since(t))
+		r.Host, r.DiscoveryMs = hostName, &d
+	}
+
+	longRunning = true
+	opened := atomic.LoadInt32(&connections)
+	times := make([]time.Duration, *n)
+	var total time.Duration
+	for i := range times {
+		t := time.Now()
+		getState(r.Host)
+		times[i] = time.Since(t)
+		total += times[i]
+	}
+	r.Connections = int(atomic.LoadInt32(&connections) - opened)
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	r.MinMs = ms(times[0])
+	r.AvgMs = ms(total / time.Duration(*n))
+	r.P95Ms = ms(percentile(times, 95))
+	r.MaxMs = ms(times[len(times)-1])
+
+	if *asJSON {
+		r.Timing = timingField()
+		if err := json.NewEncoder(os.Stdout).Encode(r); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	fmt.Printf("host: %s\n", r.Host)
+	if r.DiscoveryMs != nil {
+		fmt.Printf("discovery: %.1fms\n", *r.DiscoveryMs)
+	}
+	fmt.Printf("getState x%d: min %.1fms, avg %.1fms, p95 %.1fms, max %.1fms, %d connections\n", r.N, r.MinMs, r.AvgMs, r.P95Ms, r.MaxMs, r.Connections)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"flag"
+)
+
+// biLevel flips the brightness of a light between two levels, leaving the
+// temperature alone. A light that is off is turned on at the high level.
+func biLevel(args []string) {
+	fs := flag.NewFlagSet("bi-level", flag.ExitOnError)
+	low := fs.Int("low", 30, "low brightness (between 1 and 100)")
+	high := fs.Int("high", 80, "high brightness (between 1 and 100)")
+	fs.Parse(args)
+	if *low < 1 || *low > 100 || *high < 1 || *high > 100 {
+		fatal(errors.New("-low and -high must be between 1 and 100"))
+	}
+	if *low >= *high {
+		fatal(errors.New("-low must be less than -high"))
+	}
+
+	hostName := resolveHost()
+	cur := getState(hostName)
+	i, err := selectLight(cur)
+	if err != nil {
+		fatal(err)
+	}
+	l := cur.Lights[i]
+	b := *high
+	if l.On == 1 && *high-l.Brightness <= l.Brightness-*low {
+		b = *low
+	}
+	deviceLog(hostName).debugf("brightness %d -> %d", l.Brightness, b)
+	s := cur
+	s.Lights[i] = light{ID: l.ID, On: 1, Brightness: b}
+	putState(hostName, s)
+}
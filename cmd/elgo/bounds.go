@@ -0,0 +1,59 @@
+package main
+
+// lightRange is the range of settings a device's lights accept, in device
+// units. Devices may report it in accessory-info; those that don't are
+// assumed to accept defaultRange.
+type lightRange struct {
+	BrightnessMin  int `json:"brightnessMin,omitempty"`
+	BrightnessMax  int `json:"brightnessMax,omitempty"`
+	TemperatureMin int `json:"temperatureMin,omitempty"`
+	TemperatureMax int `json:"temperatureMax,omitempty"`
+}
+
+// defaultRange is that of the Key Light.
+var defaultRange = lightRange{BrightnessMin: 1, BrightnessMax: 100, TemperatureMin: 143, TemperatureMax: 344}
+
+// orDefault returns r with missing or inconsistent bounds taken from
+// defaultRange.
+func (r lightRange) orDefault() lightRange {
+	return r.or(defaultRange)
+}
+
+// or returns r with missing or inconsistent bounds taken from d.
+func (r lightRange) or(d lightRange) lightRange {
+	if r.BrightnessMin <= 0 || r.BrightnessMax < r.BrightnessMin {
+		r.BrightnessMin, r.BrightnessMax = d.BrightnessMin, d.BrightnessMax
+	}
+	if r.TemperatureMin <= 0 || r.TemperatureMax < r.TemperatureMin {
+		r.TemperatureMin, r.TemperatureMax = d.TemperatureMin, d.TemperatureMax
+	}
+	return r
+}
+
+// kelvin returns the range of temperatures in Kelvin. The default range is
+// the advertised 2900K to 7000K, which converting device units would only
+// approximate.
+func (r lightRange) kelvin() (min, max int) {
+	if r.TemperatureMin == defaultRange.TemperatureMin && r.TemperatureMax == defaultRange.TemperatureMax {
+		return 2900, 7000
+	}
+	return toKelvin(r.TemperatureMax), toKelvin(r.TemperatureMin)
+}
+
+// temperature converts kelvin to device units in r. Converting the ends of
+// the Kelvin range can round just past the ends of r (7000K is 142, below
+// the usual 143), so the result is clamped.
+func (r lightRange) temperature(kelvin int) int {
+	return clamp(fromKelvin(kelvin), r.TemperatureMin, r.TemperatureMax)
+}
+
+// deviceRange returns the range of the device at hostName, from the host
+// cache if fresh, or defaultRange if it can't be fetched.
+func deviceRange(hostName string) lightRange {
+	h, err := cachedInfo(hostName, func(h cachedHost) bool { return h.Range == nil })
+	if err != nil {
+		deviceLog(hostName).debugf("assuming default ranges: %v", err)
+		return defaultRange
+	}
+	return h.Range.orDefault()
+}
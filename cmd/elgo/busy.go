@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Devices answer with one of these statuses when they are busy, e.g. while
+// the Control Center app is changing them too.
+var busyStatuses = map[int]bool{
+	http.StatusConflict:           true,
+	http.StatusTooManyRequests:    true,
+	http.StatusServiceUnavailable: true,
+}
+
+// busyRetries is how many times a request is retried while its device is
+// busy, backing off from busyBackoff.
+const (
+	busyRetries = 4
+	busyBackoff = 100 * time.Millisecond
+	maxBackoff  = 2 * time.Second
+)
+
+// busyDelay returns how long to wait before retry number n (from 0) of a
+// request answered with resp, following any Retry-After header.
+func busyDelay(n int, resp *http.Response) time.Duration {
+	d := busyBackoff << uint(n)
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s >= 0 {
+		d = time.Duration(s) * time.Second
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+// canWait reports whether there is time to wait d and then retry before
+// the timeout.
+func canWait(d time.Duration) bool {
+	return longRunning || *timeout-time.Since(start) > d
+}
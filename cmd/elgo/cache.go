@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheTTL is how long cached device details are trusted before they are
+// fetched again.
+const cacheTTL = 24 * time.Hour
+
+// cachedHost holds details about a device that rarely change.
+type cachedHost struct {
+	Instance string      `json:"instance,omitempty"` // set if found via mDNS
+	HostName string      `json:"hostName,omitempty"` // host:port, set if keyed by MAC
+	IP       net.IP      `json:"ip,omitempty"`
+	Seen     time.Time   `json:"seen"`            // when last found via mDNS
+	Model    string      `json:"model,omitempty"` // as advertised over mDNS
+	Product  string      `json:"product,omitempty"`
+	Firmware string      `json:"firmware,omitempty"`
+	Range    *lightRange `json:"range,omitempty"`
+	Updated  time.Time   `json:"updated"` // when Product, Firmware and Range were fetched
+	Picked   time.Time   `json:"picked"`  // when last chosen from several
+}
+
+// hostCache maps device keys (see device.key) to cached details.
+type hostCache map[string]cachedHost
+
+// find returns the key of the device at hostName, which is hostName itself
+// if the device's MAC isn't known.
+func (c hostCache) find(hostName string) string {
+	for key, h := range c {
+		if h.HostName == hostName {
+			return key
+		}
+	}
+	return hostName
+}
+
+// knownDevice returns the device at hostName, with what the host cache
+// knows of it.
+func knownDevice(hostName string) device {
+	c := loadCache()
+	key := c.find(hostName)
+	h := c[key]
+	d := device{Instance: h.Instance, HostName: hostName, IP: h.IP, Model: h.Model}
+	if key != hostName {
+		d.MAC = key
+	}
+	return d
+}
+
+// hostName returns the address of the device with key.
+func (c hostCache) hostName(key string) string {
+	if h := c[key]; h.HostName != "" {
+		return h.HostName
+	}
+	return key
+}
+
+// cachePath returns the path of the host cache file.
+func cachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "elgo", "hosts.json"), nil
+}
+
+// loadCache returns the host cache, or an empty one if it can't be read.
+func loadCache() hostCache {
+	c := hostCache{}
+	path, err := cachePath()
+	if err != nil {
+		return c
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		cmdLog.debugf("ignoring bad host cache %s: %v", path, err)
+	}
+	return c
+}
+
+// save writes the cache. Failures only matter for performance, so they are
+// logged at debug level and otherwise ignored.
+func (c hostCache) save() {
+	path, err := cachePath()
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+	}
+	if err == nil {
+		var b []byte
+		b, err = json.MarshalIndent(c, "", "  ")
+		if err == nil {
+			err = ioutil.WriteFile(path, b, 0644)
+		}
+	}
+	if err != nil {
+		cmdLog.debugf("saving host cache: %v", err)
+	}
+}
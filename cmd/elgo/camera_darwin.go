@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// logCamera detects camera use from the power state changes the camera
+// assistant writes to the unified log.
+type logCamera struct {
+	mu  sync.Mutex
+	on  bool
+	err error
+}
+
+func newCameraMonitor() (cameraMonitor, error) {
+	cmd := exec.Command("log", "stream", "--predicate",
+		`subsystem contains "com.apple.UVCExtension" and composedMessage contains "Post PowerLog"`)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	c := &logCamera{}
+	go func() {
+		scanner := bufio.NewScanner(out)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.Contains(line, "VDCAssistant_Power_State") {
+				continue
+			}
+			c.mu.Lock()
+			c.on = strings.Contains(line, "= On")
+			c.mu.Unlock()
+		}
+		c.mu.Lock()
+		c.err = cmd.Wait()
+		if c.err == nil {
+			c.err = scanner.Err()
+		}
+		c.mu.Unlock()
+	}()
+	return c, nil
+}
+
+func (c *logCamera) inUse() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.on, c.err
+}
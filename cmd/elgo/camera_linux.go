@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// procCamera detects camera use by looking for processes with a video
+// device open, as fuser(1) does.
+type procCamera struct{}
+
+func newCameraMonitor() (cameraMonitor, error) {
+	return procCamera{}, nil
+}
+
+func (procCamera) inUse() (bool, error) {
+	fds, err := filepath.Glob("/proc/[0-9]*/fd/*")
+	if err != nil {
+		return false, err
+	}
+	self := "/proc/" + strconv.Itoa(os.Getpid()) + "/"
+	for _, fd := range fds {
+		if strings.HasPrefix(fd, self) {
+			continue
+		}
+		// Processes we can't inspect, or that exit, are skipped.
+		target, err := os.Readlink(fd)
+		if err == nil && strings.HasPrefix(target, "/dev/video") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func newCameraMonitor() (cameraMonitor, error) {
+	return nil, fmt.Errorf("camera detection is not supported on %s", runtime.GOOS)
+}
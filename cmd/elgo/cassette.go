@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// A cassette is a recording of the HTTP exchanges with a device, used to
+// reproduce device behavior without the hardware.
+type cassette struct {
+	Interactions []interaction `json:"interactions"`
+}
+
+type interaction struct {
+	Request  recordedRequest  `json:"request"`
+	Response recordedResponse `json:"response"`
+}
+
+type recordedRequest struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Body   string `json:"body,omitempty"`
+}
+
+type recordedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body"`
+}
+
+func readCassette(path string) cassette {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		fatal(err)
+	}
+	c := cassette{}
+	if err := json.Unmarshal(b, &c); err != nil {
+		fatal(fmt.Errorf("%s: %v", path, err))
+	}
+	return c
+}
+
+// recorder is an http.RoundTripper that records each exchange to the
+// cassette at path, rewriting the file after every exchange so that a
+// recording survives the process exiting.
+type recorder struct {
+	path string
+	next http.RoundTripper
+
+	mu sync.Mutex
+	c  cassette
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.c.Interactions = append(r.c.Interactions, interaction{
+		Request: recordedRequest{
+			Method: req.Method,
+			Path:   req.URL.Path,
+			Body:   string(reqBody),
+		},
+		Response: recordedResponse{
+			Status: resp.StatusCode,
+			Header: resp.Header,
+			Body:   string(respBody),
+		},
+	})
+	b, err := json.MarshalIndent(r.c, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(r.path, b, 0644); err != nil {
+		cmdLog.warnf("recording to %s: %v", r.path, err)
+	}
+	return resp, nil
+}
+
+// replayServer returns a server that answers each request with the next
+// unused recorded response for the same method and path in the cassette at
+// path, and with 404 when there is none.
+func replayServer(path string) *httptest.Server {
+	c := readCassette(path)
+	mu := sync.Mutex{}
+	used := make([]bool, len(c.Interactions))
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		for i, in := range c.Interactions {
+			if used[i] || in.Request.Method != req.Method || in.Request.Path != req.URL.Path {
+				continue
+			}
+			used[i] = true
+			for k, vs := range in.Response.Header {
+				for _, v := range vs {
+					w.Header().Add(k, v)
+				}
+			}
+			w.Header().Del("Content-Length")
+			w.WriteHeader(in.Response.Status)
+			w.Write([]byte(in.Response.Body))
+			return
+		}
+		cmdLog.warnf("replay: no recorded response for %s %s", req.Method, req.URL.Path)
+		http.NotFound(w, req)
+	}))
+}
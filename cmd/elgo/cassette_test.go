@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplayToggle(t *testing.T) {
+	srv := replayServer("testdata/keylight-toggle.json")
+	defer srv.Close()
+	hostName := srv.Listener.Addr().String()
+
+	s, err := fetchState(hostName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Lights) != 1 || s.Lights[0].On != 1 || s.Lights[0].Brightness != 20 {
+		t.Fatalf("got %+v, want one light on at 20%%", s)
+	}
+	r, err := writeState(hostName, state{NumberOfLights: 1, Lights: []light{{On: 0}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Lights[0].On != 0 {
+		t.Errorf("light still on after toggle: %+v", r)
+	}
+	// Each recorded response is used once.
+	if _, err := fetchState(hostName); err == nil {
+		t.Error("second GET succeeded with only one recorded")
+	}
+}
+
+func TestRecordThenReplay(t *testing.T) {
+	const body = `{"numberOfLights":1,"lights":[{"on":1,"brightness":42,"temperature":250}]}`
+	device := fakeDevice(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}))
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	c := &http.Client{Transport: &recorder{path: path, next: http.DefaultTransport}}
+	resp, err := c.Get("http://" + device + "/elgato/lights")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	got := readCassette(path)
+	if len(got.Interactions) != 1 {
+		t.Fatalf("recorded %d interactions, want 1", len(got.Interactions))
+	}
+	in := got.Interactions[0]
+	if in.Request.Method != http.MethodGet || in.Request.Path != "/elgato/lights" || in.Response.Status != 200 || in.Response.Body != body {
+		t.Errorf("recorded %+v", in)
+	}
+
+	srv := replayServer(path)
+	defer srv.Close()
+	s, err := fetchState(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Lights[0].Brightness != 42 {
+		t.Errorf("replayed brightness %d, want 42", s.Lights[0].Brightness)
+	}
+}
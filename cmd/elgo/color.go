@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var hue = colorFlag("hue", 360, "set hue in degrees between 0 (red) and 360 on devices with color, such as the Light Strip")
+var saturation = colorFlag("saturation", 100, "set saturation between 0 (white) and 100 on devices with color, such as the Light Strip")
+var colorSpec = flag.String("color", "", "set hue, saturation and (unless -brightness is given) brightness on devices with color from a color given as #RRGGBB, R,G,B or a CSS name (e.g. orange)")
+
+// A colorValue is a flag for a hue or saturation. Unlike a setting, it may
+// be set to 0.
+type colorValue struct {
+	name string
+	max  float64
+
+	v   float64
+	set bool
+}
+
+// colorFlag defines a color flag for a property between 0 and max.
+func colorFlag(name string, max float64, usage string) *colorValue {
+	c := &colorValue{name: name, max: max}
+	flag.Var(c, name, usage)
+	return c
+}
+
+func (c *colorValue) String() string {
+	if c == nil || !c.set {
+		return ""
+	}
+	return strconv.FormatFloat(c.v, 'f', -1, 64)
+}
+
+func (c *colorValue) Set(v string) error {
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return fmt.Errorf("must be a number")
+	}
+	c.v, c.set = n, true
+	return nil
+}
+
+// hasColor reports whether l is of a device with color, which report a hue
+// and saturation whatever mode they are in.
+func hasColor(l light) bool {
+	return l.Hue != nil
+}
+
+// inColor reports whether l is showing a color rather than a white, which
+// it shows by reporting no temperature.
+func inColor(l light) bool {
+	return hasColor(l) && l.Temperature == 0
+}
+
+// describeColor returns the color of l for display, with its RGB value at
+// full brightness.
+func describeColor(l light) string {
+	s := 0.0
+	if l.Saturation != nil {
+		s = *l.Saturation
+	}
+	r, g, b := hsvToRGB(*l.Hue, s, 100)
+	return fmt.Sprintf("hue %.0f°, saturation %.0f%% (#%02x%02x%02x)", *l.Hue, s, r, g, b)
+}
+
+// parseColor parses a color given as #RRGGBB (or #RGB), R,G,B with each
+// between 0 and 255, or a CSS color name.
+func parseColor(s string) (r, g, b uint8, err error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "#") {
+		h := s[1:]
+		if len(h) == 3 {
+			h = string([]byte{h[0], h[0], h[1], h[1], h[2], h[2]})
+		}
+		n, err := strconv.ParseUint(h, 16, 32)
+		if len(h) != 6 || err != nil {
+			return 0, 0, 0, fmt.Errorf("%q: want #RRGGBB", s)
+		}
+		return uint8(n >> 16), uint8(n >> 8), uint8(n), nil
+	}
+	if parts := strings.Split(s, ","); len(parts) == 3 {
+		var c [3]uint8
+		for i, p := range parts {
+			n, err := strconv.ParseUint(strings.TrimSpace(p), 10, 8)
+			if err != nil {
+				return 0, 0, 0, fmt.Errorf("%q: want R,G,B, each between 0 and 255", s)
+			}
+			c[i] = uint8(n)
+		}
+		return c[0], c[1], c[2], nil
+	}
+	n, ok := cssColors[strings.ToLower(s)]
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("%q: not #RRGGBB, R,G,B or a CSS color name", s)
+	}
+	return uint8(n >> 16), uint8(n >> 8), uint8(n), nil
+}
+
+// rgbToHSV returns the hue (0 to 360 degrees), saturation and value (0 to
+// 100) of an RGB color.
+func rgbToHSV(r, g, b uint8) (h, s, v float64) {
+	rf, gf, bf := float64(r)/255, float64(g)/255, float64(b)/255
+	max := math.Max(rf, math.Max(gf, bf))
+	min := math.Min(rf, math.Min(gf, bf))
+	d := max - min
+	switch {
+	case d == 0:
+	case max == rf:
+		h = 60 * math.Mod((gf-bf)/d, 6)
+	case max == gf:
+		h = 60 * ((bf-rf)/d + 2)
+	default:
+		h = 60 * ((rf-gf)/d + 4)
+	}
+	if h < 0 {
+		h += 360
+	}
+	if max > 0 {
+		s = d / max * 100
+	}
+	return h, s, max * 100
+}
+
+// hsvToRGB returns the RGB color of a hue (in degrees), saturation and value
+// (0 to 100), the inverse of rgbToHSV.
+func hsvToRGB(h, s, v float64) (r, g, b uint8) {
+	h = math.Mod(h, 360)
+	if h < 0 {
+		h += 360
+	}
+	v /= 100
+	c := v * s / 100
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	var rf, gf, bf float64
+	switch {
+	case h < 60:
+		rf, gf = c, x
+	case h < 120:
+		rf, gf = x, c
+	case h < 180:
+		gf, bf = c, x
+	case h < 240:
+		gf, bf = x, c
+	case h < 300:
+		rf, bf = x, c
+	default:
+		rf, bf = c, x
+	}
+	m := v - c
+	scale := func(f float64) uint8 { return uint8(math.Round((f + m) * 255)) }
+	return scale(rf), scale(gf), scale(bf)
+}
+
+// applyColor sets the hue, saturation and, unless set, brightness settings
+// from -color.
+func applyColor() {
+	if *colorSpec == "" {
+		return
+	}
+	if hue.set || saturation.set {
+		fatal(errors.New("-color can't be used with -hue or -saturation"))
+	}
+	r, g, b, err := parseColor(*colorSpec)
+	if err != nil {
+		fatal(fmt.Errorf("bad -color: %v", err))
+	}
+	h, s, v := rgbToHSV(r, g, b)
+	if v == 0 {
+		fatal(errors.New("bad -color: black can't be shown; use off"))
+	}
+	hue.v, hue.set = math.Round(h), true
+	saturation.v, saturation.set = math.Round(s), true
+	if !brightness.isSet() {
+		brightness.n = int(math.Round(v))
+		if brightness.n < 1 {
+			brightness.n = 1
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRGBToHSV(t *testing.T) {
+	for _, tt := range []struct {
+		r, g, b uint8
+		h, s, v float64
+	}{
+		{255, 0, 0, 0, 100, 100},
+		{0, 255, 0, 120, 100, 100},
+		{0, 0, 255, 240, 100, 100},
+		{255, 136, 0, 32, 100, 100},
+		{255, 0, 255, 300, 100, 100},
+		{128, 128, 128, 0, 0, 50.2},
+		{0, 0, 0, 0, 0, 0},
+	} {
+		h, s, v := rgbToHSV(tt.r, tt.g, tt.b)
+		if math.Abs(h-tt.h) > 0.1 || math.Abs(s-tt.s) > 0.1 || math.Abs(v-tt.v) > 0.1 {
+			t.Errorf("rgbToHSV(%d, %d, %d) = %.1f, %.1f, %.1f, want %.1f, %.1f, %.1f", tt.r, tt.g, tt.b, h, s, v, tt.h, tt.s, tt.v)
+		}
+	}
+}
+
+func TestHSVToRGB(t *testing.T) {
+	for _, tt := range []struct {
+		h, s, v float64
+		r, g, b uint8
+	}{
+		{0, 100, 100, 255, 0, 0},
+		{120, 100, 100, 0, 255, 0},
+		{240, 100, 100, 0, 0, 255},
+		{360, 100, 100, 255, 0, 0},
+		{-120, 100, 100, 0, 0, 255},
+		{60, 50, 100, 255, 255, 128},
+		{200, 0, 50, 128, 128, 128},
+		{300, 100, 0, 0, 0, 0},
+	} {
+		if r, g, b := hsvToRGB(tt.h, tt.s, tt.v); r != tt.r || g != tt.g || b != tt.b {
+			t.Errorf("hsvToRGB(%v, %v, %v) = %d, %d, %d, want %d, %d, %d", tt.h, tt.s, tt.v, r, g, b, tt.r, tt.g, tt.b)
+		}
+	}
+}
+
+func TestRGBHSVRoundTrip(t *testing.T) {
+	check := func(name string, r, g, b uint8) {
+		h, s, v := rgbToHSV(r, g, b)
+		if r2, g2, b2 := hsvToRGB(h, s, v); r2 != r || g2 != g || b2 != b {
+			t.Errorf("%s (%d, %d, %d) came back as %d, %d, %d", name, r, g, b, r2, g2, b2)
+		}
+	}
+	for name, n := range cssColors {
+		check(name, uint8(n>>16), uint8(n>>8), uint8(n))
+	}
+	for r := 0; r < 256; r += 15 {
+		for g := 0; g < 256; g += 15 {
+			for b := 0; b < 256; b += 15 {
+				check("rgb", uint8(r), uint8(g), uint8(b))
+			}
+		}
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	for _, tt := range []struct {
+		s       string
+		r, g, b uint8
+	}{
+		{"#ff8800", 255, 136, 0},
+		{"#F80", 255, 136, 0},
+		{"255,136,0", 255, 136, 0},
+		{" 255, 136, 0 ", 255, 136, 0},
+		{"orange", 255, 165, 0},
+		{"RebeccaPurple", 102, 51, 153},
+	} {
+		r, g, b, err := parseColor(tt.s)
+		if err != nil || r != tt.r || g != tt.g || b != tt.b {
+			t.Errorf("parseColor(%q) = %d, %d, %d, %v", tt.s, r, g, b, err)
+		}
+	}
+	for _, s := range []string{"", "#ff88", "#gg8800", "256,0,0", "1,2", "notacolor"} {
+		if _, _, _, err := parseColor(s); err == nil {
+			t.Errorf("parseColor(%q) accepted", s)
+		}
+	}
+}
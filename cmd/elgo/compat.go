@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Compatible lights, such as clones and DIY firmware, may serve the same API
+// under another mDNS service or path.
+var (
+	serviceFlag  = flag.String("service", defaultService, "mDNS service types to discover, comma-separated (for compatible lights that advertise another)")
+	pathTemplate = flag.String("path-template", defaultPathTemplate, "path of the device API, with %s for the endpoint (e.g. lights), for compatible lights that serve it elsewhere")
+)
+
+// A deviceOverride is an entry in the devices section of the config file.
+type deviceOverride struct {
+	Service      string
+	PathTemplate string
+}
+
+var (
+	overridesOnce sync.Once
+	overrides     map[string]deviceOverride
+)
+
+// deviceOverrides returns the devices section of the config file, by
+// instance name, MAC or address, for profiles that mix genuine and
+// compatible lights:
+//
+//	devices:
+//	  Desk Clone:
+//	    service: _keylight._tcp
+//	    path-template: /api/%s
+func deviceOverrides() map[string]deviceOverride {
+	overridesOnce.Do(func() {
+		var err error
+		if overrides, err = loadOverrides(); err != nil {
+			fatal(err)
+		}
+	})
+	return overrides
+}
+
+func loadOverrides() (map[string]deviceOverride, error) {
+	raw, path, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+	section, ok := raw["devices"].(map[interface{}]interface{})
+	if raw["devices"] != nil && !ok {
+		return nil, fmt.Errorf("%s: devices: want device names and their settings", path)
+	}
+	out := map[string]deviceOverride{}
+	for k, v := range section {
+		name := fmt.Sprint(k)
+		fields, ok := v.(map[interface{}]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: devices: %s: want service and path-template", path, name)
+		}
+		var o deviceOverride
+		for fk, fv := range fields {
+			switch fmt.Sprint(fk) {
+			case "service":
+				o.Service = fmt.Sprint(fv)
+			case "path-template":
+				o.PathTemplate = fmt.Sprint(fv)
+				if err := checkPathTemplate(o.PathTemplate); err != nil {
+					return nil, fmt.Errorf("%s: devices: %s: %v", path, name, err)
+				}
+			default:
+				return nil, fmt.Errorf("%s: devices: %s: unknown setting %v", path, name, fk)
+			}
+		}
+		out[name] = o
+	}
+	return out, nil
+}
+
+// checkPathTemplate returns an error unless t is a path with one %s.
+func checkPathTemplate(t string) error {
+	if !strings.HasPrefix(t, "/") || strings.Count(t, "%s") != 1 || strings.Count(t, "%") != 1 {
+		return fmt.Errorf("%q: want a path with one %%s, e.g. %s", t, defaultPathTemplate)
+	}
+	return nil
+}
+
+// services returns the mDNS service types to discover: those of -service
+// and of the devices section of the config file.
+func services() []string {
+	var out []string
+	seen := map[string]bool{}
+	add := func(s string) {
+		s = strings.TrimSuffix(strings.TrimSpace(s), ".")
+		if s != "" && !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	for _, s := range strings.Split(*serviceFlag, ",") {
+		add(s)
+	}
+	for _, o := range deviceOverrides() {
+		add(o.Service)
+	}
+	return out
+}
+
+// deviceURL returns the URL of endpoint (e.g. lights) of the device at
+// hostName, under -path-template or the device's own in the config file.
+func deviceURL(hostName, endpoint string) string {
+	return "http://" + hostName + fmt.Sprintf(devicePathTemplate(hostName), endpoint)
+}
+
+func devicePathTemplate(hostName string) string {
+	o := deviceOverrides()
+	if len(o) == 0 {
+		return *pathTemplate
+	}
+	c := loadCache()
+	key := c.find(hostName)
+	d := device{Instance: c[key].Instance, HostName: hostName, MAC: parseMAC(key)}
+	for name, v := range o {
+		if v.PathTemplate != "" && d.matches(name) {
+			return v.PathTemplate
+		}
+	}
+	return *pathTemplate
+}
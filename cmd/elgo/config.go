@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+var configFile = flag.String("config", "", "YAML file of defaults for flags, by flag name (default elgo/config.yaml in the user config directory), layered over "+systemConfigPath)
+
+// systemConfigPath is the config file for all users of a machine, which
+// the user's own overrides.
+const systemConfigPath = "/etc/elgo/config.yaml"
+
+// configPath returns the path of the config file.
+func configPath() (string, error) {
+	if *configFile != "" {
+		return *configFile, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "elgo", "config.yaml"), nil
+}
+
+// configSections are the keys of the config file that aren't flags.
+var configSections = map[string]bool{
+	"scenes":  true, // see strip.go
+	"devices": true, // see compat.go
+	"hotkeys": true, // see hotkeys.go
+}
+
+// readConfig returns the contents of the system config file overlaid with
+// the user's, and the path of the last file read. Values in the user's file
+// replace the system's, except that entries in sections such as scenes are
+// added to the system's. Missing files are empty, unless given with -config.
+func readConfig() (map[string]interface{}, string, error) {
+	raw, err := readConfigFile(systemConfigPath, false)
+	if err != nil {
+		return nil, systemConfigPath, err
+	}
+	path, err := configPath()
+	if err != nil {
+		return nil, "", err
+	}
+	user, err := readConfigFile(path, *configFile != "")
+	if err != nil {
+		return nil, path, err
+	}
+	if user == nil {
+		if raw != nil {
+			path = systemConfigPath
+		}
+		return raw, path, nil
+	}
+	if raw == nil {
+		raw = map[string]interface{}{}
+	}
+	for k, v := range user {
+		section, ok := v.(map[interface{}]interface{})
+		sys, sysOK := raw[k].(map[interface{}]interface{})
+		if !configSections[k] || !ok || !sysOK {
+			raw[k] = v
+			continue
+		}
+		merged := map[interface{}]interface{}{}
+		for e, ev := range sys {
+			merged[e] = ev
+		}
+		for e, ev := range section {
+			merged[e] = ev
+		}
+		raw[k] = merged
+	}
+	return raw, path, nil
+}
+
+// readConfigFile returns the contents of the config file at path, or nil
+// if it is missing and not required.
+func readConfigFile(path string, required bool) (map[string]interface{}, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !required {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return raw, nil
+}
+
+// loadConfig returns the flag values in the config file, by flag name, and
+// the file's path.
+func loadConfig() (map[string]string, string, error) {
+	raw, path, err := readConfig()
+	if err != nil {
+		return nil, path, err
+	}
+	values := map[string]string{}
+	for k, v := range raw {
+		if configSections[k] {
+			continue
+		}
+		switch v.(type) {
+		case nil:
+			continue
+		case map[interface{}]interface{}, []interface{}:
+			return nil, path, fmt.Errorf("%s: %s: want a single value", path, k)
+		}
+		values[k] = fmt.Sprint(v)
+	}
+	return values, path, nil
+}
+
+// fromConfig holds the names of flags set from the config file.
+var fromConfig = map[string]bool{}
+
+// applyConfig sets flags not given on the command line from the config
+// file. It must be called after flag.Parse and before setupLogging, so that
+// the file can set the -log flags. applyEnv then overrides these values
+// with any environment variables.
+func applyConfig() {
+	values, path, err := loadConfig()
+	if err != nil {
+		fatal(err)
+	}
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for name, v := range values {
+		if flag.Lookup(name) == nil || name == "config" {
+			cmdLog.warnf("%s: unknown flag %s ignored", path, name)
+			continue
+		}
+		if set[name] {
+			continue
+		}
+		if err := flag.Set(name, v); err != nil {
+			fatal(fmt.Errorf("%s: %s: %v", path, name, err))
+		}
+		fromConfig[name] = true
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// copyLight copies the brightness and temperature (or color) of the first
+// light of one device to every light of others, leaving their power alone
+// unless -include-power is given.
+func copyLight(args []string) {
+	fs := flag.NewFlagSet("copy", flag.ExitOnError)
+	from := fs.String("from", "", "name, address or MAC of the device to copy from")
+	to := fs.String("to", "all", "comma-separated names, addresses or MACs of the devices to copy to, or all for every other device")
+	includePower := fs.Bool("include-power", false, "copy whether the light is on too")
+	fs.Parse(args)
+	if *from == "" || fs.NArg() > 0 {
+		fatal(errors.New("usage: elgo copy -from DEVICE [-to DEVICE,...|all] [-include-power]"))
+	}
+
+	found := discoverAll()
+	longRunning = true
+	var src *device
+	for i := range found {
+		if found[i].matches(*from) {
+			src = &found[i]
+			break
+		}
+	}
+	if src == nil {
+		fatal(fmt.Errorf("no device %q found", *from))
+	}
+	var targets []device
+	if *to == "all" {
+		for _, d := range found {
+			if d.HostName != src.HostName {
+				targets = append(targets, d)
+			}
+		}
+	} else {
+		for _, name := range strings.Split(*to, ",") {
+			name = strings.TrimSpace(name)
+			n := len(targets)
+			for _, d := range found {
+				if d.matches(name) && d.HostName != src.HostName {
+					targets = append(targets, d)
+					break
+				}
+			}
+			if len(targets) == n {
+				fatal(fmt.Errorf("no device %q found to copy to", name))
+			}
+		}
+	}
+	if len(targets) == 0 {
+		fatal(errors.New("no other devices to copy to"))
+	}
+
+	s := getState(src.HostName)
+	if len(s.Lights) == 0 {
+		fatal(fmt.Errorf("%s: device reported no lights", src.Instance))
+	}
+	want := s.Lights[0]
+	failed := false
+	for _, d := range targets {
+		cur, err := fetchState(d.HostName)
+		if err == nil {
+			for i := range cur.Lights {
+				cur.Lights[i] = copiedLight(want, cur.Lights[i], *includePower)
+			}
+			_, err = tryPutState(d.HostName, cur)
+		}
+		if err != nil {
+			cmdLog.warnf("%s: %v", d.Instance, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s: updated\n", d.Instance)
+	}
+	if failed {
+		exit(1)
+	}
+}
+
+// copiedLight returns cur with the brightness and temperature, or color if
+// both have color, of want, and its power too if withPower. Fields elgo
+// doesn't know are left as cur has them.
+func copiedLight(want, cur light, withPower bool) light {
+	l := cur
+	l.Brightness, l.Temperature = want.Brightness, want.Temperature
+	l.Hue, l.Saturation = nil, nil
+	if withPower {
+		l.On = want.On
+	}
+	if inColor(want) && hasColor(cur) {
+		l.Hue, l.Saturation = want.Hue, want.Saturation
+	} else if l.Temperature == 0 {
+		// A color can't be copied to a light without one.
+		l.Temperature = cur.Temperature
+	}
+	return l
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/vsekhar/elgo"
+)
+
+var count = flag.Int("count", 0, "act on this many devices: stop discovery as soon as they are found, or carry on with those found by the timeout")
+var requireAll = flag.Bool("require-all", false, "with -count, fail if fewer devices are found")
+
+// discoverCount returns the first n devices found, or if there are fewer,
+// those found before the timeout. Missing devices are reported, and are an
+// error with -require-all. Acting on the devices gets the full -timeout.
+func discoverCount(n int) []device {
+	devs := make(chan device)
+	stop := make(chan struct{})
+	if err := browseMDNS(devs, stop); err != nil {
+		fatal(err)
+	}
+	var found []device
+	seen := map[string]bool{}
+	for d := range devs {
+		if seen[d.HostName] {
+			continue
+		}
+		seen[d.HostName] = true
+		discoveryLog.debugf("found %s at %s", d.Instance, d.HostName)
+		found = append(found, d)
+		if len(found) == n {
+			close(stop)
+			break
+		}
+	}
+	// Wait for browsing to stop, whether it was stopped or timed out.
+	for range devs {
+	}
+	start = time.Now()
+
+	if len(found) == 0 {
+		noDevice()
+	}
+	if len(found) < n {
+		msg := fmt.Sprintf("found %d of %d devices (%d missing) within %s", len(found), n, n-len(found), *timeout)
+		if *requireAll {
+			fatal(fmt.Errorf("%w: %s", elgo.ErrNoDeviceFound, msg))
+		}
+		discoveryLog.warnf("%s", msg)
+	}
+	sortDevices(found, *sortKey)
+	return found
+}
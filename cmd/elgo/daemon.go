@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// A tracked device is one the daemon polls, holding its last known state.
+type tracked struct {
+	dev device
+
+	mu       sync.Mutex
+	state    state
+	updated  time.Time // of the last successful read or write
+	err      error     // of the last refresh
+	serial   string    // from accessory-info, if known
+	onChange []func(old, new state, source string)
+
+	reachable   bool
+	onReachable []func(reachable bool, s state)
+}
+
+// Sources of state changes, for notifications.
+const (
+	sourceManual   = "manual"   // requested through elgo
+	sourceEnforce  = "enforce"  // corrected by enforce
+	sourceExternal = "external" // observed when polling, made outside elgo
+	sourceDaemon   = "daemon"   // the daemon started or stopped observing
+)
+
+// current returns the last known state of t and when it was observed.
+func (t *tracked) current() (state, time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state, t.updated
+}
+
+// observe records s as the current state of t, notifying listeners if it
+// changed.
+func (t *tracked) observe(s state, source string) {
+	t.mu.Lock()
+	old := t.state
+	changed := !reflect.DeepEqual(old, s)
+	t.state = s
+	t.updated = time.Now()
+	listeners := t.onChange
+	t.mu.Unlock()
+	if changed {
+		for _, f := range listeners {
+			f(old, s, source)
+		}
+	}
+}
+
+// log returns a logger for messages about t from component.
+func (t *tracked) log(component logger) logger {
+	return component.forDevice(t.dev.Instance, t.serial)
+}
+
+// lastError returns the error from the last refresh of t, if any.
+func (t *tracked) lastError() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// refresh fetches the state of t from the device.
+func (t *tracked) refresh() error {
+	s, err := fetchState(t.dev.HostName)
+	if err == errStopping {
+		return err
+	}
+	t.mu.Lock()
+	t.err = err
+	changed := t.reachable != (err == nil)
+	t.reachable = err == nil
+	listeners := t.onReachable
+	t.mu.Unlock()
+	if err == nil {
+		t.observe(s, sourceExternal)
+	}
+	if changed {
+		for _, f := range listeners {
+			f(err == nil, s)
+		}
+	}
+	return err
+}
+
+// set writes l to the first light of the device.
+func (t *tracked) set(l light) (state, error) {
+	s, err := sendState(t.dev.HostName, state{NumberOfLights: 1, Lights: []light{l}})
+	if err != nil {
+		return state{}, err
+	}
+	t.observe(s, sourceManual)
+	return s, nil
+}
+
+// toggle flips the power of the first light of the device.
+func (t *tracked) toggle() (state, error) {
+	if err := t.refresh(); err != nil {
+		return state{}, err
+	}
+	s, _ := t.current()
+	if len(s.Lights) == 0 {
+		return state{}, errNoLights
+	}
+	l := light{On: 1}
+	if s.Lights[0].On != 0 {
+		l.On = 0
+	}
+	return t.set(l)
+}
+
+// poll refreshes t every interval until shutdown.
+func (t *tracked) poll(interval time.Duration) {
+	for ok := true; ok; ok = sleepOrStop(interval) {
+		if err := t.refresh(); err != nil && err != errStopping {
+			t.log(daemonLog).errorf("%v", err)
+		}
+	}
+}
+
+// daemon polls every device found during discovery and exports them over
+// the enabled interfaces until interrupted. It is run as "daemon", "serve"
+// and "mqtt", which differ only in which interface is on by default: none,
+// the HTTP API, or an MQTT bridge, whose flags drop their mqtt- prefix.
+func daemon(name string, args []string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	interval := fs.Duration("interval", 2*time.Second, "polling interval")
+	defaultListen := ""
+	if name == "serve" {
+		defaultListen = "localhost:9124"
+	}
+	listen := fs.String("listen", defaultListen, "serve the HTTP API on this address")
+	grpcListen := fs.String("grpc-listen", "", "serve the gRPC API (see package daemonpb) on this address")
+	dbus := fs.Bool("dbus", false, "export devices on the D-Bus session bus (Linux only)")
+	notify := fs.Bool("notify", false, "show a desktop notification for each change")
+	readyAfter := fs.Duration("ready-threshold", 0, "report not ready on /readyz once every device has been unreachable this long (default 3 polling intervals)")
+	readyEmpty := fs.Bool("ready-without-devices", false, "run and report ready on /readyz even if no devices are found")
+	triggerList := fs.String("triggers", "", "serve GET /trigger/ endpoints on -listen for these actions: a comma-separated list of toggle, on, off, preset and preset/NAME")
+	keepHistory := fs.Bool("history", true, "append every observed change to the history log")
+	retention := fs.Int("history-retention-days", 90, "on startup, remove history entries older than this many days (0 to keep everything)")
+	minInterval := fs.Duration("min-interval", *minWriteInterval, "leave at least this long between writes to a device; writes requested meanwhile are coalesced into the latest")
+	authReads := fs.Bool("auth-reads", false, "require -api-token for reads from the HTTP API too (except /healthz)")
+	noUI := fs.Bool("no-ui", false, "don't serve the web dashboard at / on -listen")
+	corsOrigin := fs.String("cors-origin", "", "let web pages from this origin (or * for any) use the HTTP API")
+	drain := fs.Duration("drain", 5*time.Second, "on SIGINT or SIGTERM, wait this long for in-flight requests")
+	mqttFlag := "mqtt-"
+	if name == "mqtt" {
+		mqttFlag = ""
+	}
+	hostName, _ := os.Hostname()
+	broker := fs.String(mqttFlag+"broker", "", "bridge devices to this MQTT broker (e.g. tcp://localhost:1883)")
+	mqttPrefix := fs.String(mqttFlag+"topic-prefix", "elgo", "prefix of MQTT topics")
+	discoveryPrefix := fs.String(mqttFlag+"discovery-prefix", "homeassistant", "prefix of Home Assistant MQTT discovery topics")
+	clientID := fs.String(mqttFlag+"client-id", "elgo-"+hostName, "MQTT client ID")
+	fs.Parse(args)
+	if name == "mqtt" && *broker == "" {
+		fatal(errors.New("mqtt needs -broker"))
+	}
+	if *readyAfter == 0 {
+		*readyAfter = 3 * *interval
+	}
+	// Clients such as Stream Deck dials send bursts of writes, which would
+	// otherwise queue up behind the device.
+	coalesceWrites = true
+	*minWriteInterval = *minInterval
+	triggers, err := parseTriggers(*triggerList)
+	if err != nil {
+		fatal(err)
+	}
+	if len(triggers) > 0 && *listen == "" {
+		fatal(errors.New("-triggers needs -listen"))
+	}
+	token, err := apiToken()
+	if err != nil {
+		fatal(err)
+	}
+	if *authReads && token == "" {
+		fatal(errors.New("-auth-reads needs -api-token or -api-token-file"))
+	}
+
+	// Reload presets on SIGHUP. Until they are fixed, bad presets are
+	// logged and left out.
+	config := &liveConfig{cur: &daemonConfig{}}
+	if c, err := loadDaemonConfig(); err != nil {
+		daemonLog.errorf("%v", err)
+	} else {
+		config.cur = c
+	}
+	go reloadOnHangup(config)
+
+	if *keepHistory && *retention > 0 {
+		if err := pruneHistory(time.Duration(*retention) * 24 * time.Hour); err != nil {
+			daemonLog.warnf("history: %v", err)
+		}
+	}
+
+	var found []device
+	if *readyEmpty {
+		found = discoverAny()
+	} else {
+		found = discoverAll()
+	}
+	longRunning = true
+	var devices []*tracked
+	for _, d := range found {
+		t := &tracked{dev: d}
+		if info, err := fetchAccessoryInfo(d.HostName); err == nil {
+			t.serial = info.SerialNumber
+		}
+		if *keepHistory {
+			t.onChange = append(t.onChange, func(old, new state, source string) {
+				appendHistory(diffStates(t.dev, old, new), source)
+			})
+			t.onReachable = append(t.onReachable, func(reachable bool, s state) {
+				appendHistory(observed(t.dev, reachable, s), sourceDaemon)
+			})
+		}
+		// Let -assume-one-light toggles know whether the state elgo left a
+		// device in is still current.
+		t.onChange = append(t.onChange, func(old, new state, source string) {
+			noteChange(t.dev.HostName, new)
+		})
+		if *notify {
+			n := newNotifier()
+			t.onChange = append(t.onChange, func(old, new state, source string) {
+				n.events(diffStates(t.dev, old, new), source)
+			})
+		}
+		if err := t.refresh(); err != nil {
+			t.log(daemonLog).errorf("%v", err)
+		}
+		devices = append(devices, t)
+	}
+	var cleanup []func(ctx context.Context)
+	if *dbus {
+		release, err := exportDBus(devices)
+		if err != nil {
+			fatal(err)
+		}
+		cleanup = append(cleanup, func(context.Context) { release() })
+	}
+	for _, t := range devices {
+		go t.poll(*interval)
+	}
+	if *keepHistory {
+		go func() {
+			for sleepOrStop(historyHeartbeat) {
+				for _, t := range devices {
+					t.mu.Lock()
+					reachable := t.reachable
+					t.mu.Unlock()
+					if reachable {
+						appendHistory(stillObserved(t.dev), sourceDaemon)
+					}
+				}
+			}
+		}()
+		cleanup = append(cleanup, func(context.Context) {
+			for _, t := range devices {
+				t.mu.Lock()
+				reachable := t.reachable
+				t.mu.Unlock()
+				if reachable {
+					appendHistory(observed(t.dev, false, state{}), sourceDaemon)
+				}
+			}
+		})
+	}
+	a := &api{
+		devices:        devices,
+		interval:       *interval,
+		readyThreshold: *readyAfter,
+		readyEmpty:     *readyEmpty,
+		triggers:       triggers,
+		config:         config,
+		token:          token,
+		authReads:      *authReads,
+		ui:             !*noUI,
+		corsOrigin:     *corsOrigin,
+	}
+	if *listen != "" || *grpcListen != "" {
+		a.events = newEventHub(a)
+	}
+	if *listen != "" {
+		srv := serveAPI(*listen, a)
+		cleanup = append(cleanup, func(ctx context.Context) {
+			if err := srv.Shutdown(ctx); err != nil {
+				apiLog.errorf("shutdown: %v", err)
+			}
+		})
+	}
+	if *grpcListen != "" {
+		srv := serveGRPC(*grpcListen, a)
+		cleanup = append(cleanup, func(ctx context.Context) { stopGRPC(ctx, srv) })
+	}
+	if *broker != "" {
+		b := startMQTT(*broker, *clientID, *mqttPrefix, *discoveryPrefix, devices)
+		cleanup = append(cleanup, func(context.Context) { b.stop() })
+	}
+	awaitShutdown(*drain, cleanup...)
+}
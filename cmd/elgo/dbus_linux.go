@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/godbus/dbus/v5/prop"
+)
+
+const (
+	dbusName  = "org.elgo"
+	dbusIface = "org.elgo.Light"
+	dbusPath  = "/org/elgo/Light"
+)
+
+var dbusUnsafe = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// dbusLight exports a tracked device as an org.elgo.Light object.
+type dbusLight struct {
+	t *tracked
+}
+
+func dbusErr(err error) *dbus.Error {
+	if err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
+func (l dbusLight) On() *dbus.Error {
+	_, err := l.t.set(light{On: 1})
+	return dbusErr(err)
+}
+
+func (l dbusLight) Off() *dbus.Error {
+	_, err := l.t.set(light{On: 0})
+	return dbusErr(err)
+}
+
+func (l dbusLight) Toggle() *dbus.Error {
+	_, err := l.t.toggle()
+	return dbusErr(err)
+}
+
+// power returns the current power of the first light, to leave it unchanged
+// when setting other properties.
+func (l dbusLight) power() int {
+	s, _ := l.t.current()
+	if len(s.Lights) == 0 {
+		return 0
+	}
+	return s.Lights[0].On
+}
+
+func (l dbusLight) SetBrightness(b uint32) *dbus.Error {
+	nl, err := limitLight(l.t.dev.HostName, int(b), 0)
+	if err != nil {
+		return dbusErr(err)
+	}
+	nl.On = l.power()
+	_, err = l.t.set(nl)
+	return dbusErr(err)
+}
+
+func (l dbusLight) SetTemperature(kelvin uint32) *dbus.Error {
+	nl, err := limitLight(l.t.dev.HostName, 0, int(kelvin))
+	if err != nil {
+		return dbusErr(err)
+	}
+	nl.On = l.power()
+	_, err = l.t.set(nl)
+	return dbusErr(err)
+}
+
+// dbusProps returns the D-Bus property values for s.
+func dbusProps(s state) map[string]interface{} {
+	l := light{}
+	if len(s.Lights) > 0 {
+		l = s.Lights[0]
+	}
+	kelvin := uint32(0)
+	if l.Temperature != 0 {
+		kelvin = uint32(toKelvin(l.Temperature))
+	}
+	return map[string]interface{}{
+		"On":          l.On != 0,
+		"Brightness":  uint32(l.Brightness),
+		"Temperature": kelvin,
+	}
+}
+
+// exportDBus exports an org.elgo.Light object per device on the session bus
+// and emits PropertiesChanged whenever a device's state changes. It returns
+// a function that releases the bus name.
+func exportDBus(devices []*tracked) (func(), error) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.RequestName(dbusName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return nil, err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return nil, fmt.Errorf("D-Bus name %s already taken", dbusName)
+	}
+
+	for _, t := range devices {
+		path := dbus.ObjectPath(dbusPath + "/" + dbusUnsafe.ReplaceAllString(t.dev.Instance, "_"))
+		if err := conn.Export(dbusLight{t}, path, dbusIface); err != nil {
+			return nil, err
+		}
+
+		s, _ := t.current()
+		props := map[string]*prop.Prop{
+			"Name": {Value: t.dev.Instance, Emit: prop.EmitConst},
+			"Host": {Value: t.dev.HostName, Emit: prop.EmitConst},
+		}
+		for k, v := range dbusProps(s) {
+			props[k] = &prop.Prop{Value: v, Emit: prop.EmitTrue}
+		}
+		p, err := prop.Export(conn, path, map[string]map[string]*prop.Prop{dbusIface: props})
+		if err != nil {
+			return nil, err
+		}
+
+		node := &introspect.Node{
+			Name: string(path),
+			Interfaces: []introspect.Interface{
+				introspect.IntrospectData,
+				prop.IntrospectData,
+				{
+					Name:       dbusIface,
+					Methods:    introspect.Methods(dbusLight{}),
+					Properties: p.Introspection(dbusIface),
+				},
+			},
+		}
+		if err := conn.Export(introspect.NewIntrospectable(node), path, "org.freedesktop.DBus.Introspectable"); err != nil {
+			return nil, err
+		}
+
+		mu := &sync.Mutex{}
+		prev := dbusProps(s)
+		t.mu.Lock()
+		t.onChange = append(t.onChange, func(_, s state, _ string) {
+			mu.Lock()
+			defer mu.Unlock()
+			for k, v := range dbusProps(s) {
+				if prev[k] != v {
+					p.SetMust(dbusIface, k, v)
+				}
+			}
+			prev = dbusProps(s)
+		})
+		t.mu.Unlock()
+	}
+	return func() { conn.ReleaseName(dbusName) }, nil
+}
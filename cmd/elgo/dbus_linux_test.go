@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// privateSessionBus starts a session bus for the rest of the test and
+// points DBUS_SESSION_BUS_ADDRESS at it.
+func privateSessionBus(t *testing.T) string {
+	t.Helper()
+	path, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("no dbus-daemon")
+	}
+	cmd := exec.Command(path, "--session", "--nofork", "--print-address")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	addr, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr = strings.TrimSpace(addr)
+	old, had := os.LookupEnv("DBUS_SESSION_BUS_ADDRESS")
+	os.Setenv("DBUS_SESSION_BUS_ADDRESS", addr)
+	t.Cleanup(func() {
+		if had {
+			os.Setenv("DBUS_SESSION_BUS_ADDRESS", old)
+		} else {
+			os.Unsetenv("DBUS_SESSION_BUS_ADDRESS")
+		}
+	})
+	return addr
+}
+
+func TestDBusLight(t *testing.T) {
+	privateSessionBus(t)
+	sim := testLight(false)
+	tr := &tracked{dev: device{Instance: "Key Light", HostName: fakeDevice(t, sim)}}
+	if err := tr.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	release, err := exportDBus([]*tracked{tr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	conn, err := dbus.SessionBusPrivate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.Auth(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Hello(); err != nil {
+		t.Fatal(err)
+	}
+	match := "type='signal',interface='org.freedesktop.DBus.Properties',member='PropertiesChanged'"
+	if err := conn.BusObject().Call("org.freedesktop.DBus.AddMatch", 0, match).Err; err != nil {
+		t.Fatal(err)
+	}
+	signals := make(chan *dbus.Signal, 10)
+	conn.Signal(signals)
+
+	obj := conn.Object(dbusName, dbusPath+"/Key_Light")
+	if err := obj.Call(dbusIface+".On", 0).Err; err != nil {
+		t.Fatal(err)
+	}
+	if s := sim.lightState(); s.Lights[0].On != 1 {
+		t.Errorf("light is off after On: %+v", s)
+	}
+	v, err := obj.GetProperty(dbusIface + ".On")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if on, _ := v.Value().(bool); !on {
+		t.Errorf("On property is %v after On", v)
+	}
+	select {
+	case sig := <-signals:
+		if len(sig.Body) < 2 {
+			t.Fatalf("PropertiesChanged without changes: %v", sig.Body)
+		}
+		changed, _ := sig.Body[1].(map[string]dbus.Variant)
+		if on, _ := changed["On"].Value().(bool); !on {
+			t.Errorf("PropertiesChanged %v, want On true", changed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("no PropertiesChanged after On")
+	}
+
+	if err := obj.Call(dbusIface+".SetBrightness", 0, uint32(60)).Err; err != nil {
+		t.Fatal(err)
+	}
+	if s := sim.lightState(); s.Lights[0].Brightness != 60 || s.Lights[0].On != 1 {
+		t.Errorf("after SetBrightness(60): %+v", s)
+	}
+	if err := obj.Call(dbusIface+".SetBrightness", 0, uint32(150)).Err; err == nil {
+		t.Error("SetBrightness(150) succeeded")
+	}
+}
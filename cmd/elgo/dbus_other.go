@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+func exportDBus(devices []*tracked) (func(), error) {
+	return nil, errors.New("D-Bus is only supported on Linux")
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// sortDevices sorts devices by key: "name" sorts by instance name then IP,
+// "ip" by IP then instance name, and "none" leaves them in discovery order.
+// Devices with unknown IPs sort last by IP.
+func sortDevices(devices []device, key string) {
+	byName := func(a, b device) int {
+		return strings.Compare(strings.ToLower(a.Instance), strings.ToLower(b.Instance))
+	}
+	byIP := func(a, b device) int {
+		switch {
+		case a.IP == nil && b.IP == nil:
+			return 0
+		case a.IP == nil:
+			return 1
+		case b.IP == nil:
+			return -1
+		}
+		return bytes.Compare(a.IP.To16(), b.IP.To16())
+	}
+	var keys []func(a, b device) int
+	switch key {
+	case "name":
+		keys = append(keys, byName, byIP)
+	case "ip":
+		keys = append(keys, byIP, byName)
+	default:
+		return
+	}
+	sort.SliceStable(devices, func(i, j int) bool {
+		for _, k := range keys {
+			if c := k(devices[i], devices[j]); c != 0 {
+				return c < 0
+			}
+		}
+		return devices[i].HostName < devices[j].HostName
+	})
+}
+
+// A discovered device is a line of elgo discover -json.
+type discovered struct {
+	Index    int      `json:"index"`
+	Name     string   `json:"name"`
+	Host     string   `json:"host"`
+	IP       string   `json:"ip,omitempty"`
+	MAC      string   `json:"mac,omitempty"`
+	Model    string   `json:"model,omitempty"`
+	Serial   string   `json:"serial,omitempty"`
+	Firmware string   `json:"firmware,omitempty"`
+	Battery  *float64 `json:"battery,omitempty"` // level in percent, with -battery
+}
+
+// discover lists every device found before the timeout, with the serial
+// number and firmware version of those that answer and, with -battery,
+// their battery levels. With -stream, each is listed as soon as it is
+// found rather than in -sort order at the end.
+func discover(args []string) {
+	fs := flag.NewFlagSet("discover", flag.ExitOnError)
+	showBattery := fs.Bool("battery", false, "add a column with the battery level of battery-powered devices")
+	stream := fs.Bool("stream", false, "list each device as it is found")
+	asJSON := jsonFlag(fs, "print each device as a line of JSON")
+	fs.Parse(args)
+
+	list := func(i int, d device, info accessoryInfo, b *batteryInfo) {
+		r := discovered{
+			Index:    i,
+			Name:     d.Instance,
+			Host:     d.HostName,
+			MAC:      d.MAC,
+			Model:    d.Model,
+			Serial:   info.SerialNumber,
+			Firmware: info.FirmwareVersion,
+		}
+		if d.IP != nil {
+			r.IP = d.IP.String()
+		}
+		if r.Model == "" {
+			r.Model = info.ProductName
+		}
+		if b != nil {
+			r.Battery = &b.Level
+		}
+		if *asJSON {
+			if err := json.NewEncoder(os.Stdout).Encode(r); err != nil {
+				fatal(err)
+			}
+			return
+		}
+		line := fmt.Sprintf("%d\t%s\t%s\t%s\t%s\t%s\t%s", i, r.Name, r.Host, orDash(r.IP), orDash(r.MAC), orDash(r.Serial), orDash(r.Firmware))
+		if *showBattery {
+			level := "-"
+			if b != nil {
+				level = fmt.Sprintf("%.0f%%", b.Level)
+			}
+			line += "\t" + level
+		}
+		fmt.Println(line)
+	}
+
+	if *stream {
+		streamDiscovery(*showBattery, list)
+		return
+	}
+	found := discoverAll()
+	// Discovery takes the whole timeout, so give each device its own.
+	longRunning = true
+	infos := fetchAllInfo(found)
+	recordInfos(found, infos)
+	batteries := make([]*batteryInfo, len(found))
+	if *showBattery {
+		batteries = fetchAllBatteries(found, infos)
+	}
+	for i, d := range found {
+		list(i, d, infos[i], batteries[i])
+	}
+}
+
+// streamDiscovery calls list for each device as soon as it is found and
+// has answered (or failed to answer) for its details, until discovery
+// completes or the timeout expires.
+func streamDiscovery(withBattery bool, list func(int, device, accessoryInfo, *batteryInfo)) {
+	var found []device
+	if *host != "" || *waitFor > 0 {
+		found = discoverAll()
+	}
+	// Devices found near the end of discovery still get a full timeout.
+	longRunning = true
+	devs := make(chan device)
+	if found != nil {
+		go func() {
+			for _, d := range found {
+				devs <- d
+			}
+			close(devs)
+		}()
+	} else if err := browseMDNS(devs, nil); err != nil {
+		fatal(err)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var listed []device
+	var infos []accessoryInfo
+	seen := map[string]bool{}
+	for d := range devs {
+		if seen[d.HostName] {
+			continue
+		}
+		seen[d.HostName] = true
+		wg.Add(1)
+		go func(d device) {
+			defer wg.Done()
+			info, err := fetchAccessoryInfo(d.HostName)
+			if err != nil {
+				discoveryLog.debugf("%s: %v", d.HostName, err)
+			}
+			var b *batteryInfo
+			if withBattery {
+				b = fetchBatteryOf(d, info)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			list(len(listed), d, info, b)
+			listed = append(listed, d)
+			infos = append(infos, info)
+		}(d)
+	}
+	wg.Wait()
+	if len(listed) == 0 {
+		noDevice()
+	}
+	recordInfos(listed, infos)
+}
+
+// recordInfos remembers the firmware versions in infos, of devices, for
+// elgo info to compare against.
+func recordInfos(devices []device, infos []accessoryInfo) {
+	c := loadCache()
+	for i, d := range devices {
+		if infos[i].FirmwareVersion != "" {
+			c.recordInfo(d.key(), infos[i])
+		}
+	}
+}
+
+// orDash returns s, or "-" if it is empty.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
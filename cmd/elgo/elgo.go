@@ -2,63 +2,428 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/oleksandr/bonjour"
+	"github.com/vsekhar/elgo"
 )
 
-var brightness = flag.Uint("brightness", 0, "set brightness (between 1 and 100)")
-var temperature = flag.Uint("temperature", 0, "set color temperature (between 2900 (reddish) and 7000 (blueish)")
-var verbose = flag.Bool("v", false, "enable verbose output")
-var timeout = flag.Duration("timeout", 10*time.Second, "timeout (default 10s)")
+var brightness = settingFlag("brightness", 1, 100, "set brightness (between 1 and 100 on most devices), or change it by an amount (e.g. +10) or by a percentage of that range (e.g. -10%)")
+var temperature = settingFlag("temperature", 2900, 7000, "set color temperature between 2900 (reddish) and 7000 (blueish) on most devices, or change it by Kelvins (e.g. +500) or by a percentage of that range (e.g. +10% is 410K cooler)")
+var rawTemperature = flag.Uint("raw-temperature", 0, "set color temperature in device units as shown in responses (between 143 (blueish) and 344 (reddish) on most devices)")
+var verbose = flag.Bool("v", false, "log discovery and a summary of each device request to stderr")
+var veryVerbose = flag.Bool("vv", false, "also log the body and timing of each device request (implies -v)")
+var timeout = flag.Duration("timeout", 10*time.Second, "overall timeout, including discovery (default 10s)")
+var requestTimeoutFlag = flag.Duration("request-timeout", 5*time.Second, "timeout for each device request, within the overall -timeout")
+var host = flag.String("host", "", "address (host or host:port) of the light, skipping discovery, or its MAC address")
+var record = flag.String("record", "", "record device requests and responses to this cassette file")
+var replay = flag.String("replay", "", "serve device responses from this cassette file instead of a real device")
+var lightIndex = flag.Int("light-index", 0, "index of the light to control on devices with several")
+var lightID = flag.String("light-id", "", "ID of the light to control on devices with several (if reported by the device)")
+var sortKey = flag.String("sort", "name", "order of discovered devices: name (then IP), ip (then name) or none (discovery order)")
+var deviceIndex = flag.Int("device-index", -1, "act on the device at this index in -sort order, as listed by elgo discover, instead of -host")
+var lockPower = flag.Bool("lock-power", false, "never change whether the light is on, only its brightness and temperature")
+var pipeline = flag.Bool("parallel-discovery-then-act", false, "act on every device as soon as it is discovered, until the timeout")
 
 // From: https://help.elgato.com/hc/en-us/articles/4413403384845-mDNS-Service-Strings-for-Elgato-Devices
-const service = "_elg._tcp"
+const defaultService = "_elg._tcp"
+
+// defaultPort is the port Elgato lights serve their API on.
+const defaultPort = "9123"
+
+// envFlags lists the environment variables that provide defaults for flags
+// not given on the command line.
+var envFlags = []struct {
+	flag, env string
+	secret    bool // not to be logged
+}{
+	{"vv", "ELGO_DEBUG", false},
+	{"host", "ELGO_HOST", false},
+	{"brightness", "ELGO_BRIGHTNESS", false},
+	{"temperature", "ELGO_TEMPERATURE", false},
+	{"api-token", "ELGO_API_TOKEN", true},
+}
+
+// fromEnv holds the names of flags set from environment variables.
+var fromEnv = map[string]bool{}
+
+// onCommandLine reports whether any of the flags named were given on the
+// command line, rather than set from the config file or environment.
+func onCommandLine(names ...string) bool {
+	given := false
+	flag.Visit(func(f *flag.Flag) {
+		for _, name := range names {
+			if f.Name == name && !fromConfig[name] && !fromEnv[name] {
+				given = true
+			}
+		}
+	})
+	return given
+}
+
+// applyEnv sets flags not given on the command line from their environment
+// variables. It must be called after flag.Parse and applyConfig and before
+// flags are validated, so that environment values are validated the same
+// way.
+func applyEnv() {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = !fromConfig[f.Name]
+	})
+	for _, e := range envFlags {
+		source := "default"
+		if set[e.flag] {
+			source = "flag"
+		} else if v, ok := os.LookupEnv(e.env); ok {
+			if err := flag.Set(e.flag, v); err != nil {
+				fatal(fmt.Errorf("%s: %v", e.env, err))
+			}
+			source = e.env
+			fromEnv[e.flag] = true
+		} else if fromConfig[e.flag] {
+			source = "config"
+		}
+		if *veryVerbose {
+			*verbose = true
+		}
+		v := fmt.Sprintf("%q", flag.Lookup(e.flag).Value)
+		if e.secret && source != "default" {
+			v = "(redacted)"
+		}
+		cmdLog.debugf("%s: %s (from %s)", e.flag, v, source)
+	}
+}
+
+// resolveMACHost replaces a MAC address given as -host with the address of
+// the device with that MAC, found via mDNS or, failing that, in the host
+// cache.
+func resolveMACHost() {
+	mac := parseMAC(*host)
+	if mac == "" {
+		return
+	}
+	*host = ""
+	devs := make(chan device)
+	stop := make(chan struct{})
+	if err := browseMDNS(devs, stop); err == nil {
+		for d := range devs {
+			if d.MAC == mac && *host == "" {
+				*host = d.HostName
+				close(stop)
+			}
+		}
+	}
+	if *host == "" {
+		c := loadCache()
+		if _, ok := c[mac]; ok {
+			*host = c.hostName(mac)
+			noteDiscovery("cache", 0)
+			discoveryLog.debugf("%s: using cached address %s", mac, *host)
+		}
+	}
+	if *host == "" {
+		fatal(fmt.Errorf("%w with MAC address %s", elgo.ErrNoDeviceFound, mac))
+	}
+	discoveryLog.debugf("%s is at %s", mac, *host)
+}
+
+// resolveDeviceIndex sets -host to the address of the device at
+// -device-index among those discovered, in -sort order.
+func resolveDeviceIndex() {
+	if *deviceIndex < 0 {
+		return
+	}
+	if *host != "" {
+		fatal(errors.New("-device-index and -host are mutually exclusive"))
+	}
+	found := discoverAny()
+	if *deviceIndex >= len(found) {
+		var names []string
+		for i, d := range found {
+			names = append(names, fmt.Sprintf("%d %s", i, d.Instance))
+		}
+		fatal(fmt.Errorf("%w at index %d: found %d (%s)", elgo.ErrNoDeviceFound, *deviceIndex, len(found), strings.Join(names, ", ")))
+	}
+	*host = found[*deviceIndex].HostName
+	// Discovery takes the whole timeout, so act with a fresh one.
+	start = time.Now()
+	discoveryLog.debugf("device %d is %s at %s", *deviceIndex, found[*deviceIndex].Instance, *host)
+}
+
+// hostAddr returns the -host address with the default port added if needed.
+func hostAddr() string {
+	if _, _, err := net.SplitHostPort(*host); err == nil {
+		return *host
+	}
+	return net.JoinHostPort(*host, defaultPort)
+}
 
 // From: https://groups.google.com/a/google.com/g/spend-1000-discuss/c/lAFjaEU4GAA/m/ccK6t_KCBwAJ
-const urlTemplate = "http://%s/elgato/lights"
+const defaultPathTemplate = "/elgato/%s"
 
-func getMDNS() (hostName string, err error) {
-	wg := &sync.WaitGroup{}
+// lightsEndpoint is the API endpoint of the lights' state, under the path
+// template.
+const lightsEndpoint = "lights"
+
+// device is a light found via mDNS.
+type device struct {
+	Instance string
+	HostName string // host:port
+	IP       net.IP // nil if unknown
+	MAC      string // from the mDNS TXT record, as by parseMAC; empty if unknown
+	Model    string // from the mDNS TXT record (e.g. Elgato Key Light Air); empty if unknown
+}
+
+// key identifies d in persistent state: by MAC if known, since unlike its
+// address that survives DHCP changes, and otherwise by address.
+func (d device) key() string {
+	if d.MAC != "" {
+		return d.MAC
+	}
+	return d.HostName
+}
+
+// matches reports whether name is d's instance name, address or MAC,
+// ignoring case.
+func (d device) matches(name string) bool {
+	return strings.EqualFold(d.Instance, name) || strings.EqualFold(d.HostName, name) ||
+		d.MAC != "" && parseMAC(name) == d.MAC
+}
+
+// parseMAC returns s in the canonical form of a 48-bit MAC address (e.g.
+// 3c:6a:9d:12:34:56), or "" if it isn't one.
+func parseMAC(s string) string {
+	mac, err := net.ParseMAC(s)
+	if err != nil || len(mac) != 6 {
+		return ""
+	}
+	return mac.String()
+}
+
+// txtField returns the value of field name in an mDNS TXT record, or "".
+func txtField(txt []string, name string) string {
+	for _, t := range txt {
+		if strings.HasPrefix(t, name+"=") {
+			return strings.TrimPrefix(t, name+"=")
+		}
+	}
+	return ""
+}
+
+// txtMAC returns the MAC address advertised in the id field of an Elgato
+// mDNS TXT record.
+func txtMAC(txt []string) string {
+	return parseMAC(txtField(txt, "id"))
+}
+
+// A resolver browses for a service, sending each entry it finds on entries
+// until it exits. Like bonjour.Resolver, it may only take the exit between
+// sends.
+type resolver interface {
+	Browse(service, domain string, entries chan<- *bonjour.ServiceEntry) error
+	exit()
+}
+
+type bonjourResolver struct {
+	*bonjour.Resolver
+}
+
+func (r bonjourResolver) exit() {
+	r.Exit <- true
+}
+
+// newResolver returns a resolver for browseMDNS. Tests replace it.
+var newResolver = func() (resolver, error) {
 	r, err := bonjour.NewResolver(nil)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	return bonjourResolver{r}, nil
+}
+
+// browseMDNS sends each device it finds on devs until stop is closed or the
+// timeout expires, then closes devs.
+func browseMDNS(devs chan<- device, stop <-chan struct{}) error {
+	// Each service needs its own resolver, which only browses once.
 	svcs := make(chan *bonjour.ServiceEntry)
-	wg.Add(1)
+	var resolvers []resolver
+	// exit stops the resolvers, passing any entries they send meanwhile to
+	// found, if not nil. A resolver only takes its Exit between sends, and
+	// svcs is unbuffered, so without draining it one blocked sending an
+	// entry would never stop, and nor would exit.
+	exit := func(found func(*bonjour.ServiceEntry)) {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for _, r := range resolvers {
+				r.exit()
+			}
+		}()
+		for {
+			select {
+			case svc := <-svcs:
+				if found != nil {
+					found(svc)
+				}
+			case <-done:
+				return
+			}
+		}
+	}
+	for _, s := range services() {
+		r, err := newResolver()
+		if err != nil {
+			exit(nil)
+			return browseFallback(devs, err)
+		}
+		if err := r.Browse(s, "", svcs); err != nil {
+			exit(nil)
+			return browseFallback(devs, err)
+		}
+		resolvers = append(resolvers, r)
+	}
+	began := time.Now()
 	go func() {
-		var svc *bonjour.ServiceEntry
-		select {
-		case svc = <-svcs:
-		case <-time.After(*timeout - time.Since(start)):
-			log.Fatalf("discovery timeout (%s)", *timeout)
+		defer close(devs)
+		defer func() { noteDiscovery("mDNS browse", time.Since(began)) }()
+		var seen []device
+		defer func() { rememberDevices(seen) }()
+		found := func(svc *bonjour.ServiceEntry) {
+			discoveryLog.debugf("service: %+v", svc)
+			d := device{
+				Instance: elgo.UnescapeInstance(svc.Instance),
+				HostName: fmt.Sprintf("%s:%d", svc.HostName, svc.Port),
+				IP:       svc.AddrIPv4,
+				MAC:      txtMAC(svc.Text),
+				Model:    txtField(svc.Text, "md"),
+			}
+			seen = append(seen, d)
+			devs <- d
 		}
-		if *verbose {
-			log.Printf("Service: %+v", svc)
+		deadline := time.After(remaining())
+		for {
+			select {
+			case svc := <-svcs:
+				found(svc)
+			case <-stop:
+				// The receiver may have stopped reading devs.
+				exit(nil)
+				return
+			case <-interrupted.Done():
+				exit(nil)
+				return
+			case <-deadline:
+				// Keep entries that arrived just in time.
+				exit(found)
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// browseFallback sends the devices from -scan or the host cache on devs, then
+// closes it, for when mDNS can't be used.
+func browseFallback(devs chan<- device, mdnsErr error) error {
+	found, err := fallbackDevices(mdnsErr)
+	if err != nil {
+		close(devs)
+		return err
+	}
+	go func() {
+		defer close(devs)
+		for _, d := range found {
+			devs <- d
 		}
-		hostName = fmt.Sprintf("%s:%d", svc.HostName, svc.Port)
-		r.Exit <- true
-		wg.Done()
 	}()
-	if err := r.Browse(service, "", svcs); err != nil {
+	return nil
+}
+
+func getMDNS() (hostName string, err error) {
+	devs := make(chan device)
+	stop := make(chan struct{})
+	if err := browseMDNS(devs, stop); err != nil {
 		return "", err
 	}
-	wg.Wait()
-	return hostName, nil
+	d, ok := <-devs
+	close(stop)
+	for range devs {
+	}
+	if !ok {
+		noDevice()
+	}
+	return d.HostName, nil
 }
 
 type light struct {
-	On          int `json:"on"` // 1 or 0, always include it
-	Brightness  int `json:"brightness,omitempty"`
-	Temperature int `json:"temperature,omitempty"`
+	ID          string `json:"id,omitempty"` // only reported by some firmware
+	On          int    `json:"on"`           // 1 or 0, always include it
+	Brightness  int    `json:"brightness,omitempty"`
+	Temperature int    `json:"temperature,omitempty"`
+
+	// Only devices with color, such as the Light Strip, report these.
+	Hue        *float64 `json:"hue,omitempty"`
+	Saturation *float64 `json:"saturation,omitempty"`
+
+	// extra holds fields elgo doesn't know, such as those of newer
+	// firmware, so that writing back a light read from a device keeps them.
+	extra map[string]json.RawMessage
+}
+
+// lightFields are the JSON names of the fields of light.
+var lightFields = []string{"id", "on", "brightness", "temperature", "hue", "saturation"}
+
+func (l *light) UnmarshalJSON(b []byte) error {
+	type plain light
+	var p plain
+	if err := json.Unmarshal(b, &p); err != nil {
+		return err
+	}
+	var extra map[string]json.RawMessage
+	if err := json.Unmarshal(b, &extra); err != nil {
+		return err
+	}
+	for _, f := range lightFields {
+		delete(extra, f)
+	}
+	*l = light(p)
+	if len(extra) > 0 {
+		l.extra = extra
+	}
+	return nil
+}
+
+func (l light) MarshalJSON() ([]byte, error) {
+	type plain light
+	b, err := json.Marshal(plain(l))
+	if err != nil || len(l.extra) == 0 {
+		return b, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(b, &all); err != nil {
+		return nil, err
+	}
+	for k, v := range l.extra {
+		if _, ok := all[k]; !ok {
+			all[k] = v
+		}
+	}
+	return json.Marshal(all)
 }
 
 type state struct {
@@ -66,6 +431,8 @@ type state struct {
 	Lights         []light `json:"lights"`
 }
 
+var errNoLights = errors.New("device reported no lights")
+
 // From: https://docs.google.com/spreadsheets/d/1QqLaonLxfAmD5vcyXd_9u8FkxbFoNYMQhOMk4lLZS5k/edit#gid=0
 const kelvinFactor = 1000000
 
@@ -76,139 +443,865 @@ func toKelvin(temp int) int {
 	return int(kelvinFactor / temp)
 }
 
-func getState(hostName string) state {
-	url := fmt.Sprintf(urlTemplate, hostName)
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		log.Fatal(err)
+// maxSnippet is how much of a response body to quote in errors.
+const maxSnippet = 200
+
+// snippet returns the start of the response body b for error messages.
+func snippet(b []byte) string {
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		return "(empty response)"
 	}
-	req.Header.Set("Content-Type", "application/json; charset=utf-8")
-	client := &http.Client{
-		Timeout: *timeout - time.Since(start),
+	if len(s) > maxSnippet {
+		return s[:maxSnippet] + "..."
 	}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Fatal(err)
+	return s
+}
+
+// requestLogger returns requestLog for the device at hostName, by its name
+// if known.
+func requestLogger(hostName string) logger {
+	if !*verbose {
+		return requestLog
+	}
+	c := loadCache()
+	name := c[c.find(hostName)].Instance
+	if name == "" {
+		name = hostName
 	}
-	respJson, err := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
+	return requestLog.forDevice(name, "")
+}
+
+// badJSON returns an error for a response b from the device at hostName
+// that didn't decode, with err, as the JSON expected of what.
+func badJSON(hostName, what string, b []byte, err error) error {
+	return fmt.Errorf("%s: %s: %w", hostName, what, &elgo.BadResponseError{Body: b, Err: err})
+}
+
+// transport is used for all device requests. It keeps a connection or two
+// to each device alive between requests, as opening another is slow and
+// the lights' small HTTP stacks sometimes drop it.
+var transport http.RoundTripper = &http.Transport{
+	Proxy:               http.ProxyFromEnvironment,
+	DialContext:         (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+	MaxIdleConnsPerHost: 2,
+	IdleConnTimeout:     10 * time.Second,
+}
+
+// client sends all device requests over transport, so that, e.g., a
+// toggle's PUT reuses the connection of its GET. Each request has its own
+// deadline, from requestTimeout.
+var client = &http.Client{Transport: transport}
+
+// connections counts the connections opened to devices, for bench.
+var connections int32
+
+// request sends a request with an optional JSON body to url and returns the
+// response body.
+func request(method, url string, body []byte) ([]byte, error) {
+	select {
+	case <-stopping:
+		return nil, errStopping
+	default:
+	}
+	base := requestContext()
+	if base.Err() != nil {
+		return nil, errInterrupted
+	}
+	atomic.AddInt32(&inflight, 1)
+	defer atomic.AddInt32(&inflight, -1)
+	if *usageStats {
+		began := time.Now()
+		defer func() { noteRequest(time.Since(began)) }()
+	}
+	// Retry while the device is busy, since that usually passes quickly, and
+	// after transient failures, as of a device that has just woken.
+	busy, tries := 0, 0
+	retry := func(err error) bool {
+		if !retryable(method, tries) {
+			return false
+		}
+		d := retryDelay(tries)
+		if !canWait(d) {
+			return false
+		}
+		tries++
+		requestLog.debugf("%v; retry %d of %d in %s", err, tries, *retries, d.Round(time.Millisecond))
+		return sleepOrStop(d)
+	}
+	for {
+		var connected int32
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				atomic.StoreInt32(&connected, 1)
+				if !info.Reused {
+					atomic.AddInt32(&connections, 1)
+				}
+			},
+		}
+		var times traceTimes
+		if *timing {
+			times.hook(trace)
+		}
+		ctx := httptrace.WithClientTrace(base, trace)
+		limit := requestTimeout()
+		ctx, cancel := context.WithTimeout(ctx, limit)
+		req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		rl := requestLogger(req.URL.Host)
+		if len(body) > 0 {
+			rl.tracef("%s %s: sending %s", method, req.URL.Path, body)
+		}
+		sent := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			noteRequestTiming(&times, method, req.URL, sent)
+			cancel()
+			if base.Err() != nil {
+				return nil, errInterrupted
+			}
+			// Leave out the *url.Error's own method and URL.
+			if inner := errors.Unwrap(err); inner != nil {
+				err = inner
+			}
+			err = describeTimeout(err, atomic.LoadInt32(&connected) == 1, limit)
+			err = fmt.Errorf("%w: %s %s: %v", elgo.ErrDeviceUnreachable, method, url, err)
+			if retry(err) {
+				continue
+			}
+			return nil, err
+		}
+		respJson, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		noteRequestTiming(&times, method, req.URL, sent)
+		cancel()
+		if err != nil {
+			if base.Err() != nil {
+				return nil, errInterrupted
+			}
+			err = fmt.Errorf("%w: %s %s: %v", elgo.ErrDeviceUnreachable, method, url, describeTimeout(err, true, limit))
+			if retry(err) {
+				continue
+			}
+			return nil, err
+		}
+		rl.debugf("%s %s: %s", method, req.URL.Path, resp.Status)
+		rl.tracef("%s %s: answered in %s: %s", method, req.URL.Path, time.Since(sent).Round(100*time.Microsecond), respJson)
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			// A device that is still booting may answer with an HTML page.
+			if len(bytes.TrimSpace(respJson)) == 0 || json.Valid(respJson) {
+				return respJson, nil
+			}
+			err := fmt.Errorf("%s: %s %s: %w", req.URL.Host, method, req.URL.Path, &elgo.BadResponseError{
+				Status:      resp.StatusCode,
+				ContentType: resp.Header.Get("Content-Type"),
+				Body:        respJson,
+			})
+			if retry(err) {
+				continue
+			}
+			return nil, err
+		}
+		httpErr := &elgo.HTTPError{Status: resp.StatusCode, Body: respJson}
+		if !busyStatuses[resp.StatusCode] {
+			err := fmt.Errorf("%s: device returned %w on %s %s: %s", req.URL.Host, httpErr, method, req.URL.Path, snippet(respJson))
+			if transientStatus(resp.StatusCode) && retry(err) {
+				continue
+			}
+			return nil, err
+		}
+		d := busyDelay(busy, resp)
+		if busy == busyRetries || !canWait(d) {
+			return nil, fmt.Errorf("%s %s: device still busy after %d attempts (is another app controlling it?): %w", method, url, busy+1, httpErr)
+		}
+		busy++
+		requestLog.debugf("%s %s: device busy (%d), retrying in %s", method, url, resp.StatusCode, d)
+		if !sleepOrStop(d) {
+			if base.Err() != nil {
+				return nil, errInterrupted
+			}
+			return nil, errStopping
+		}
+	}
+}
+
+func fetchState(hostName string) (state, error) {
+	url := deviceURL(hostName, lightsEndpoint)
+	respJson, err := request(http.MethodGet, url, nil)
 	if err != nil {
-		log.Fatal(err)
+		return state{}, err
+	}
+	if *validateSchema {
+		checkLightsSchema(hostName, respJson)
 	}
 	r := state{}
 	err = json.Unmarshal(respJson, &r)
 	if err != nil {
-		log.Fatal(err)
+		return state{}, badJSON(hostName, "GET lights", respJson, err)
+	}
+	return r, nil
+}
+
+func getState(hostName string) state {
+	r, err := fetchState(hostName)
+	if err != nil {
+		fatal(err)
 	}
 	return r
 }
 
-func putState(hostName string, s state) state {
-	url := fmt.Sprintf(urlTemplate, hostName)
+func writeState(hostName string, s state) (state, error) {
+	url := deviceURL(hostName, lightsEndpoint)
 	jsonState, err := json.Marshal(s)
 	if err != nil {
-		log.Fatal(err)
+		return state{}, err
 	}
-	if *verbose {
-		log.Printf("request: %s", jsonState)
-	}
-	req, err := http.NewRequest(http.MethodPut, url, bytes.NewBuffer(jsonState))
+	respJson, err := request(http.MethodPut, url, jsonState)
 	if err != nil {
-		log.Fatal(err)
+		return state{}, err
 	}
-	req.Header.Set("Content-Type", "application/json; charset=utf-8")
-	client := &http.Client{
-		Timeout: *timeout - time.Since(start),
+	if *validateSchema {
+		checkLightsSchema(hostName, respJson)
 	}
-	resp, err := client.Do(req)
+	r := state{}
+	err = json.Unmarshal(respJson, &r)
 	if err != nil {
-		log.Fatal(err)
+		return state{}, badJSON(hostName, "PUT lights", respJson, err)
 	}
-	respJson, err := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
+	return r, nil
+}
+
+// putState writes s to the device at hostName, running the -pre-hook with
+// the requested state before and the -post-hook with the resulting state
+// after, and recording the change with -history and the resulting state for
+// elgo last.
+func putState(hostName string, s state) state {
+	r, err := tryPutState(hostName, s)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
-	if *verbose {
-		log.Printf("JSON response: %s", respJson)
+	return r
+}
+
+// tryPutState is putState, returning rather than exiting on failure.
+func tryPutState(hostName string, s state) (state, error) {
+	var old state
+	if *recordHistory {
+		old, _ = fetchState(hostName)
 	}
-	r := state{}
-	err = json.Unmarshal(respJson, &r)
+	runStateHook("pre", *preHook, hostName, s)
+	r, err := sendState(hostName, s)
 	if err != nil {
-		log.Fatalf("bad JSON response: %s", respJson)
+		return state{}, err
 	}
-	return r
+	runStateHook("post", *postHook, hostName, r)
+	recordLast(hostName, r)
+	if *recordHistory {
+		appendHistory(diffStates(device{Instance: hostName, HostName: hostName}, old, r), sourceCLI)
+	}
+	return r, nil
+}
+
+// resolveHost returns the -host address, or that of the first device
+// discovered.
+func resolveHost() string {
+	hostName := hostAddr()
+	if *waitFor > 0 {
+		hostName = waitForDevices(true)[0].HostName
+	} else if *host == "" {
+		hostName = pickDevice()
+	}
+	if hostName == "" {
+		fatal(errors.New("empty hostname"))
+	}
+	cmdLog.debugf("Hostname: %s", hostName)
+	return hostName
+}
+
+// discoverAll returns every device found before the timeout in -sort order,
+// or just the -host device if given.
+func discoverAll() []device {
+	found := discoverAny()
+	if len(found) == 0 {
+		noDevice()
+	}
+	return found
+}
+
+// discoverAny is like discoverAll, but returns no devices rather than
+// failing if none are found.
+func discoverAny() []device {
+	if *waitFor > 0 {
+		found := waitForDevices(false)
+		sortDevices(found, *sortKey)
+		return found
+	}
+	if *host != "" {
+		return []device{{Instance: *host, HostName: hostAddr()}}
+	}
+	devs := make(chan device)
+	if err := browseMDNS(devs, nil); err != nil {
+		fatal(err)
+	}
+	var found []device
+	for d := range devs {
+		discoveryLog.debugf("found %s at %s", d.Instance, d.HostName)
+		found = append(found, d)
+	}
+	sortDevices(found, *sortKey)
+	return found
 }
 
 var start time.Time
 
-func main() {
-	start = time.Now()
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	flag.Parse()
+// longRunning is set by modes that run indefinitely (e.g. watch), in which
+// case each request gets the full timeout rather than sharing one deadline.
+var longRunning bool
 
-	hostName, err := getMDNS()
-	if err != nil {
-		log.Fatal(err)
+// remaining returns the time left before the overall timeout.
+func remaining() time.Duration {
+	d := *timeout - time.Since(start)
+	if d <= 0 {
+		fatal(fmt.Errorf("timeout (%s)", *timeout))
 	}
-	if hostName == "" {
-		log.Fatal("empty hostname")
+	return d
+}
+
+// requestTimeout returns the timeout for a single device request.
+func requestTimeout() time.Duration {
+	if longRunning {
+		return *requestTimeoutFlag
 	}
-	if *verbose {
-		log.Printf("Hostname: %s", hostName)
+	if d := remaining(); d < *requestTimeoutFlag {
+		return d
 	}
+	return *requestTimeoutFlag
+}
 
-	args := flag.Args()
-	if len(args) > 1 {
-		log.Fatal("only one command may be specified: on, off or toggle (default)")
+// describeTimeout returns err, or if it is a timeout, an error saying
+// whether the device never accepted the connection or accepted it and then
+// never answered, as a half-asleep light does.
+func describeTimeout(err error, connected bool, d time.Duration) error {
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		return err
 	}
-	if len(args) == 0 {
-		args = []string{"toggle"}
+	if connected {
+		return fmt.Errorf("connected, but no response within %s", d.Round(time.Millisecond))
 	}
+	return fmt.Errorf("no connection within %s", d.Round(time.Millisecond))
+}
 
-	command := args[0]
-	s := state{
-		NumberOfLights: 1,
-		Lights:         []light{{}},
+// exitOff is the exit status of toggle when the light ends up off, so that
+// scripts can tell the new power state without a second query. It is
+// distinct from the statuses for errors (1) and bad usage (2).
+const exitOff = 10
+
+// selectLight returns the index of the light selected by -light-id or
+// -light-index in s. Devices that don't report light IDs are addressed by
+// index, so -light-id may then be given as a number.
+func selectLight(s state) (int, error) {
+	if *lightID == "" {
+		if *lightIndex < 0 || *lightIndex >= len(s.Lights) {
+			return 0, fmt.Errorf("light index %d out of range: device has %d lights", *lightIndex, len(s.Lights))
+		}
+		return *lightIndex, nil
+	}
+	hasIDs := false
+	for i, l := range s.Lights {
+		if l.ID == *lightID {
+			return i, nil
+		}
+		hasIDs = hasIDs || l.ID != ""
+	}
+	if i, err := strconv.Atoi(*lightID); err == nil && !hasIDs && i >= 0 && i < len(s.Lights) {
+		return i, nil
+	}
+	var ids []string
+	for _, l := range s.Lights {
+		if l.ID != "" {
+			ids = append(ids, l.ID)
+		}
+	}
+	if !hasIDs {
+		return 0, fmt.Errorf("no light %q: device does not report light IDs, use -light-index", *lightID)
+	}
+	return 0, fmt.Errorf("no light %q: device has lights %s", *lightID, strings.Join(ids, ", "))
+}
+
+// checkRange returns the -brightness, -temperature and -raw-temperature
+// settings for the ranges the device at hostName accepts, which relative
+// changes are limited to, failing (or with -clamp, clamping them) if they
+// are outside them.
+func checkRange(hostName string) (b, t *setting, raw int) {
+	r := deviceRange(hostName)
+	b, err := brightness.limit(hostName, r.BrightnessMin, r.BrightnessMax)
+	if err != nil {
+		fatal(fmt.Errorf("%w: %s: %v", elgo.ErrInvalidBrightness, hostName, err))
 	}
-	commandLower := strings.ToLower(command)
-	switch commandLower {
+	tv := *temperature
+	min, max := r.kelvin()
+	if *xy != "" {
+		tv = clampXY(hostName, tv, min, max)
+	}
+	t, err = tv.limit(hostName, min, max)
+	if err != nil {
+		fatal(fmt.Errorf("%w: %s: %v", elgo.ErrInvalidTemperature, hostName, err))
+	}
+	if raw = int(*rawTemperature); raw != 0 {
+		if raw, err = limitValue(hostName, "raw temperature", raw, r.TemperatureMin, r.TemperatureMax); err != nil {
+			fatal(fmt.Errorf("%w: %s: %v", elgo.ErrInvalidTemperature, hostName, err))
+		}
+	}
+	return b, t, raw
+}
+
+// act applies command (on, off, toggle, or adjust to only set properties)
+// to the selected light of the device at hostName and returns its new state.
+func act(hostName, command string) light {
+	return actFrom(hostName, command, *assumeOneLight)
+}
+
+// actFrom is act, toggling from the state elgo last left the device in,
+// if blind and it is known to be current (see lastKnown), rather than
+// reading the device first.
+func actFrom(hostName, command string, blind bool) light {
+	if *minFirmware != "" {
+		if err := requireFirmware(hostName, "this change", *minFirmware); err != nil {
+			fatal(err)
+		}
+	}
+	// Refuse before sending what the model would silently drop.
+	colored := hue.set || saturation.set
+	if colored {
+		if m := deviceModel(hostName); !m.Color {
+			fatal(fmt.Errorf("%s: %s does not support %s", hostName, m.Name, colorFlagName()))
+		}
+	}
+	// Limit the settings to this device's ranges.
+	brightness, temperature := brightness, temperature
+	raw := int(*rawTemperature)
+	if brightness.isSet() || temperature.isSet() || raw != 0 {
+		brightness, temperature, raw = checkRange(hostName)
+	}
+	// Addressing a light other than the first requires writing the whole
+	// array, so read it first.
+	multi := *lightID != "" || *lightIndex != 0
+	var cur state
+	i := 0
+	blind = blind && command == "toggle" && !multi && !brightness.relative && !temperature.relative && !colored
+	if blind {
+		cur, blind = lastKnown(hostName)
+	}
+	// A soft start needs to know whether the light is off.
+	ramp := *softStart > 0 && (command == "on" || command == "toggle")
+	if !blind && (command == "toggle" || command == "adjust" || multi || brightness.relative || temperature.relative || colored || ramp) {
+		cur = getState(hostName)
+		if multi {
+			var err error
+			if i, err = selectLight(cur); err != nil {
+				fatal(err)
+			}
+		} else if cur.NumberOfLights != 1 {
+			fatal(fmt.Errorf("expected one light, got %d (use -light-index or -light-id)", cur.NumberOfLights))
+		}
+	}
+
+	l := light{}
+	switch command {
 	case "on":
-		s.Lights[0].On = 1
+		l.On = 1
 	case "off":
-		s.Lights[0].On = 0
+		l.On = 0
 	case "toggle":
-		s = getState(hostName)
-		if s.NumberOfLights != 1 {
-			log.Fatalf("expected one light, got %d", s.NumberOfLights)
+		// Don't change other properties
+		if cur.Lights[i].On == 0 {
+			l.On = 1
 		}
-		if s.Lights[0].On == 0 {
-			s.Lights[0].On = 1
-		} else {
-			s.Lights[0].On = 0
+	case "adjust":
+		l.On = cur.Lights[i].On
+	}
+
+	// The current state is only read if needed.
+	var was light
+	if i < len(cur.Lights) {
+		was = cur.Lights[i]
+	}
+	if brightness.isSet() {
+		l.Brightness = brightness.apply(was.Brightness)
+	}
+	if temperature.isSet() {
+		k := temperature.n
+		if temperature.relative {
+			if was.Temperature == 0 {
+				fatal(fmt.Errorf("%s: device did not report a temperature to change", hostName))
+			}
+			k = temperature.apply(toKelvin(was.Temperature))
 		}
+		l.Temperature = deviceRange(hostName).temperature(k)
+	}
+	if raw != 0 {
+		l.Temperature = raw
+	}
+	// Setting a temperature switches a device with color to white, as it
+	// is sent without a hue.
+	if colored {
+		if !hasColor(was) {
+			fatal(fmt.Errorf("%s: device doesn't support color (-color, -hue and -saturation need a device such as the Light Strip)", hostName))
+		}
+		h, sat := *was.Hue, 0.0
+		if was.Saturation != nil {
+			sat = *was.Saturation
+		}
+		// Out-of-range colors are usage errors, like out-of-range brightness.
+		var err error
+		if hue.set {
+			if h, err = limitFloat(hostName, hue.name, hue.v, 0, hue.max); err != nil {
+				deviceLog(hostName).errorf("%v", err)
+				exit(exitUsage)
+			}
+		}
+		if saturation.set {
+			if sat, err = limitFloat(hostName, saturation.name, saturation.v, 0, saturation.max); err != nil {
+				deviceLog(hostName).errorf("%v", err)
+				exit(exitUsage)
+			}
+		}
+		l.Hue, l.Saturation = &h, &sat
+	}
 
-		// Don't change other properties
-		s.Lights[0].Brightness = 0
-		s.Lights[0].Temperature = 0
+	s := state{NumberOfLights: 1, Lights: []light{l}}
+	if multi {
+		l.ID = cur.Lights[i].ID
+		l.extra = cur.Lights[i].extra
+		s = cur
+		s.Lights[i] = l
+	}
+	if ramp && was.On == 0 && l.On == 1 {
+		target := l.Brightness
+		if target == 0 {
+			target = was.Brightness
+		}
+		rampOn(hostName, s, i, target)
+		s.Lights[i].Brightness = target
+	}
+	rState := putState(hostName, s)
+	if blind && (rState.NumberOfLights != 1 || len(rState.Lights) != 1) {
+		deviceLog(hostName).debugf("response disagrees with last known state; reading it")
+		return actFrom(hostName, command, false)
+	}
+	// The response only confirms what was sent, but anything else in it
+	// that differs from the last known state gives away a change made
+	// elsewhere, which may have changed the power too.
+	if blind && !sameSettings(rState.Lights[0], cur.Lights[0]) {
+		deviceLog(hostName).warnf("the light was changed elsewhere since elgo last set it; if this toggle did nothing, toggle again")
+	}
+	if i >= len(rState.Lights) {
+		fatal(fmt.Errorf("expected at least %d lights in response, got %d", i+1, len(rState.Lights)))
+	}
+
+	if rl := rState.Lights[i]; inColor(rl) {
+		deviceLog(hostName).debugf("color: %s", describeColor(rl))
+	} else {
+		deviceLog(hostName).debugf("temperature: %dK", toKelvin(rl.Temperature))
+	}
+	return rState.Lights[i]
+}
+
+const onlyOneCommand = "only one command may be specified: on, off, toggle (default), status, identify, wake, copy, watch, enforce, daemon, serve, mqtt, obs, autocam, automeeting, autolock, hotkeys, bench, reflect, discover, info, battery, wifi, strip, rename, settings, simulate, bi-level, tui, tray, flash, fade, signal, reset, history, match-monitor, stats, last, trace or ctl"
+
+func main() {
+	start = time.Now()
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	handleInterrupts()
+	flag.Parse()
+	applyConfig()
+	setupLogging()
+	applyEnv()
+	if *quiet {
+		if *verbose {
+			fatal(errors.New("-q and -v are mutually exclusive"))
+		}
+		silence()
+	}
+	startProfiling()
+	if err := checkPathTemplate(*pathTemplate); err != nil {
+		fatal(fmt.Errorf("bad -path-template: %v", err))
+	}
+	resolveMACHost()
+	switch *sortKey {
+	case "name", "ip", "none":
 	default:
-		log.Fatalf("bad command: %s", command)
+		fatal(fmt.Errorf("bad -sort: %s", *sortKey))
+	}
+	resolveDeviceIndex()
+	if *minFirmware != "" {
+		if _, err := parseVersion(*minFirmware); err != nil {
+			fatal(fmt.Errorf("bad -min-firmware: %v", err))
+		}
+	}
+	if *record != "" && *replay != "" {
+		fatal(errors.New("-record and -replay are mutually exclusive"))
+	}
+	switch {
+	case *count < 0:
+		fatal(errors.New("-count must not be negative"))
+	case *count > 0 && *pipeline:
+		fatal(errors.New("-count and -parallel-discovery-then-act are mutually exclusive"))
+	case *requireAll && *count == 0:
+		fatal(errors.New("-require-all needs -count"))
+	}
+	if *record != "" {
+		transport = &recorder{path: *record, next: transport}
+		client.Transport = transport
+	}
+	if *traceFile != "" {
+		transport = newTracer(*traceFile, transport)
+		client.Transport = transport
+	}
+	if *replay != "" {
+		srv := replayServer(*replay)
+		defer srv.Close()
+		*host = srv.Listener.Addr().String()
 	}
 
-	if *brightness > 100 {
-		log.Fatal("brightness must be between 0 and 100")
+	args := flag.Args()
+	usageCommand = "toggle"
+	if len(args) > 0 {
+		usageCommand = strings.ToLower(args[0])
+	}
+	defer exit(0)
+	if len(args) > 0 {
+		switch strings.ToLower(args[0]) {
+		case "watch":
+			watch(args[1:])
+			return
+		case "enforce":
+			enforce(args[1:])
+			return
+		case "daemon", "serve", "mqtt":
+			daemon(strings.ToLower(args[0]), args[1:])
+			return
+		case "obs":
+			obs(args[1:])
+			return
+		case "autocam":
+			autocam(args[1:])
+			return
+		case "automeeting":
+			automeeting(args[1:])
+			return
+		case "autolock":
+			autolock(args[1:])
+			return
+		case "hotkeys":
+			hotkeys(args[1:])
+			return
+		case "bench":
+			bench(args[1:])
+			return
+		case "reflect":
+			reflector(args[1:])
+			return
+		case "discover":
+			discover(args[1:])
+			return
+		case "info":
+			info(args[1:])
+			return
+		case "rename":
+			rename(args[1:])
+			return
+		case "settings":
+			settings(args[1:])
+			return
+		case "battery":
+			battery(args[1:])
+			return
+		case "wifi":
+			wifi(args[1:])
+			return
+		case "strip":
+			strip(args[1:])
+			return
+		case "copy":
+			copyLight(args[1:])
+			return
+		case "wake":
+			wake(args[1:])
+			return
+		case "identify":
+			identify(args[1:])
+			return
+		case "status":
+			statusCommand(args[1:])
+			return
+		case "simulate":
+			simulate(args[1:])
+			return
+		case "bi-level":
+			biLevel(args[1:])
+			return
+		case "tui":
+			tui(args[1:])
+			return
+		case "tray":
+			tray(args[1:])
+			return
+		case "flash":
+			flash(args[1:])
+			return
+		case "fade":
+			fade(args[1:])
+			return
+		case "signal":
+			signalCode(args[1:])
+			return
+		case "reset":
+			reset(args[1:])
+			return
+		case "history":
+			history(args[1:])
+			return
+		case "match-monitor":
+			matchMonitor(args[1:])
+			return
+		case "stats":
+			stats(args[1:])
+			return
+		case "last":
+			last(args[1:])
+			return
+		case "ctl":
+			ctl(args[1:])
+			return
+		case "trace":
+			traceCmd(args[1:])
+			return
+		}
+	}
+	if len(args) > 1 {
+		switch strings.ToLower(args[0]) {
+		case "on", "off", "toggle":
+			// These take flags, parsed below.
+		default:
+			fatal(errors.New(onlyOneCommand))
+		}
 	}
-	s.Lights[0].Brightness = int(*brightness)
-	if *temperature != 0 {
-		if *temperature < 2900 || *temperature > 7000 {
-			log.Fatal("temperature must be between 2900 and 7000 (in Kelvins)")
+	applyXY()
+	applyColor()
+	// With no command, setting properties on the command line leaves the
+	// power alone, and otherwise elgo toggles, applying any defaults from
+	// the config file or environment.
+	command := "toggle"
+	asJSON := new(bool)
+	if len(args) > 0 {
+		command = strings.ToLower(args[0])
+		switch command {
+		case "on", "off", "toggle":
+		default:
+			fatal(fmt.Errorf("bad command: %s", args[0]))
 		}
-		s.Lights[0].Temperature = fromKelvin(int(*temperature))
+		fs := flag.NewFlagSet(command, flag.ExitOnError)
+		fs.BoolVar(onlyIfPresent, "only-if-present", *onlyIfPresent, onlyIfPresentUsage)
+		asJSON = jsonFlag(fs, "print the new state of each light as JSON")
+		fs.Parse(args[1:])
+		if fs.NArg() > 0 {
+			fatal(errors.New(onlyOneCommand))
+		}
+	} else if onCommandLine("brightness", "temperature", "raw-temperature", "hue", "saturation", "xy", "color") {
+		command = "adjust"
 	}
+	usageCommand = command
 
-	rState := putState(hostName, s)
+	if *lockPower {
+		switch {
+		case command != "adjust" && len(args) > 0:
+			fatal(fmt.Errorf("-lock-power can't be used with %s", command))
+		case command != "adjust":
+			fatal(errors.New("-lock-power needs -brightness or -temperature to change"))
+		}
+	}
+
+	if (hue.set || saturation.set) && (temperature.isSet() || *rawTemperature != 0) {
+		fatal(errors.New("-hue and -saturation can't be used with -temperature or -raw-temperature"))
+	}
+	if *rawTemperature != 0 && temperature.isSet() {
+		fatal(errors.New("-temperature and -raw-temperature are mutually exclusive"))
+	}
+
+	checkPresent()
+	// Once every device is done, -json prints the lights as they were left,
+	// and toggling them all off exits with exitOff.
+	var results []lightStatus
+	var resultsMu sync.Mutex
+	acted := func(d device, l light) {
+		resultsMu.Lock()
+		defer resultsMu.Unlock()
+		results = append(results, newLightStatus(d, *lightIndex, l))
+	}
+	done := func(allOff bool) {
+		if *asJSON {
+			out := struct {
+				Lights []lightStatus `json:"lights"`
+				Timing *timingReport `json:"timing,omitempty"`
+			}{results, timingField()}
+			if err := json.NewEncoder(os.Stdout).Encode(out); err != nil {
+				fatal(err)
+			}
+		}
+		if command == "toggle" && allOff {
+			exit(exitOff)
+		}
+	}
+	if *pipeline && *host == "" {
+		devs := make(chan device)
+		if err := browseMDNS(devs, nil); err != nil {
+			fatal(err)
+		}
+		wg := &sync.WaitGroup{}
+		mu := sync.Mutex{}
+		allOff := true
+		n := 0
+		for d := range devs {
+			cmdLog.debugf("Hostname: %s", d.HostName)
+			n++
+			wg.Add(1)
+			go func(d device) {
+				l := act(d.HostName, command)
+				acted(d, l)
+				mu.Lock()
+				allOff = allOff && l.On == 0
+				mu.Unlock()
+				wg.Done()
+			}(d)
+		}
+		wg.Wait()
+		if n == 0 {
+			noDevice()
+		}
+		done(allOff)
+		return
+	}
 
-	if *verbose {
-		log.Printf("temperature: %dK", toKelvin(rState.Lights[0].Temperature))
+	if *count > 0 && *host == "" {
+		allOff := true
+		for _, d := range discoverCount(*count) {
+			cmdLog.debugf("Hostname: %s", d.HostName)
+			l := act(d.HostName, command)
+			acted(d, l)
+			allOff = l.On == 0 && allOff
+		}
+		done(allOff)
+		return
 	}
+
+	hostName := resolveHost()
+	l := act(hostName, command)
+	acted(knownDevice(hostName), l)
+	done(l.On == 0)
 }
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oleksandr/bonjour"
+)
+
+func TestLightKeepsUnknownFields(t *testing.T) {
+	const in = `{"numberOfLights":1,"lights":[{"on":1,"brightness":20,"temperature":213,"effect":{"id":"wave","speed":3}}]}`
+	const effect = `"effect":{"id":"wave","speed":3}`
+	var cur state
+	if err := json.Unmarshal([]byte(in), &cur); err != nil {
+		t.Fatal(err)
+	}
+	want := light{On: 0, Brightness: 60, Temperature: 200}
+	b, tt := &setting{name: "brightness", n: 60}, &setting{name: "temperature", n: 5000}
+	for _, tc := range []struct {
+		name string
+		l    light
+	}{
+		{"unchanged", cur.Lights[0]},
+		{"copiedLight", copiedLight(want, cur.Lights[0], true)},
+		{"fadeTarget", fadeTarget("test", cur.Lights[0], b, tt)},
+	} {
+		out, err := json.Marshal(state{NumberOfLights: 1, Lights: []light{tc.l}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(out), effect) {
+			t.Errorf("%s: encoded %s, lost %s", tc.name, out, effect)
+		}
+	}
+	if l := copiedLight(want, cur.Lights[0], true); l.On != 0 || l.Brightness != 60 || l.Temperature != 200 {
+		t.Errorf("copiedLight = %+v", l)
+	}
+	if l := fadeTarget("test", cur.Lights[0], b, tt); l.On != 1 || l.Brightness != 60 || l.Temperature != fromKelvin(5000) {
+		t.Errorf("fadeTarget = %+v", l)
+	}
+}
+
+// fakeResolver sends its entries one at a time, taking an exit only between
+// sends, as bonjour.Resolver does.
+type fakeResolver struct {
+	entries []*bonjour.ServiceEntry
+	quit    chan bool
+	wg      *sync.WaitGroup
+}
+
+func (r *fakeResolver) Browse(service, domain string, entries chan<- *bonjour.ServiceEntry) error {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		for _, e := range r.entries {
+			select {
+			case <-r.quit:
+				return
+			default:
+			}
+			entries <- e
+		}
+		<-r.quit
+	}()
+	return nil
+}
+
+func (r *fakeResolver) exit() {
+	r.quit <- true
+}
+
+// fakeResolvers makes browseMDNS browse three services with fakeResolvers
+// of perResolver entries each for the rest of the test, returning a wait
+// for every resolver to have stopped.
+func fakeResolvers(t *testing.T, perResolver int) (stopped func() bool) {
+	services := *serviceFlag
+	*serviceFlag = "_elg._tcp,_fake-a._tcp,_fake-b._tcp"
+	wg := &sync.WaitGroup{}
+	n := 0
+	newResolver = func() (resolver, error) {
+		r := &fakeResolver{quit: make(chan bool), wg: wg}
+		for i := 0; i < perResolver; i++ {
+			r.entries = append(r.entries, &bonjour.ServiceEntry{
+				ServiceRecord: bonjour.ServiceRecord{Instance: fmt.Sprintf("Light %d", n)},
+				HostName:      fmt.Sprintf("light-%d.local.", n),
+				Port:          9123,
+			})
+			n++
+		}
+		return r, nil
+	}
+	t.Cleanup(func() {
+		*serviceFlag = services
+		newResolver = func() (resolver, error) {
+			r, err := bonjour.NewResolver(nil)
+			if err != nil {
+				return nil, err
+			}
+			return bonjourResolver{r}, nil
+		}
+	})
+	stopped = func() bool {
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return true
+		case <-time.After(5 * time.Second):
+			return false
+		}
+	}
+	return stopped
+}
+
+// receive returns the devices sent on devs until it is closed, failing the
+// test if that takes too long.
+func receive(t *testing.T, devs <-chan device) []device {
+	t.Helper()
+	var got []device
+	for {
+		select {
+		case d, ok := <-devs:
+			if !ok {
+				return got
+			}
+			got = append(got, d)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("browseMDNS never closed devs; got %d devices", len(got))
+		}
+	}
+}
+
+func TestBrowseStop(t *testing.T) {
+	stopped := fakeResolvers(t, 4)
+	devs, stop := make(chan device), make(chan struct{})
+	if err := browseMDNS(devs, stop); err != nil {
+		t.Fatal(err)
+	}
+	// Stop after the first, with the others queued behind it.
+	<-devs
+	close(stop)
+	receive(t, devs)
+	if !stopped() {
+		t.Error("a resolver was left blocked sending an entry")
+	}
+}
+
+func TestBrowseDeadline(t *testing.T) {
+	stopped := fakeResolvers(t, 1)
+	start, *timeout = time.Now(), 100*time.Millisecond
+	defer func() { start, *timeout = time.Now(), time.Hour }()
+	devs := make(chan device)
+	if err := browseMDNS(devs, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Read nothing until the deadline, so that every resolver is blocked
+	// sending its entry when it passes. They are all kept.
+	time.Sleep(2 * *timeout)
+	if got := receive(t, devs); len(got) != 3 {
+		t.Errorf("got %d devices, want 3: %v", len(got), got)
+	}
+	if !stopped() {
+		t.Error("a resolver was left blocked sending an entry")
+	}
+}
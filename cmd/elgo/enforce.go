@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+)
+
+// desired is the state enforce holds a light to. Zero brightness or
+// temperature means the property is not enforced.
+type desired struct {
+	power       bool // whether On is enforced
+	on          int
+	brightness  int
+	temperature int // device units
+	kelvin      int // as requested, which temperature only approximates
+	tolerance   int // in Kelvin, as for temperatureClose
+}
+
+// deviates reports whether l differs from d in any enforced property.
+func (d desired) deviates(l light) bool {
+	return (d.power && l.On != d.on) ||
+		(d.brightness != 0 && l.Brightness != d.brightness) ||
+		(d.temperature != 0 && !temperatureClose(d.kelvin, l.Temperature, d.tolerance))
+}
+
+// temperatureClose reports whether the device temperature got is within
+// tolerance of kelvin or, for zero tolerance, within the one device unit
+// that converting kelvin (with fromKelvin) may have truncated away.
+func temperatureClose(kelvin, got, tolerance int) bool {
+	if tolerance == 0 {
+		d := got - fromKelvin(kelvin)
+		return d >= -1 && d <= 1
+	}
+	d := toKelvin(got) - kelvin
+	return d >= -tolerance && d <= tolerance
+}
+
+// state returns the state to write to correct a deviation.
+func (d desired) state(current light) state {
+	l := light{On: current.On, Brightness: d.brightness, Temperature: d.temperature}
+	if d.power {
+		l.On = d.on
+	}
+	return state{NumberOfLights: 1, Lights: []light{l}}
+}
+
+// enforceDevice polls dev and writes want back whenever the light deviates
+// from it for longer than grace, making at most maxPerMinute corrections in
+// any minute.
+func enforceDevice(dev device, want desired, interval, grace time.Duration, maxPerMinute int, n *notifier) {
+	l := enforceLog.forDevice(dev.Instance, "")
+	var deviatedAt time.Time
+	var corrections []time.Time
+	limited := false
+	for ok := true; ok; ok = sleepOrStop(interval) {
+		s, err := fetchState(dev.HostName)
+		if err == errStopping {
+			return
+		} else if err != nil {
+			l.errorf("%v", err)
+			continue
+		}
+		if len(s.Lights) == 0 || !want.deviates(s.Lights[0]) {
+			deviatedAt = time.Time{}
+			continue
+		}
+		now := time.Now()
+		if deviatedAt.IsZero() {
+			deviatedAt = now
+			if grace > 0 {
+				l.infof("changed, reverting in %s", grace)
+			}
+		}
+		if now.Sub(deviatedAt) < grace {
+			continue
+		}
+
+		for len(corrections) > 0 && now.Sub(corrections[0]) >= time.Minute {
+			corrections = corrections[1:]
+		}
+		if maxPerMinute > 0 && len(corrections) >= maxPerMinute {
+			if !limited {
+				l.warnf("%d corrections in the last minute, holding off", len(corrections))
+				limited = true
+			}
+			continue
+		}
+		limited = false
+
+		cur := s.Lights[0]
+		l.infof("correcting on=%d brightness=%d temperature=%dK", cur.On, cur.Brightness, toKelvin(cur.Temperature))
+		r, err := sendState(dev.HostName, want.state(cur))
+		if err != nil {
+			l.errorf("%v", err)
+			continue
+		}
+		if n != nil {
+			n.events(diffStates(dev, s, r), sourceEnforce)
+		}
+		corrections = append(corrections, now)
+		deviatedAt = time.Time{}
+	}
+}
+
+// enforce holds every device found during discovery to the requested state
+// until SIGINT or SIGTERM.
+func enforce(args []string) {
+	fs := flag.NewFlagSet("enforce", flag.ExitOnError)
+	b := fs.Uint("brightness", uint(brightness.absolute()), "brightness to enforce (between 1 and 100)")
+	t := fs.Uint("temperature", uint(temperature.absolute()), "color temperature to enforce (between 2900 and 7000)")
+	tolerance := fs.Uint("temperature-tolerance", 0, "Kelvin by which a light's temperature may differ from -temperature before it is corrected (0 for one device unit, which conversion may round away)")
+	on := fs.Bool("on", false, "keep the light on")
+	off := fs.Bool("off", false, "keep the light off")
+	interval := fs.Duration("interval", 2*time.Second, "polling interval")
+	grace := fs.Duration("grace", 0, "tolerate manual changes for this long before reverting them")
+	notify := fs.Bool("notify", false, "show a desktop notification for each correction")
+	maxPerMinute := fs.Int("max-corrections-per-minute", 6, "maximum corrections per device per minute (0 for no limit)")
+	drain := fs.Duration("drain", 5*time.Second, "on SIGINT or SIGTERM, wait this long for in-flight requests")
+	fs.Parse(args)
+
+	if *on && *off {
+		fatal(errors.New("-on and -off are mutually exclusive"))
+	}
+	want := desired{power: *on || *off, tolerance: int(*tolerance)}
+	if *on {
+		want.on = 1
+	}
+	if *b != 0 {
+		n, err := limitValue("enforce", "brightness", int(*b), 1, 100)
+		if err != nil {
+			fatal(err)
+		}
+		want.brightness = n
+	}
+	if *t != 0 {
+		k, err := limitValue("enforce", "temperature", int(*t), 2900, 7000)
+		if err != nil {
+			fatal(fmt.Errorf("%v (in Kelvins)", err))
+		}
+		want.temperature, want.kelvin = defaultRange.temperature(k), k
+	}
+	if !want.power && want.brightness == 0 && want.temperature == 0 {
+		fatal(errors.New("nothing to enforce: specify -on, -off, -brightness or -temperature"))
+	}
+
+	found := discoverAll()
+	longRunning = true
+	var n *notifier
+	if *notify {
+		n = newNotifier()
+	}
+	for _, d := range found {
+		go enforceDevice(d, want, *interval, *grace, *maxPerMinute, n)
+	}
+	awaitShutdown(*drain)
+}
@@ -0,0 +1,72 @@
+package main
+
+import "testing"
+
+func TestTemperatureCloseRounding(t *testing.T) {
+	for k := 2900; k <= 7000; k++ {
+		want := fromKelvin(k)
+		for d := -3; d <= 3; d++ {
+			close := d >= -1 && d <= 1
+			if got := temperatureClose(k, want+d, 0); got != close {
+				t.Errorf("temperatureClose(%dK, %d, 0) = %v, want %v", k, want+d, got, close)
+			}
+		}
+		// Reading back what was written is close at any tolerance, though
+		// converting it back to Kelvin may be a device unit (up to 49K at
+		// 7000K) away.
+		step := k*k/kelvinFactor + 1
+		if d := toKelvin(want) - k; d < 0 || d > step {
+			t.Errorf("%dK reads back as %dK", k, toKelvin(want))
+		}
+		for _, tolerance := range []int{step, 2 * step} {
+			if !temperatureClose(k, want, tolerance) {
+				t.Errorf("temperatureClose(%dK, %d, %d) = false", k, want, tolerance)
+			}
+		}
+	}
+}
+
+func TestTemperatureCloseTolerance(t *testing.T) {
+	for _, tt := range []struct {
+		kelvin, got, tolerance int
+		want                   bool
+	}{
+		{5000, 200, 50, true},  // 5000K
+		{5000, 196, 50, false}, // 5102K
+		{5000, 197, 100, true}, // 5076K
+		{5000, 201, 50, true},  // 4975K
+		{5000, 204, 50, false}, // 4901K, truncated from 4901.96
+		{2900, 344, 0, true},
+		{2900, 345, 0, true},
+		{2900, 346, 0, false},
+		{7000, 143, 0, true}, // fromKelvin(7000) is 142
+		{7000, 141, 0, true},
+		{7000, 140, 0, false},
+	} {
+		if got := temperatureClose(tt.kelvin, tt.got, tt.tolerance); got != tt.want {
+			t.Errorf("temperatureClose(%dK, %d (%dK), %d) = %v, want %v", tt.kelvin, tt.got, toKelvin(tt.got), tt.tolerance, got, tt.want)
+		}
+	}
+}
+
+func TestDeviates(t *testing.T) {
+	want := desired{power: true, on: 1, brightness: 40, temperature: fromKelvin(4500), kelvin: 4500}
+	for _, tt := range []struct {
+		l    light
+		want bool
+	}{
+		{light{On: 1, Brightness: 40, Temperature: fromKelvin(4500)}, false},
+		{light{On: 1, Brightness: 40, Temperature: fromKelvin(4500) + 1}, false},
+		{light{On: 0, Brightness: 40, Temperature: fromKelvin(4500)}, true},
+		{light{On: 1, Brightness: 41, Temperature: fromKelvin(4500)}, true},
+		{light{On: 1, Brightness: 40, Temperature: fromKelvin(4500) + 2}, true},
+	} {
+		if got := want.deviates(tt.l); got != tt.want {
+			t.Errorf("deviates(%+v) = %v, want %v", tt.l, got, tt.want)
+		}
+	}
+	// Properties not enforced may be anything.
+	if (desired{brightness: 40}).deviates(light{On: 0, Brightness: 40, Temperature: 300}) {
+		t.Error("deviates on properties not enforced")
+	}
+}
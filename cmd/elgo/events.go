@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// eventBuffer is how many events may queue for a client before further ones
+// are dropped, so that a slow client can't hold up the daemon.
+const eventBuffer = 64
+
+// A streamEvent is sent to clients of /v1/events.
+type streamEvent struct {
+	Type    string      `json:"type"` // snapshot, change or overflow
+	Time    time.Time   `json:"time"`
+	Source  string      `json:"source,omitempty"`  // of a change
+	Device  *apiDevice  `json:"device,omitempty"`  // after a change
+	Devices []apiDevice `json:"devices,omitempty"` // in a snapshot
+	Dropped int         `json:"dropped,omitempty"` // events lost to an overflow
+}
+
+// eventHub fans out state changes to the clients of /v1/events.
+type eventHub struct {
+	api *api
+
+	mu   sync.Mutex
+	subs map[*subscriber]bool
+}
+
+// A subscriber is a client of /v1/events.
+type subscriber struct {
+	devices map[*tracked]bool // nil for all
+	events  chan streamEvent
+	lost    chan struct{} // signalled when events are dropped
+	dropped int           // guarded by eventHub.mu
+}
+
+// subscriber returns a new subscriber to the devices named, or to all
+// devices if none are.
+func (h *eventHub) subscriber(names []string) (*subscriber, error) {
+	s := &subscriber{
+		events: make(chan streamEvent, eventBuffer),
+		lost:   make(chan struct{}, 1),
+	}
+	if len(names) > 0 {
+		s.devices = map[*tracked]bool{}
+		for _, name := range names {
+			t := h.api.find(name)
+			if t == nil {
+				return nil, fmt.Errorf("no such device: %s", name)
+			}
+			s.devices[t] = true
+		}
+	}
+	return s, nil
+}
+
+func (s *subscriber) wants(t *tracked) bool {
+	return s.devices == nil || s.devices[t]
+}
+
+// newEventHub returns a hub publishing every change to the devices of a.
+func newEventHub(a *api) *eventHub {
+	h := &eventHub{api: a, subs: map[*subscriber]bool{}}
+	for _, t := range a.devices {
+		t := t
+		t.mu.Lock()
+		t.onChange = append(t.onChange, func(_, _ state, source string) {
+			h.publish(t, source)
+		})
+		t.mu.Unlock()
+	}
+	return h
+}
+
+// publish queues a change event for each subscriber to t, dropping it for
+// those whose queue is full.
+func (h *eventHub) publish(t *tracked, source string) {
+	d := h.api.apiDevice(t)
+	e := streamEvent{Type: "change", Time: time.Now(), Source: source, Device: &d}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		if !s.wants(t) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			s.dropped++
+			select {
+			case s.lost <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func (h *eventHub) snapshot(s *subscriber) streamEvent {
+	e := streamEvent{Type: "snapshot", Time: time.Now()}
+	for _, t := range h.api.devices {
+		if s.wants(t) {
+			e.Devices = append(e.Devices, h.api.apiDevice(t))
+		}
+	}
+	return e
+}
+
+// run sends s a snapshot and then each event until send fails, done is
+// closed or the daemon stops. After an overflow it sends an overflow event
+// and a fresh snapshot in place of the events still queued.
+func (h *eventHub) run(s *subscriber, send func(streamEvent) error, done <-chan struct{}) {
+	h.mu.Lock()
+	h.subs[s] = true
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		delete(h.subs, s)
+		h.mu.Unlock()
+	}()
+
+	e := h.snapshot(s)
+	for {
+		if err := send(e); err != nil {
+			return
+		}
+		select {
+		case e = <-s.events:
+		case <-s.lost:
+			h.mu.Lock()
+			n := s.dropped
+			s.dropped = 0
+			h.mu.Unlock()
+		drain:
+			for {
+				select {
+				case <-s.events:
+				default:
+					break drain
+				}
+			}
+			if err := send(streamEvent{Type: "overflow", Time: time.Now(), Dropped: n}); err != nil {
+				return
+			}
+			e = h.snapshot(s)
+		case <-done:
+			return
+		case <-stopping:
+			return
+		}
+	}
+}
+
+// ServeHTTP handles GET /v1/events, streaming a snapshot of the devices and
+// then each change to them, as JSON. Clients that ask for a WebSocket get
+// one message per event; others get server-sent events. Repeat the device
+// parameter to follow only some devices:
+//
+//	GET /v1/events?device=Key+Light&device=3c:6a:9d:12:34:56
+func (h *eventHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	s, err := h.subscriber(r.URL.Query()["device"])
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		srv := websocket.Server{
+			Handshake: h.checkOrigin,
+			Handler: func(ws *websocket.Conn) {
+				// Reads only detect the client going away.
+				done := make(chan struct{})
+				go func() {
+					var msg []byte
+					for websocket.Message.Receive(ws, &msg) == nil {
+					}
+					close(done)
+				}()
+				h.run(s, func(e streamEvent) error { return websocket.JSON.Send(ws, e) }, done)
+			},
+		}
+		srv.ServeHTTP(w, r)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	h.run(s, func(e streamEvent) error {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", b); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}, r.Context().Done())
+}
+
+// checkOrigin accepts WebSockets from non-browser clients, which send no
+// Origin, and from pages allowed to use the API: the daemon's own (e.g. the
+// dashboard) and -cors-origin.
+func (h *eventHub) checkOrigin(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" || h.api.corsOrigin == "*" || origin == h.api.corsOrigin {
+		return nil
+	}
+	if u, err := url.Parse(origin); err == nil && u.Host == r.Host {
+		return nil
+	}
+	return fmt.Errorf("origin %s not allowed", origin)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+
+	"github.com/vsekhar/elgo"
+)
+
+// Exit statuses for failures that scripts may want to handle differently,
+// e.g. retrying only when a device is unreachable. Other errors exit with 1.
+const (
+	exitUsage       = 2
+	exitNoDevice    = 3
+	exitUnreachable = 4
+	exitDeviceError = 5
+	exitInterrupted = 130 // a ramp was stopped partway, as by Ctrl-C
+)
+
+// exitStatus returns the exit status for err.
+func exitStatus(err error) int {
+	var httpErr *elgo.HTTPError
+	var badResp *elgo.BadResponseError
+	switch {
+	case errors.Is(err, elgo.ErrInvalidBrightness), errors.Is(err, elgo.ErrInvalidTemperature):
+		return exitUsage
+	case errors.Is(err, elgo.ErrNoDeviceFound):
+		return exitNoDevice
+	case errors.Is(err, elgo.ErrDeviceUnreachable):
+		return exitUnreachable
+	case errors.As(err, &httpErr), errors.As(err, &badResp):
+		return exitDeviceError
+	case errors.Is(err, errInterrupted):
+		return exitInterrupted
+	}
+	return 1
+}
+
+// fatal logs err, like log.Fatal, and exits with its exit status. Once
+// interrupted, errors are most likely from that, so it just exits.
+func fatal(err error) {
+	if interrupted.Err() == nil {
+		log.Output(2, err.Error())
+	}
+	exit(exitStatus(err))
+}
+
+// exit records usage, finishes any profiles and exits with status code, or
+// exitInterrupted if interrupted.
+func exit(code int) {
+	if interrupted.Err() != nil {
+		code = exitInterrupted
+	}
+	recordUsage(code == 0 || code == exitOff)
+	reportTiming()
+	stopProfiling()
+	os.Exit(code)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+)
+
+// fade changes the lights of a device gradually to -brightness and
+// -temperature over -duration, turning them on. Interrupted, or if the
+// device is too slow to finish within -duration and -request-timeout, it
+// leaves them where they got to and prints that.
+func fade(args []string) {
+	fs := flag.NewFlagSet("fade", flag.ExitOnError)
+	duration := fs.Duration("duration", 5*time.Second, "how long to take")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fatal(errors.New("usage: elgo [-brightness N] [-temperature K] fade [-duration D]"))
+	}
+	if !brightness.isSet() && !temperature.isSet() {
+		fatal(errors.New("fade needs -brightness or -temperature"))
+	}
+	if *duration <= 0 {
+		fatal(errors.New("-duration must be positive"))
+	}
+
+	hostName := resolveHost()
+	longRunning = true
+	b, t, _ := checkRange(hostName)
+	from := getState(hostName)
+	if len(from.Lights) == 0 {
+		fatal(fmt.Errorf("%s: %w", hostName, errNoLights))
+	}
+	min := deviceRange(hostName).BrightnessMin
+	to := state{NumberOfLights: from.NumberOfLights}
+	for i, l := range from.Lights {
+		// Fade up from the lowest brightness a light that is off.
+		if l.On == 0 {
+			from.Lights[i].On, from.Lights[i].Brightness = 1, min
+		}
+		to.Lights = append(to.Lights, fadeTarget(hostName, l, b, t))
+	}
+
+	ctx, cancel := rampContext(time.Now().Add(*duration + *requestTimeoutFlag))
+	defer cancel()
+	last, err := ramp(ctx, hostName, from, to, *duration)
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		stoppedAt(hostName, last, ctx.Err())
+	default:
+		fatal(err)
+	}
+	putState(hostName, to)
+	deviceLog(hostName).debugf("faded to %s", describeLight(to.Lights[0]))
+}
+
+// fadeTarget returns l, on and with brightness b and temperature t if they
+// are set. Fields elgo doesn't know are left as l has them.
+func fadeTarget(hostName string, l light, b, t *setting) light {
+	target := l
+	target.On, target.Hue, target.Saturation = 1, nil, nil
+	if b.isSet() {
+		target.Brightness = b.apply(l.Brightness)
+	}
+	if t.isSet() {
+		k := t.n
+		if t.relative {
+			if l.Temperature == 0 {
+				fatal(fmt.Errorf("%s: device did not report a temperature to change", hostName))
+			}
+			k = t.apply(toKelvin(l.Temperature))
+		}
+		target.Temperature = deviceRange(hostName).temperature(k)
+	}
+	return target
+}
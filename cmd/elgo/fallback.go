@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var scan = flag.String("scan", "", "if mDNS is unavailable, probe this subnet (e.g. 192.168.1.0/24) for lights")
+
+// scanTimeout bounds each probe during a -scan, so unresponsive addresses
+// don't use up the overall timeout.
+const scanTimeout = time.Second
+
+// maxScan is the largest number of addresses -scan will probe.
+const maxScan = 4096
+
+// rememberDevices adds devices found via mDNS to the host cache, so that
+// they can be used if mDNS later becomes unavailable.
+func rememberDevices(found []device) {
+	if len(found) == 0 {
+		return
+	}
+	c := loadCache()
+	for _, d := range found {
+		key := d.key()
+		h, ok := c[key]
+		if old, found := c[d.HostName]; !ok && found && key != d.HostName {
+			// Move what was cached by address before the MAC was known.
+			h = old
+			delete(c, d.HostName)
+		}
+		h.Instance = d.Instance
+		if key != d.HostName {
+			h.HostName = d.HostName
+		}
+		h.IP = d.IP
+		if d.Model != "" {
+			h.Model = d.Model
+		}
+		h.Seen = time.Now()
+		c[key] = h
+	}
+	c.save()
+}
+
+// cachedDevices returns the devices previously found via mDNS, addressed by
+// IP where known since their .local names may not resolve without mDNS.
+func cachedDevices() []device {
+	var found []device
+	c := loadCache()
+	for key, h := range c {
+		if h.Instance == "" {
+			continue
+		}
+		hostName := c.hostName(key)
+		d := device{Instance: h.Instance, HostName: hostName, IP: h.IP, Model: h.Model}
+		if hostName != key {
+			d.MAC = key
+		}
+		if _, port, err := net.SplitHostPort(hostName); err == nil && h.IP != nil {
+			d.HostName = net.JoinHostPort(h.IP.String(), port)
+		}
+		found = append(found, d)
+	}
+	return found
+}
+
+// scanSubnet probes every address in cidr for the lights API and returns
+// those that respond.
+func scanSubnet(cidr string) ([]device, error) {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	ip4 := ipnet.IP.To4()
+	if ip4 == nil {
+		return nil, fmt.Errorf("-scan %s: only IPv4 subnets are supported", cidr)
+	}
+	ones, bits := ipnet.Mask.Size()
+	if n := 1 << uint(bits-ones); n > maxScan {
+		return nil, fmt.Errorf("-scan %s: subnet too large (%d addresses, at most %d)", cidr, n, maxScan)
+	}
+
+	client := &http.Client{Timeout: scanTimeout, Transport: transport}
+	first := binary.BigEndian.Uint32(ip4)
+	var mu sync.Mutex
+	var found []device
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 64)
+	for i := uint32(0); i < 1<<uint(bits-ones); i++ {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, first+i)
+		hostName := net.JoinHostPort(ip.String(), defaultPort)
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			resp, err := client.Get(deviceURL(hostName, lightsEndpoint))
+			if err != nil {
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+			discoveryLog.debugf("scan: found %s", hostName)
+			mu.Lock()
+			found = append(found, device{Instance: ip.String(), HostName: hostName, IP: ip})
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return found, nil
+}
+
+// fallbackDevices returns devices from -scan or the host cache when mDNS is
+// unavailable, failing only if neither is.
+func fallbackDevices(mdnsErr error) ([]device, error) {
+	began := time.Now()
+	if *scan != "" {
+		discoveryLog.warnf("mDNS unavailable (%v), scanning %s", mdnsErr, *scan)
+		found, err := scanSubnet(*scan)
+		noteDiscovery("scan", time.Since(began))
+		if err != nil {
+			return nil, err
+		}
+		if len(found) == 0 {
+			return nil, fmt.Errorf("mDNS unavailable (%v) and no lights found scanning %s", mdnsErr, *scan)
+		}
+		return found, nil
+	}
+	if found := cachedDevices(); len(found) > 0 {
+		noteDiscovery("cache", time.Since(began))
+		discoveryLog.warnf("mDNS unavailable (%v), using %d cached devices", mdnsErr, len(found))
+		return found, nil
+	}
+	return nil, fmt.Errorf("mDNS unavailable (%v): use -host, ELGO_HOST or -scan", mdnsErr)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var assumeOneLight = flag.Bool("assume-one-light", false, "toggle without reading the device first, assuming it has one light and is as elgo last left it, unless a running daemon saw it changed elsewhere since")
+
+// A deviceWatch records what the daemon has seen of a device, so that a toggle
+// can tell whether the state elgo last left it in is still current.
+type deviceWatch struct {
+	Changed time.Time `json:"changed"` // when it last saw a change not made by an elgo command
+}
+
+func watchPath() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "watched.json"), nil
+}
+
+var watchMu sync.Mutex
+
+// loadWatches returns the watches by device key (see hostCache.find).
+func loadWatches() (map[string]deviceWatch, error) {
+	watches := map[string]deviceWatch{}
+	path, err := watchPath()
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return watches, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return watches, json.Unmarshal(b, &watches)
+}
+
+// updateWatch applies f to the watch of the device at hostName. Failures
+// are logged, since they only cost toggles a read.
+func updateWatch(hostName string, f func(w *deviceWatch)) {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	watches, err := loadWatches()
+	var path string
+	if err == nil {
+		key := loadCache().find(hostName)
+		w := watches[key]
+		f(&w)
+		watches[key] = w
+		path, err = watchPath()
+	}
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+	}
+	if err == nil {
+		var b []byte
+		b, err = json.MarshalIndent(watches, "", "  ")
+		if err == nil {
+			err = ioutil.WriteFile(path, b, 0644)
+		}
+	}
+	if err != nil {
+		cmdLog.warnf("recording watch: %v", err)
+	}
+}
+
+// noteChange records that the device at hostName was seen in state s, as a
+// change made elsewhere unless it is the state an elgo command left it in.
+func noteChange(hostName string, s state) {
+	if last, err := loadLast(); err == nil {
+		ls := last[loadCache().find(hostName)].State
+		if len(s.Lights) == 1 && len(ls.Lights) == 1 &&
+			s.Lights[0].On == ls.Lights[0].On && sameSettings(s.Lights[0], ls.Lights[0]) {
+			return
+		}
+	}
+	updateWatch(hostName, func(w *deviceWatch) { w.Changed = time.Now() })
+}
+
+// sameSettings reports whether a and b have the same brightness, color
+// temperature and color, whether or not they are on.
+func sameSettings(a, b light) bool {
+	same := func(x, y *float64) bool { return x == nil && y == nil || x != nil && y != nil && *x == *y }
+	return a.Brightness == b.Brightness && a.Temperature == b.Temperature &&
+		same(a.Hue, b.Hue) && same(a.Saturation, b.Saturation)
+}
+
+// lastKnown returns the state elgo last left the device at hostName in, if
+// it has one light and no daemon has seen it changed elsewhere since.
+func lastKnown(hostName string) (state, bool) {
+	last, err := loadLast()
+	if err != nil {
+		return state{}, false
+	}
+	key := loadCache().find(hostName)
+	ls, ok := last[key]
+	if !ok || ls.State.NumberOfLights != 1 || len(ls.State.Lights) != 1 {
+		return state{}, false
+	}
+	if watches, err := loadWatches(); err == nil && watches[key].Changed.After(ls.At) {
+		return state{}, false
+	}
+	return ls.State, true
+}
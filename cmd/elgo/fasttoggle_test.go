@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLastKnown(t *testing.T) {
+	// Start from no recorded state, however often the test runs.
+	old := os.Getenv("XDG_DATA_HOME")
+	os.Setenv("XDG_DATA_HOME", t.TempDir())
+	defer os.Setenv("XDG_DATA_HOME", old)
+
+	// Each step must be recorded after the one before.
+	tick := func() { time.Sleep(time.Millisecond) }
+	hostName := "last-known.test:9123"
+	check := func(step string, want bool) {
+		t.Helper()
+		if _, ok := lastKnown(hostName); ok != want {
+			t.Errorf("%s: lastKnown = %v, want %v", step, ok, want)
+		}
+	}
+	on := state{NumberOfLights: 1, Lights: []light{{On: 1, Brightness: 40, Temperature: 200}}}
+
+	check("never set", false)
+	recordLast(hostName, on)
+	check("set", true)
+	noteChange(hostName, on)
+	check("daemon saw elgo's own change", true)
+	tick()
+	noteChange(hostName, state{NumberOfLights: 1, Lights: []light{{On: 0, Brightness: 40, Temperature: 200}}})
+	check("changed elsewhere", false)
+	tick()
+	recordLast(hostName, on)
+	check("set again", true)
+	recordLast(hostName, state{NumberOfLights: 2, Lights: []light{on.Lights[0], on.Lights[0]}})
+	check("two lights", false)
+}
+
+// Without -assume-one-light, toggles read the light even when elgo knows
+// the state it last left it in.
+func TestToggleReadsByDefault(t *testing.T) {
+	sim := testLight(true)
+	h := sim.handler(simDevice{name: "Sim", model: "Elgato Key Light", firmware: "1.0.3"})
+	var reads int32
+	hostName := fakeDevice(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/lights") {
+			atomic.AddInt32(&reads, 1)
+		}
+		h.ServeHTTP(w, r)
+	}))
+	recordLast(hostName, sim.lightState())
+	if l := act(hostName, "toggle"); l.On != 0 {
+		t.Errorf("toggle from on left it on: %+v", l)
+	}
+	if n := atomic.LoadInt32(&reads); n != 1 {
+		t.Errorf("toggle read the light %d times, want 1", n)
+	}
+}
+
+func TestBlindToggle(t *testing.T) {
+	*assumeOneLight = true
+	defer func() { *assumeOneLight = false }()
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	sim := testLight(true)
+	h := sim.handler(simDevice{name: "Sim", model: "Elgato Key Light", firmware: "1.0.3"})
+	var reads int32
+	hostName := fakeDevice(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/lights") {
+			atomic.AddInt32(&reads, 1)
+		}
+		h.ServeHTTP(w, r)
+	}))
+	set := func(on, brightness int) state {
+		sim.mu.Lock()
+		sim.state.Lights[0].On, sim.state.Lights[0].Brightness = on, brightness
+		sim.mu.Unlock()
+		return sim.lightState()
+	}
+	recordLast(hostName, sim.lightState())
+
+	if l := act(hostName, "toggle"); l.On != 0 || sim.lightState().Lights[0].On != 0 {
+		t.Errorf("toggle from on left it on: %+v", l)
+	}
+	if n := atomic.LoadInt32(&reads); n != 0 {
+		t.Errorf("blind toggle read the light %d times", n)
+	}
+
+	// Changed elsewhere, with nothing watching: the toggle can only tell
+	// afterwards.
+	set(1, 70)
+	act(hostName, "toggle")
+	if !strings.Contains(logged.String(), "changed elsewhere") {
+		t.Errorf("no warning of a stale last known state; logged %q", logged.String())
+	}
+
+	// Changed elsewhere, seen by a daemon: the toggle reads the light first.
+	time.Sleep(time.Millisecond)
+	noteChange(hostName, set(0, 30))
+	if l := act(hostName, "toggle"); l.On != 1 || sim.lightState().Lights[0].On != 1 {
+		t.Errorf("toggle from off after a change elsewhere left it off: %+v", l)
+	}
+	if n := atomic.LoadInt32(&reads); n != 1 {
+		t.Errorf("toggle after a change elsewhere read the light %d times, want 1", n)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var minFirmware = flag.String("min-firmware", "", "refuse to change a device whose firmware is older than this version (e.g. 1.0.3)")
+
+const accessoryInfoEndpoint = "accessory-info"
+
+// accessoryInfo is the subset of a device's accessory-info used by elgo.
+type accessoryInfo struct {
+	ProductName         string    `json:"productName"`
+	FirmwareBuildNumber int       `json:"firmwareBuildNumber"`
+	FirmwareVersion     string    `json:"firmwareVersion"`
+	SerialNumber        string    `json:"serialNumber"`
+	DisplayName         string    `json:"displayName"`
+	WifiInfo            *wifiInfo `json:"wifi-info,omitempty"`
+	lightRange
+}
+
+func fetchAccessoryInfo(hostName string) (accessoryInfo, error) {
+	respJson, err := request(http.MethodGet, deviceURL(hostName, accessoryInfoEndpoint), nil)
+	if err != nil {
+		return accessoryInfo{}, err
+	}
+	info := accessoryInfo{}
+	if err := json.Unmarshal(respJson, &info); err != nil {
+		return accessoryInfo{}, badJSON(hostName, "accessory-info", respJson, err)
+	}
+	return info, nil
+}
+
+// cachedInfo returns the cached details of the device at hostName,
+// fetching its accessory-info again if they are missing (as reported by
+// missing) or stale.
+func cachedInfo(hostName string, missing func(cachedHost) bool) (cachedHost, error) {
+	c := loadCache()
+	key := c.find(hostName)
+	if h, ok := c[key]; ok && !missing(h) && time.Since(h.Updated) < cacheTTL {
+		return h, nil
+	}
+	info, err := fetchAccessoryInfo(hostName)
+	if err != nil {
+		return cachedHost{}, err
+	}
+	return c.recordInfo(key, info), nil
+}
+
+// recordInfo caches the details in info of the device with key, and saves
+// the cache.
+func (c hostCache) recordInfo(key string, info accessoryInfo) cachedHost {
+	r := info.lightRange.or(lookupModel(info.ProductName).Range)
+	h := c[key]
+	h.Product = info.ProductName
+	h.Firmware = info.FirmwareVersion
+	h.Range = &r
+	h.Updated = time.Now()
+	c[key] = h
+	c.save()
+	return h
+}
+
+// newestFirmware returns the newest firmware version cached for any device
+// of product, or "" if none is known.
+func (c hostCache) newestFirmware(product string) string {
+	var newest string
+	var nv []int
+	for _, h := range c {
+		if h.Product != product {
+			continue
+		}
+		if v, err := parseVersion(h.Firmware); err == nil && (nv == nil || versionLess(nv, v)) {
+			newest, nv = h.Firmware, v
+		}
+	}
+	return newest
+}
+
+// olderThan reports whether version a is older than b, treating versions
+// that don't parse as not older.
+func olderThan(a, b string) bool {
+	av, err1 := parseVersion(a)
+	bv, err2 := parseVersion(b)
+	return err1 == nil && err2 == nil && versionLess(av, bv)
+}
+
+// firmwareVersion returns the firmware version of the device at hostName,
+// from the host cache if fresh.
+func firmwareVersion(hostName string) (string, error) {
+	h, err := cachedInfo(hostName, func(h cachedHost) bool { return h.Firmware == "" })
+	if err != nil {
+		return "", err
+	}
+	if h.Firmware == "" {
+		return "", fmt.Errorf("%s: device did not report a firmware version", hostName)
+	}
+	return h.Firmware, nil
+}
+
+// parseVersion parses a dotted version such as 1.0.3.
+func parseVersion(v string) ([]int, error) {
+	var parts []int
+	for _, f := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("bad version: %q", v)
+		}
+		parts = append(parts, n)
+	}
+	return parts, nil
+}
+
+// versionLess reports whether version a is older than b. Missing trailing
+// components count as zero.
+func versionLess(a, b []int) bool {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		x, y := 0, 0
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			return x < y
+		}
+	}
+	return false
+}
+
+// requireFirmware returns an error naming feature if the device at hostName
+// runs firmware older than min. Devices silently ignore fields they don't
+// support, so features that need newer firmware should check first.
+func requireFirmware(hostName, feature, min string) error {
+	want, err := parseVersion(min)
+	if err != nil {
+		return err
+	}
+	have, err := firmwareVersion(hostName)
+	if err != nil {
+		return fmt.Errorf("%s: checking firmware: %v", hostName, err)
+	}
+	got, err := parseVersion(have)
+	if err != nil {
+		return fmt.Errorf("%s: %v", hostName, err)
+	}
+	if versionLess(got, want) {
+		return fmt.Errorf("%s: %s requires firmware %s or newer, device has %s", hostName, feature, min, have)
+	}
+	deviceLog(hostName).debugf("firmware %s", have)
+	return nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestOlderThan(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want bool
+	}{
+		{"1.0.2", "1.0.3", true},
+		{"1.0.3", "1.0.3", false},
+		{"1.0", "1.0.0", false},
+		{"1.0.9", "1.0.10", true},
+		{"2.0", "1.9.9", false},
+		{"beta", "1.0.3", false},
+	} {
+		if got := olderThan(tt.a, tt.b); got != tt.want {
+			t.Errorf("olderThan(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
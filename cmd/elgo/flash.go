@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// flash pulses every device a number of times as a visual cue, then
+// restores exactly the state each was in before, even if interrupted.
+func flash(args []string) {
+	fs := flag.NewFlagSet("flash", flag.ExitOnError)
+	times := fs.Int("times", 2, "number of pulses")
+	interval := fs.Duration("interval", 400*time.Millisecond, "duration of each half of a pulse")
+	mode := fs.String("mode", "toggle", "how to pulse: toggle (power) or brightness")
+	pulse := fs.Int("pulse-brightness", 0, "brightness to pulse to in brightness mode (default 100, or 10 for lights brighter than 50)")
+	fs.Parse(args)
+	switch *mode {
+	case "toggle", "brightness":
+	default:
+		fatal(fmt.Errorf("bad -mode: %s", *mode))
+	}
+	if *times < 1 {
+		fatal(errors.New("-times must be at least 1"))
+	}
+	if *pulse < 0 || *pulse > 100 {
+		fatal(errors.New("-pulse-brightness must be between 1 and 100"))
+	}
+
+	found := discoverAll()
+	longRunning = true
+	ctx, cancel := rampContext(pulseDeadline(*times, *interval))
+	defer cancel()
+	pulseDevices(ctx, found, *times, *interval, *mode, *pulse)
+}
+
+// pulseDeadline returns when pulsing times, each half of a pulse lasting
+// interval, should have finished, allowing for slow requests.
+func pulseDeadline(times int, interval time.Duration) time.Time {
+	return time.Now().Add(2*time.Duration(times)*interval + *requestTimeoutFlag)
+}
+
+// pulseDevices pulses devices times, each half of a pulse lasting interval,
+// in mode (toggle or brightness, to pulse, or 0 for the default), then
+// restores their states, stopping early once ctx is done.
+func pulseDevices(ctx context.Context, found []device, times int, interval time.Duration, mode string, pulse int) {
+	saved := capture(found)
+	defer saved.restore(found)
+
+	// pulsed returns the state to flash to from s.
+	pulsed := func(s state) state {
+		p := state{NumberOfLights: len(s.Lights)}
+		for _, l := range s.Lights {
+			l.Temperature = 0
+			switch mode {
+			case "toggle":
+				l.On = 1 - l.On
+				l.Brightness = 0
+			case "brightness":
+				b := pulse
+				if b == 0 {
+					b = 100
+					if l.On != 0 && l.Brightness > 50 {
+						b = 10
+					}
+				}
+				l.On, l.Brightness = 1, b
+			}
+			p.Lights = append(p.Lights, l)
+		}
+		return p
+	}
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for i := 0; i < 2*times; i++ {
+		// Wait for each step's requests, so none can land after the
+		// final restore.
+		wg := sync.WaitGroup{}
+		for _, d := range found {
+			s, ok := saved[d.HostName]
+			if !ok {
+				continue
+			}
+			if i%2 == 0 {
+				s = pulsed(s)
+			}
+			wg.Add(1)
+			go func(d device, s state) {
+				defer wg.Done()
+				if _, err := sendState(d.HostName, s); err != nil {
+					cmdLog.warnf("%s: %v", d.Instance, err)
+				}
+			}(d, s)
+		}
+		wg.Wait()
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
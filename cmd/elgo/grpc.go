@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/vsekhar/elgo"
+	"github.com/vsekhar/elgo/daemonpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcServer serves the daemonpb API, backed by the same devices as the
+// HTTP API.
+type grpcServer struct {
+	daemonpb.UnimplementedDaemonServer
+	api *api
+}
+
+// grpcReads are the unary methods that don't change anything.
+var grpcReads = map[string]bool{
+	"/elgo.daemon.v1.Daemon/ListDevices": true,
+	"/elgo.daemon.v1.Daemon/GetState":    true,
+}
+
+// serveGRPC starts serving the gRPC API on addr.
+func serveGRPC(addr string, a *api) *grpc.Server {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal(err)
+	}
+	srv := newGRPCServer(a)
+	go func() {
+		apiLog.infof("serving gRPC on %s", addr)
+		if err := srv.Serve(lis); err != nil {
+			fatal(err)
+		}
+	}()
+	return srv
+}
+
+// newGRPCServer returns a server for the gRPC API of a.
+func newGRPCServer(a *api) *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, h grpc.UnaryHandler) (interface{}, error) {
+			if err := a.grpcAuth(ctx, !grpcReads[info.FullMethod]); err != nil {
+				return nil, err
+			}
+			return h(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, h grpc.StreamHandler) error {
+			if err := a.grpcAuth(ss.Context(), false); err != nil {
+				return err
+			}
+			return h(srv, ss)
+		}),
+	)
+	daemonpb.RegisterDaemonServer(srv, &grpcServer{api: a})
+	return srv
+}
+
+// stopGRPC stops srv, waiting until ctx is done for calls in progress.
+func stopGRPC(ctx context.Context, srv *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		srv.Stop()
+	}
+}
+
+// grpcAuth requires the API token, in the authorization metadata as for
+// the HTTP API, for calls that change anything and, with -auth-reads, for
+// all calls.
+func (a *api) grpcAuth(ctx context.Context, mutating bool) error {
+	if a.token == "" || !mutating && !a.authReads {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		if strings.HasPrefix(v, "Bearer ") && a.validToken(strings.TrimPrefix(v, "Bearer ")) {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "unauthorized")
+}
+
+func pbDevice(d apiDevice) *daemonpb.Device {
+	pd := &daemonpb.Device{
+		Name:  d.Name,
+		Host:  d.Host,
+		Mac:   d.MAC,
+		Stale: d.Stale,
+	}
+	if !d.UpdatedAt.IsZero() {
+		pd.UpdatedAt = timestamppb.New(d.UpdatedAt)
+	}
+	for _, l := range d.State.Lights {
+		pd.Lights = append(pd.Lights, &daemonpb.Light{
+			On:          l.On != 0,
+			Brightness:  int32(l.Brightness),
+			Temperature: int32(l.Temperature),
+		})
+	}
+	return pd
+}
+
+func (g *grpcServer) find(name string) (*tracked, error) {
+	t := g.api.find(name)
+	if t == nil {
+		return nil, status.Errorf(codes.NotFound, "no such device: %s", name)
+	}
+	return t, nil
+}
+
+func (g *grpcServer) ListDevices(ctx context.Context, req *daemonpb.ListDevicesRequest) (*daemonpb.ListDevicesResponse, error) {
+	resp := &daemonpb.ListDevicesResponse{}
+	for _, t := range g.api.devices {
+		resp.Devices = append(resp.Devices, pbDevice(g.api.apiDevice(t)))
+	}
+	return resp, nil
+}
+
+func (g *grpcServer) GetState(ctx context.Context, req *daemonpb.GetStateRequest) (*daemonpb.Device, error) {
+	t, err := g.find(req.Device)
+	if err != nil {
+		return nil, err
+	}
+	return pbDevice(g.api.apiDevice(t)), nil
+}
+
+func (g *grpcServer) SetState(ctx context.Context, req *daemonpb.SetStateRequest) (*daemonpb.Device, error) {
+	t, err := g.find(req.Device)
+	if err != nil {
+		return nil, err
+	}
+	if req.Light == nil {
+		return nil, status.Error(codes.InvalidArgument, "no light given")
+	}
+	l := light{Brightness: int(req.Light.Brightness), Temperature: int(req.Light.Temperature)}
+	if req.Light.On {
+		l.On = 1
+	}
+	// Zero leaves a property unchanged.
+	if l, err = limitDeviceLight(t.dev.HostName, l); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if _, err := t.set(l); err != nil {
+		return nil, grpcDeviceError(err)
+	}
+	return pbDevice(g.api.apiDevice(t)), nil
+}
+
+// grpcDeviceError returns the status for err from a device: the device
+// refusing a request is the caller's problem, and anything else, including
+// the device staying busy, is the device being unavailable.
+func grpcDeviceError(err error) error {
+	var httpErr *elgo.HTTPError
+	if errors.As(err, &httpErr) && httpErr.Status >= 400 && httpErr.Status < 500 && !busyStatuses[httpErr.Status] {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return status.Error(codes.Unavailable, err.Error())
+}
+
+func (g *grpcServer) Toggle(ctx context.Context, req *daemonpb.ToggleRequest) (*daemonpb.Device, error) {
+	t, err := g.find(req.Device)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := t.toggle(); err != nil {
+		return nil, grpcDeviceError(err)
+	}
+	return pbDevice(g.api.apiDevice(t)), nil
+}
+
+// pbEventTypes maps streamEvent types to their daemonpb equivalents.
+var pbEventTypes = map[string]daemonpb.Event_Type{
+	"snapshot": daemonpb.Event_SNAPSHOT,
+	"change":   daemonpb.Event_CHANGE,
+	"overflow": daemonpb.Event_OVERFLOW,
+}
+
+// WatchEvents streams the same events as /v1/events.
+func (g *grpcServer) WatchEvents(req *daemonpb.WatchEventsRequest, stream daemonpb.Daemon_WatchEventsServer) error {
+	s, err := g.api.events.subscriber(req.Devices)
+	if err != nil {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	g.api.events.run(s, func(e streamEvent) error {
+		pe := &daemonpb.Event{
+			Type:    pbEventTypes[e.Type],
+			Source:  e.Source,
+			Dropped: int32(e.Dropped),
+			Time:    timestamppb.New(e.Time),
+		}
+		if e.Device != nil {
+			pe.Device = pbDevice(*e.Device)
+		}
+		for _, d := range e.Devices {
+			pe.Devices = append(pe.Devices, pbDevice(d))
+		}
+		return stream.Send(pe)
+	}, stream.Context().Done())
+	return stream.Context().Err()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/vsekhar/elgo/daemonpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// grpcClient serves the gRPC API of a in memory for the rest of the test
+// and returns a client of it.
+func grpcClient(t *testing.T, a *api) daemonpb.DaemonClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := newGRPCServer(a)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.DialContext(context.Background(), "bufconn",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return daemonpb.NewDaemonClient(conn)
+}
+
+func TestGRPC(t *testing.T) {
+	sim := testLight(false)
+	h := sim.handler(simDevice{name: "Sim", model: "Elgato Key Light", firmware: "1.0.3"})
+	broken := fakeDevice(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			http.Error(w, "oops", http.StatusInternalServerError)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+	tr := &tracked{dev: device{Instance: "Key Light", HostName: fakeDevice(t, h)}}
+	brokenTr := &tracked{dev: device{Instance: "Broken", HostName: broken}}
+	for _, d := range []*tracked{tr, brokenTr} {
+		if err := d.refresh(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := grpcClient(t, &api{devices: []*tracked{tr, brokenTr}, token: "secret"})
+	ctx := context.Background()
+	authed := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+
+	list, err := c.ListDevices(ctx, &daemonpb.ListDevicesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Devices) != 2 || list.Devices[0].Name != "Key Light" || list.Devices[0].Lights[0].On {
+		t.Errorf("ListDevices: %v", list.Devices)
+	}
+
+	d, err := c.SetState(authed, &daemonpb.SetStateRequest{Device: "Key Light", Light: &daemonpb.Light{On: true, Brightness: 60, Temperature: 200}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := d.Lights[0]; !l.On || l.Brightness != 60 || l.Temperature != 200 {
+		t.Errorf("SetState returned %v", d)
+	}
+	if s := sim.lightState(); s.Lights[0].On != 1 || s.Lights[0].Brightness != 60 || s.Lights[0].Temperature != 200 {
+		t.Errorf("after SetState: %+v", s)
+	}
+
+	d, err = c.Toggle(authed, &daemonpb.ToggleRequest{Device: "Key Light"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Lights[0].On || sim.lightState().Lights[0].On != 0 {
+		t.Errorf("still on after Toggle: %v", d)
+	}
+
+	for _, tt := range []struct {
+		name string
+		ctx  context.Context
+		req  *daemonpb.SetStateRequest
+		code codes.Code
+	}{
+		{"no token", ctx, &daemonpb.SetStateRequest{Device: "Key Light", Light: &daemonpb.Light{On: true}}, codes.Unauthenticated},
+		{"no light", authed, &daemonpb.SetStateRequest{Device: "Key Light"}, codes.InvalidArgument},
+		{"brightness 150", authed, &daemonpb.SetStateRequest{Device: "Key Light", Light: &daemonpb.Light{On: true, Brightness: 150}}, codes.InvalidArgument},
+		{"temperature 100", authed, &daemonpb.SetStateRequest{Device: "Key Light", Light: &daemonpb.Light{On: true, Temperature: 100}}, codes.InvalidArgument},
+		{"no such device", authed, &daemonpb.SetStateRequest{Device: "Nope", Light: &daemonpb.Light{On: true}}, codes.NotFound},
+		{"device error", authed, &daemonpb.SetStateRequest{Device: "Broken", Light: &daemonpb.Light{On: true}}, codes.Unavailable},
+	} {
+		_, err := c.SetState(tt.ctx, tt.req)
+		if got := status.Code(err); got != tt.code {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.code)
+		}
+	}
+	if s := sim.lightState(); s.Lights[0].On != 0 || s.Lights[0].Brightness != 60 {
+		t.Errorf("refused calls changed the device: %+v", s)
+	}
+
+	*clampRange = true
+	defer func() { *clampRange = false }()
+	if _, err := c.SetState(authed, &daemonpb.SetStateRequest{Device: "Key Light", Light: &daemonpb.Light{On: true, Brightness: 150}}); err != nil {
+		t.Errorf("brightness 150 with -clamp: %v", err)
+	}
+	if s := sim.lightState(); s.Lights[0].Brightness != 100 {
+		t.Errorf("brightness 150 with -clamp set %+v, want brightness 100", s)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+var recordHistory = flag.Bool("history", false, "append changes made by this command to the history log")
+
+// sourceCLI marks changes made by one-shot commands.
+const sourceCLI = "cli"
+
+// historyEntry is one line of the history log: a change to one field of a
+// light, and what made it.
+type historyEntry struct {
+	event
+	Source string `json:"source"`
+}
+
+// dataDir returns the directory for elgo's persistent data, following the
+// XDG base directory spec on Unix.
+func dataDir() (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "elgo"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		return filepath.Join(home, "Library", "Application Support", "elgo"), nil
+	case "windows":
+		if dir := os.Getenv("LocalAppData"); dir != "" {
+			return filepath.Join(dir, "elgo"), nil
+		}
+	}
+	return filepath.Join(home, ".local", "share", "elgo"), nil
+}
+
+func historyPath() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "history.jsonl"), nil
+}
+
+var historyMu sync.Mutex
+
+// appendHistory adds events to the history log. Failures are logged, since
+// history is never worth failing a change for.
+func appendHistory(events []event, source string) {
+	if len(events) == 0 {
+		return
+	}
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	path, err := historyPath()
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+	}
+	var f *os.File
+	if err == nil {
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	}
+	if err != nil {
+		cmdLog.warnf("history: %v", err)
+		return
+	}
+	defer f.Close()
+	for _, e := range events {
+		b, _ := json.Marshal(historyEntry{e, source})
+		if _, err := f.Write(append(b, '\n')); err != nil {
+			cmdLog.warnf("history: %v", err)
+			return
+		}
+	}
+}
+
+// readHistory calls f for each entry in the history log, in order. A
+// missing log has no entries.
+func readHistory(f func(historyEntry)) error {
+	path, err := historyPath()
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	s := bufio.NewScanner(file)
+	for n := 1; s.Scan(); n++ {
+		var e historyEntry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			cmdLog.warnf("history: %s:%d: %v", path, n, err)
+			continue
+		}
+		f(e)
+	}
+	return s.Err()
+}
+
+// pruneHistory removes entries older than retention from the history log.
+func pruneHistory(retention time.Duration) error {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	path, err := historyPath()
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-retention)
+	var kept []historyEntry
+	pruned := 0
+	if err := readHistory(func(e historyEntry) {
+		if e.Time.Before(cutoff) {
+			pruned++
+		} else {
+			kept = append(kept, e)
+		}
+	}); err != nil || pruned == 0 {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range kept {
+		b, _ := json.Marshal(e)
+		w.Write(append(b, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	cmdLog.infof("history: pruned %d entries older than %s", pruned, cutoff.Format(time.RFC3339))
+	return os.Rename(tmp, path)
+}
+
+// observed returns the history entries marking the start (with the state
+// of the device at the time) or the end of observation of d. Between them,
+// the history log is a complete record of changes to d.
+func observed(d device, start bool, s state) []event {
+	now := time.Now()
+	if !start {
+		return []event{{now, d.Instance, d.HostName, d.MAC, "observed", 1, 0}}
+	}
+	events := []event{{now, d.Instance, d.HostName, d.MAC, "observed", 0, 1}}
+	if len(s.Lights) > 0 {
+		l := s.Lights[0]
+		events = append(events,
+			event{now, d.Instance, d.HostName, d.MAC, "on", l.On, l.On},
+			event{now, d.Instance, d.HostName, d.MAC, "brightness", l.Brightness, l.Brightness})
+	}
+	return events
+}
+
+// historyHeartbeat is how often the daemon notes in the history log that it
+// is still observing a device, which bounds what stats leaves out when the
+// daemon stops without saying so.
+const historyHeartbeat = time.Hour
+
+// stillObserved returns the history entry noting that d is still being
+// observed.
+func stillObserved(d device) []event {
+	return []event{{time.Now(), d.Instance, d.HostName, d.MAC, "observed", 1, 1}}
+}
+
+// matchDevice reports whether e is about the device named, addressed or
+// with the MAC address name, ignoring case. An empty name matches every
+// device.
+func matchDevice(e event, name string) bool {
+	return name == "" || device{Instance: e.Device, HostName: e.Host, MAC: e.MAC}.matches(name)
+}
+
+// history prints the entries in the history log.
+func history(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	since := longDuration(24 * time.Hour)
+	fs.Var(&since, "since", "show changes made within this long, e.g. 12h or 7d")
+	deviceName := fs.String("device", "", "only show changes to the device with this name, host or MAC address")
+	fs.Parse(args)
+
+	cutoff := time.Now().Add(-time.Duration(since))
+	err := readHistory(func(e historyEntry) {
+		if e.Time.Before(cutoff) || !matchDevice(e.event, *deviceName) {
+			return
+		}
+		if e.Field == "observed" && e.Old == e.New {
+			return // a heartbeat
+		}
+		fmt.Printf("%s  %s (%s)\n", e.Time.Local().Format("2006-01-02 15:04:05"), e.event, e.Source)
+	})
+	if err != nil {
+		fatal(err)
+	}
+}
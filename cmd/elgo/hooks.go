@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+var preHook = flag.String("pre-hook", "", "command to run (with sh -c) before changing a light")
+var postHook = flag.String("post-hook", "", "command to run (with sh -c) after changing a light")
+var hookFailFatal = flag.Bool("hook-fail-fatal", false, "abort if a hook fails instead of just reporting it")
+
+// runStateHook runs command with the target host and state in its
+// environment. Variables are prefixed ELGO_HOOK_ so that they don't become
+// defaults (e.g. ELGO_BRIGHTNESS) for elgo if the hook runs it.
+func runStateHook(phase, command, hostName string, s state) {
+	if command == "" {
+		return
+	}
+	j, err := json.Marshal(s)
+	if err != nil {
+		fatal(err)
+	}
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		"ELGO_HOOK_PHASE="+phase,
+		"ELGO_HOOK_HOST="+hostName,
+		"ELGO_HOOK_STATE="+string(j),
+	)
+	if len(s.Lights) > 0 {
+		l := s.Lights[0]
+		cmd.Env = append(cmd.Env,
+			"ELGO_HOOK_ON="+strconv.Itoa(l.On),
+			"ELGO_HOOK_BRIGHTNESS="+strconv.Itoa(l.Brightness),
+		)
+		if l.Temperature != 0 {
+			cmd.Env = append(cmd.Env, "ELGO_HOOK_TEMPERATURE="+strconv.Itoa(toKelvin(l.Temperature)))
+		}
+	}
+	// With -q, only a hook's errors are shown.
+	if !*quiet {
+		cmd.Stdout = os.Stderr
+	}
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		msg := fmt.Sprintf("%s-hook %q: %v", phase, command, err)
+		if *hookFailFatal {
+			fatal(errors.New(msg))
+		}
+		deviceLog(hostName).warnf("%s", msg)
+	}
+}
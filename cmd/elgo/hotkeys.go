@@ -0,0 +1,276 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A hotkey is a key combination such as "ctrl+alt+l".
+type hotkey struct {
+	mods modifiers
+	key  string // lower case key name, e.g. "l" or "up"
+}
+
+type modifiers uint8
+
+const (
+	modShift modifiers = 1 << iota
+	modCtrl
+	modAlt
+	modSuper
+)
+
+var modifierNames = []struct {
+	mod  modifiers
+	name string
+}{
+	{modShift, "shift"},
+	{modCtrl, "ctrl"},
+	{modAlt, "alt"},
+	{modSuper, "super"},
+}
+
+var modifierAliases = map[string]string{
+	"control": "ctrl",
+	"option":  "alt",
+	"meta":    "alt",
+	"cmd":     "super",
+	"win":     "super",
+}
+
+func parseHotkey(s string) (hotkey, error) {
+	parts := strings.Split(strings.ToLower(s), "+")
+	h := hotkey{key: strings.TrimSpace(parts[len(parts)-1])}
+	if h.key == "" {
+		return hotkey{}, fmt.Errorf("bad hotkey %q: no key", s)
+	}
+parts:
+	for _, m := range parts[:len(parts)-1] {
+		m = strings.TrimSpace(m)
+		if a, ok := modifierAliases[m]; ok {
+			m = a
+		}
+		for _, n := range modifierNames {
+			if n.name == m {
+				h.mods |= n.mod
+				continue parts
+			}
+		}
+		return hotkey{}, fmt.Errorf("bad hotkey %q: unknown modifier %q", s, m)
+	}
+	return h, nil
+}
+
+func (h hotkey) String() string {
+	var parts []string
+	for _, n := range modifierNames {
+		if h.mods&n.mod != 0 {
+			parts = append(parts, n.name)
+		}
+	}
+	return strings.Join(append(parts, h.key), "+")
+}
+
+// hotkeyBindings maps hotkeys to actions, from repeated -bind KEYS=ACTION
+// flags. Setting it replaces the defaults.
+type hotkeyBindings struct {
+	m       map[string]string // hotkey string to action
+	changed bool
+}
+
+func (b *hotkeyBindings) String() string {
+	var s []string
+	for k, v := range b.m {
+		s = append(s, k+"="+v)
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+func (b *hotkeyBindings) Set(s string) error {
+	i := strings.LastIndex(s, "=")
+	if i < 0 {
+		return fmt.Errorf("expected KEYS=ACTION, got %q", s)
+	}
+	h, err := parseHotkey(s[:i])
+	if err != nil {
+		return err
+	}
+	if err := checkHotkeyAction(s[i+1:]); err != nil {
+		return err
+	}
+	if !b.changed {
+		b.m = map[string]string{}
+		b.changed = true
+	}
+	b.m[h.String()] = s[i+1:]
+	return nil
+}
+
+// checkHotkeyAction returns an error if action is not one of toggle,
+// brighter, dimmer, warmer, cooler or a preset.
+func checkHotkeyAction(action string) error {
+	switch action {
+	case "toggle", "brighter", "dimmer", "warmer", "cooler":
+		return nil
+	}
+	_, err := parsePreset(action)
+	return err
+}
+
+// loadHotkeyBindings returns the bindings in the hotkeys section of the
+// config file, by hotkey, or nil if it has none:
+//
+//	hotkeys:
+//	  ctrl+alt+l: toggle
+//	  ctrl+alt+w: on@40/3200K
+func loadHotkeyBindings() (map[string]string, error) {
+	raw, path, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+	section, ok := raw["hotkeys"].(map[interface{}]interface{})
+	if raw["hotkeys"] != nil && !ok {
+		return nil, fmt.Errorf("%s: hotkeys: want hotkeys and their actions", path)
+	}
+	if len(section) == 0 {
+		return nil, nil
+	}
+	m := map[string]string{}
+	for k, v := range section {
+		h, err := parseHotkey(fmt.Sprint(k))
+		if err != nil {
+			return nil, fmt.Errorf("%s: hotkeys: %v", path, err)
+		}
+		action := fmt.Sprint(v)
+		if err := checkHotkeyAction(action); err != nil {
+			return nil, fmt.Errorf("%s: hotkeys: %s: %v", path, k, err)
+		}
+		m[h.String()] = action
+	}
+	return m, nil
+}
+
+// adjust returns l with brightness and temperature (in Kelvin) moved by the
+// given amounts, clamped to the valid ranges.
+func adjust(l light, brightnessDelta, kelvinDelta int) light {
+	r := light{On: l.On}
+	if brightnessDelta != 0 {
+		r.Brightness = clamp(l.Brightness+brightnessDelta, 1, 100)
+	}
+	if kelvinDelta != 0 && l.Temperature != 0 {
+		r.Temperature = defaultRange.temperature(toKelvin(l.Temperature) + kelvinDelta)
+	}
+	return r
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// hotkeys registers global hotkeys and applies their actions to every device
+// found at startup. Bindings come from -bind flags, or else the hotkeys
+// section of the config file, or else the defaults. They are registered with
+// X11, the Wayland GlobalShortcuts portal or, on macOS, Carbon.
+func hotkeys(args []string) {
+	fs := flag.NewFlagSet("hotkeys", flag.ExitOnError)
+	bindings := &hotkeyBindings{m: map[string]string{
+		"ctrl+alt+l":    "toggle",
+		"ctrl+alt+up":   "brighter",
+		"ctrl+alt+down": "dimmer",
+	}}
+	fs.Var(bindings, "bind", "bind a hotkey to an action, as KEYS=ACTION where ACTION is toggle, brighter, dimmer, warmer, cooler or a preset (repeatable, replacing the config file's hotkeys)")
+	brightnessStep := fs.Int("brightness-step", 10, "brightness change for brighter and dimmer")
+	temperatureStep := fs.Int("temperature-step", 300, "temperature change in Kelvin for warmer and cooler")
+	interval := fs.Duration("interval", 10*time.Second, "device polling interval, to keep cached state fresh")
+	fs.Parse(args)
+	if !bindings.changed {
+		m, err := loadHotkeyBindings()
+		if err != nil {
+			fatal(err)
+		}
+		if m != nil {
+			bindings.m = m
+		}
+	}
+
+	// Resolve devices and their state once, so that keypresses only cost
+	// the request that applies them.
+	found := discoverAll()
+	longRunning = true
+	var devices []*tracked
+	for _, d := range found {
+		t := &tracked{dev: d}
+		if err := t.refresh(); err != nil {
+			cmdLog.warnf("%s: %v", d.Instance, err)
+		}
+		go t.poll(*interval)
+		devices = append(devices, t)
+	}
+
+	do := func(action string) {
+		for _, t := range devices {
+			go func(t *tracked) {
+				var err error
+				s, _ := t.current()
+				switch action {
+				case "toggle":
+					_, err = t.toggle()
+				case "brighter", "dimmer", "warmer", "cooler":
+					if len(s.Lights) == 0 {
+						err = errNoLights
+						break
+					}
+					deltas := map[string][2]int{
+						"brighter": {*brightnessStep, 0},
+						"dimmer":   {-*brightnessStep, 0},
+						"warmer":   {0, -*temperatureStep},
+						"cooler":   {0, *temperatureStep},
+					}[action]
+					_, err = t.set(adjust(s.Lights[0], deltas[0], deltas[1]))
+				default:
+					p, _ := parsePreset(action)
+					var ps state
+					if ps, err = p.stateFor(t.dev.HostName); err == nil {
+						_, err = t.set(ps.Lights[0])
+					}
+				}
+				if err != nil {
+					cmdLog.warnf("%s: %s: %v", t.dev.Instance, action, err)
+				}
+			}(t)
+		}
+	}
+
+	keys := map[hotkey]string{}
+	for k, action := range bindings.m {
+		h, _ := parseHotkey(k)
+		keys[h] = action
+	}
+	pressed, err := registerHotkeys(keys)
+	if err != nil {
+		fatal(err)
+	}
+	go func() {
+		for action := range pressed {
+			cmdLog.debugf("hotkey: %s", action)
+			do(action)
+		}
+	}()
+
+	ownSignals()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	waitHotkeys(sig)
+}
@@ -0,0 +1,154 @@
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package main
+
+/*
+#cgo LDFLAGS: -framework Carbon
+#include <Carbon/Carbon.h>
+#include <stdint.h>
+#include <unistd.h>
+
+// hotkeyPressed writes the ID of each hotkey pressed to the pipe whose
+// write end is data.
+static OSStatus hotkeyPressed(EventHandlerCallRef next, EventRef event, void *data) {
+	EventHotKeyID id;
+	if (GetEventParameter(event, kEventParamDirectObject, typeEventHotKeyID, NULL, sizeof(id), NULL, &id) != noErr) {
+		return eventNotHandledErr;
+	}
+	UInt32 n = id.id;
+	write((int)(intptr_t)data, &n, sizeof(n));
+	return noErr;
+}
+
+static OSStatus installHotkeyHandler(int fd) {
+	EventTypeSpec spec = {kEventClassKeyboard, kEventHotKeyPressed};
+	return InstallApplicationEventHandler(NewEventHandlerUPP(hotkeyPressed), 1, &spec, (void *)(intptr_t)fd, NULL);
+}
+
+static OSStatus registerHotkey(UInt32 code, UInt32 mods, UInt32 n) {
+	EventHotKeyID id = {'elgo', n};
+	EventHotKeyRef ref;
+	return RegisterEventHotKey(code, mods, id, GetApplicationEventTarget(), 0, &ref);
+}
+
+static void runHotkeyLoop(void) { RunApplicationEventLoop(); }
+static void quitHotkeyLoop(void) { QuitEventLoop(GetMainEventLoop()); }
+*/
+import "C"
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Carbon delivers hotkeys to the event loop of the main thread, so the main
+// goroutine has to stay on it to register keys and run the loop.
+func init() {
+	runtime.LockOSThread()
+}
+
+// keyCodes maps key names to macOS virtual key codes (kVK_*), which are
+// positions on an ANSI keyboard.
+// From: HIToolbox/Events.h
+var keyCodes = map[string]C.UInt32{
+	"a": 0x00, "s": 0x01, "d": 0x02, "f": 0x03, "h": 0x04, "g": 0x05, "z": 0x06, "x": 0x07,
+	"c": 0x08, "v": 0x09, "b": 0x0b, "q": 0x0c, "w": 0x0d, "e": 0x0e, "r": 0x0f, "y": 0x10,
+	"t": 0x11, "1": 0x12, "2": 0x13, "3": 0x14, "4": 0x15, "6": 0x16, "5": 0x17, "equal": 0x18,
+	"9": 0x19, "7": 0x1a, "minus": 0x1b, "8": 0x1c, "0": 0x1d, "o": 0x1f, "u": 0x20, "i": 0x22,
+	"p": 0x23, "return": 0x24, "enter": 0x24, "l": 0x25, "j": 0x26, "k": 0x28, "comma": 0x2b,
+	"n": 0x2d, "m": 0x2e, "period": 0x2f, "tab": 0x30, "space": 0x31, "escape": 0x35,
+	"f5": 0x60, "f6": 0x61, "f7": 0x62, "f3": 0x63, "f8": 0x64, "f9": 0x65, "f11": 0x67,
+	"f10": 0x6d, "f12": 0x6f, "home": 0x73, "pageup": 0x74, "f4": 0x76, "end": 0x77,
+	"f2": 0x78, "pagedown": 0x79, "f1": 0x7a, "left": 0x7b, "right": 0x7c, "down": 0x7d, "up": 0x7e,
+}
+
+func carbonMods(m modifiers) C.UInt32 {
+	var r C.UInt32
+	if m&modShift != 0 {
+		r |= C.shiftKey
+	}
+	if m&modCtrl != 0 {
+		r |= C.controlKey
+	}
+	if m&modAlt != 0 {
+		r |= C.optionKey
+	}
+	if m&modSuper != 0 {
+		r |= C.cmdKey
+	}
+	return r
+}
+
+// registerHotkeys registers keys with Carbon and returns a channel that
+// receives the action for each key pressed, once waitHotkeys runs the event
+// loop. Keys that can't be registered (e.g. because another application
+// holds them) are reported as an error at startup.
+func registerHotkeys(keys map[hotkey]string) (<-chan string, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	if status := C.installHotkeyHandler(C.int(w.Fd())); status != C.noErr {
+		r.Close()
+		w.Close()
+		return nil, fmt.Errorf("installing hotkey handler: error %d", status)
+	}
+
+	var hs []hotkey
+	for h := range keys {
+		hs = append(hs, h)
+	}
+	sort.Slice(hs, func(i, j int) bool { return hs[i].String() < hs[j].String() })
+	var actions []string // by hotkey ID
+	var failed []string
+	for _, h := range hs {
+		code, ok := keyCodes[h.key]
+		if !ok {
+			failed = append(failed, fmt.Sprintf("%s: unknown key %q", h, h.key))
+			continue
+		}
+		switch status := C.registerHotkey(code, carbonMods(h.mods), C.UInt32(len(actions))); status {
+		case C.noErr:
+			actions = append(actions, keys[h])
+		case C.eventHotKeyExistsErr:
+			failed = append(failed, fmt.Sprintf("%s: already registered by another application", h))
+		default:
+			failed = append(failed, fmt.Sprintf("%s: error %d", h, status))
+		}
+	}
+	if len(failed) > 0 {
+		r.Close()
+		w.Close()
+		return nil, fmt.Errorf("registering hotkeys:\n  %s", strings.Join(failed, "\n  "))
+	}
+
+	pressed := make(chan string)
+	go func() {
+		defer close(pressed)
+		var buf [4]byte
+		for {
+			if _, err := r.Read(buf[:]); err != nil {
+				return
+			}
+			if id := binary.LittleEndian.Uint32(buf[:]); int(id) < len(actions) {
+				pressed <- actions[id]
+			}
+		}
+	}()
+	return pressed, nil
+}
+
+// waitHotkeys runs the Carbon event loop, which delivers hotkeys, until a
+// signal arrives on sig.
+func waitHotkeys(sig <-chan os.Signal) {
+	go func() {
+		<-sig
+		C.quitHotkeyLoop()
+	}()
+	C.runHotkeyLoop()
+}
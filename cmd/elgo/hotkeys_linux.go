@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+// keysyms maps key names to X11 keysyms for keys that aren't a single
+// letter or digit (whose keysyms are their ASCII codes).
+// From: /usr/include/X11/keysymdef.h
+var keysyms = map[string]xproto.Keysym{
+	"space": 0x0020, "return": 0xff0d, "enter": 0xff0d, "escape": 0xff1b, "tab": 0xff09,
+	"left": 0xff51, "up": 0xff52, "right": 0xff53, "down": 0xff54,
+	"pageup": 0xff55, "pagedown": 0xff56, "home": 0xff50, "end": 0xff57,
+	"minus": 0x002d, "equal": 0x003d, "comma": 0x002c, "period": 0x002e,
+	"f1": 0xffbe, "f2": 0xffbf, "f3": 0xffc0, "f4": 0xffc1, "f5": 0xffc2, "f6": 0xffc3,
+	"f7": 0xffc4, "f8": 0xffc5, "f9": 0xffc6, "f10": 0xffc7, "f11": 0xffc8, "f12": 0xffc9,
+}
+
+func keysym(key string) (xproto.Keysym, bool) {
+	if ks, ok := keysyms[key]; ok {
+		return ks, true
+	}
+	if len(key) == 1 && (key[0] >= 'a' && key[0] <= 'z' || key[0] >= '0' && key[0] <= '9') {
+		return xproto.Keysym(key[0]), true
+	}
+	return 0, false
+}
+
+func x11Mods(m modifiers) uint16 {
+	var r uint16
+	if m&modShift != 0 {
+		r |= xproto.ModMaskShift
+	}
+	if m&modCtrl != 0 {
+		r |= xproto.ModMaskControl
+	}
+	if m&modAlt != 0 {
+		r |= xproto.ModMask1
+	}
+	if m&modSuper != 0 {
+		r |= xproto.ModMask4
+	}
+	return r
+}
+
+// ignoredMods are the lock modifiers (Caps Lock and Num Lock) that shouldn't
+// stop a hotkey from matching.
+const ignoredMods = xproto.ModMaskLock | xproto.ModMask2
+
+// registerHotkeys registers keys and returns a channel that receives the
+// action for each key pressed: through the GlobalShortcuts desktop portal on
+// Wayland, where X11 clients only see keys while one of them has focus, and
+// on the X11 root window otherwise.
+func registerHotkeys(keys map[hotkey]string) (<-chan string, error) {
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		return registerPortalHotkeys(keys)
+	}
+	if os.Getenv("DISPLAY") == "" {
+		return nil, errors.New("global hotkeys need an X11 display or a Wayland session (neither DISPLAY nor WAYLAND_DISPLAY is set)")
+	}
+	return registerX11Hotkeys(keys)
+}
+
+// waitHotkeys waits for a signal on sig while the backend's own goroutine
+// delivers hotkeys.
+func waitHotkeys(sig <-chan os.Signal) {
+	<-sig
+}
+
+// registerX11Hotkeys grabs keys on the X11 root window. All keys are grabbed
+// before it returns, so keys that can't be registered (e.g. because another
+// client holds them) are reported as an error at startup.
+func registerX11Hotkeys(keys map[hotkey]string) (<-chan string, error) {
+	conn, err := xgb.NewConn()
+	if err != nil {
+		return nil, err
+	}
+	setup := xproto.Setup(conn)
+	root := setup.DefaultScreen(conn).Root
+
+	first, last := setup.MinKeycode, setup.MaxKeycode
+	mapping, err := xproto.GetKeyboardMapping(conn, first, byte(last-first+1)).Reply()
+	if err != nil {
+		return nil, err
+	}
+	keycode := func(ks xproto.Keysym) (xproto.Keycode, bool) {
+		per := int(mapping.KeysymsPerKeycode)
+		for i := 0; i*per < len(mapping.Keysyms); i++ {
+			for _, k := range mapping.Keysyms[i*per : (i+1)*per] {
+				if k == ks {
+					return first + xproto.Keycode(i), true
+				}
+			}
+		}
+		return 0, false
+	}
+
+	type grab struct {
+		code xproto.Keycode
+		mods uint16
+	}
+	actions := map[grab]string{}
+	var failed []string
+	for h, action := range keys {
+		ks, ok := keysym(h.key)
+		if !ok {
+			failed = append(failed, fmt.Sprintf("%s: unknown key %q", h, h.key))
+			continue
+		}
+		code, ok := keycode(ks)
+		if !ok {
+			failed = append(failed, fmt.Sprintf("%s: key %q is not on this keyboard", h, h.key))
+			continue
+		}
+		mods := x11Mods(h.mods)
+		for _, extra := range []uint16{0, xproto.ModMaskLock, xproto.ModMask2, ignoredMods} {
+			err := xproto.GrabKeyChecked(conn, true, root, mods|extra, code, xproto.GrabModeAsync, xproto.GrabModeAsync).Check()
+			if err != nil {
+				if _, ok := err.(xproto.AccessError); ok {
+					err = errors.New("already grabbed by another application")
+				}
+				failed = append(failed, fmt.Sprintf("%s: %v", h, err))
+				break
+			}
+		}
+		actions[grab{code, mods}] = action
+	}
+	if len(failed) > 0 {
+		conn.Close()
+		return nil, fmt.Errorf("registering hotkeys:\n  %s", strings.Join(failed, "\n  "))
+	}
+
+	pressed := make(chan string)
+	go func() {
+		for {
+			ev, err := conn.WaitForEvent()
+			if ev == nil && err == nil {
+				close(pressed)
+				return
+			}
+			if kp, ok := ev.(xproto.KeyPressEvent); ok {
+				if action, ok := actions[grab{kp.Detail, kp.State &^ ignoredMods}]; ok {
+					pressed <- action
+				}
+			}
+		}
+	}()
+	return pressed, nil
+}
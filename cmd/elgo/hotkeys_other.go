@@ -0,0 +1,19 @@
+//go:build !linux && !(darwin && cgo)
+// +build !linux
+// +build !darwin !cgo
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+func registerHotkeys(keys map[hotkey]string) (<-chan string, error) {
+	return nil, fmt.Errorf("global hotkeys are not supported on %s (only on Linux and on macOS built with cgo)", runtime.GOOS)
+}
+
+func waitHotkeys(sig <-chan os.Signal) {
+	<-sig
+}
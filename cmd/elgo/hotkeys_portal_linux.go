@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	portalName      = "org.freedesktop.portal.Desktop"
+	portalPath      = "/org/freedesktop/portal/desktop"
+	portalRequest   = "org.freedesktop.portal.Request"
+	portalShortcuts = "org.freedesktop.portal.GlobalShortcuts"
+)
+
+// portalKeys maps key names to the XKB keysym names the GlobalShortcuts
+// portal takes in triggers, for keys that aren't a single letter or digit.
+var portalKeys = map[string]string{
+	"space": "space", "return": "Return", "enter": "Return", "escape": "Escape", "tab": "Tab",
+	"left": "Left", "up": "Up", "right": "Right", "down": "Down",
+	"pageup": "Page_Up", "pagedown": "Page_Down", "home": "Home", "end": "End",
+	"minus": "minus", "equal": "equal", "comma": "comma", "period": "period",
+	"f1": "F1", "f2": "F2", "f3": "F3", "f4": "F4", "f5": "F5", "f6": "F6",
+	"f7": "F7", "f8": "F8", "f9": "F9", "f10": "F10", "f11": "F11", "f12": "F12",
+}
+
+// portalTrigger returns h in the shortcuts format of the portal's preferred
+// triggers, e.g. CTRL+ALT+l.
+func portalTrigger(h hotkey) (string, bool) {
+	key, ok := portalKeys[h.key]
+	if !ok {
+		if len(h.key) != 1 || !(h.key[0] >= 'a' && h.key[0] <= 'z' || h.key[0] >= '0' && h.key[0] <= '9') {
+			return "", false
+		}
+		key = h.key
+	}
+	var parts []string
+	for _, m := range []struct {
+		mod  modifiers
+		name string
+	}{{modCtrl, "CTRL"}, {modAlt, "ALT"}, {modShift, "SHIFT"}, {modSuper, "LOGO"}} {
+		if h.mods&m.mod != 0 {
+			parts = append(parts, m.name)
+		}
+	}
+	return strings.Join(append(parts, key), "+"), true
+}
+
+// portalShortcut is a shortcut as the portal takes and returns them: an ID
+// and its properties.
+type portalShortcut struct {
+	ID         string
+	Properties map[string]dbus.Variant
+}
+
+// portalCall calls method of the GlobalShortcuts portal and waits for the
+// response to the request it starts, which may wait on the user confirming
+// the shortcuts in a dialog.
+func portalCall(conn *dbus.Conn, signals <-chan *dbus.Signal, method string, args ...interface{}) (map[string]dbus.Variant, error) {
+	var handle dbus.ObjectPath
+	if err := conn.Object(portalName, portalPath).Call(portalShortcuts+"."+method, 0, args...).Store(&handle); err != nil {
+		return nil, err
+	}
+	for {
+		select {
+		case sig, ok := <-signals:
+			if !ok {
+				return nil, errors.New("session bus closed")
+			}
+			if sig.Path != handle || sig.Name != portalRequest+".Response" || len(sig.Body) < 2 {
+				continue
+			}
+			code, _ := sig.Body[0].(uint32)
+			results, _ := sig.Body[1].(map[string]dbus.Variant)
+			switch code {
+			case 0:
+				return results, nil
+			case 1:
+				return nil, fmt.Errorf("%s: cancelled", method)
+			default:
+				return nil, fmt.Errorf("%s: failed", method)
+			}
+		case <-interrupted.Done():
+			return nil, errInterrupted
+		}
+	}
+}
+
+// registerPortalHotkeys binds keys through the GlobalShortcuts desktop
+// portal. The compositor may let the user confirm or change the triggers;
+// shortcuts it doesn't bind at all are reported as an error at startup.
+func registerPortalHotkeys(keys map[hotkey]string) (<-chan string, error) {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, err
+	}
+	fail := func(err error) (<-chan string, error) {
+		conn.Close()
+		return nil, fmt.Errorf("GlobalShortcuts portal: %w", err)
+	}
+	for _, m := range [][]dbus.MatchOption{
+		{dbus.WithMatchInterface(portalRequest), dbus.WithMatchMember("Response")},
+		{dbus.WithMatchInterface(portalShortcuts), dbus.WithMatchMember("Activated")},
+	} {
+		if err := conn.AddMatchSignal(m...); err != nil {
+			return fail(err)
+		}
+	}
+	signals := make(chan *dbus.Signal, 16)
+	conn.Signal(signals)
+
+	results, err := portalCall(conn, signals, "CreateSession", map[string]dbus.Variant{
+		"handle_token":         dbus.MakeVariant("elgo_session"),
+		"session_handle_token": dbus.MakeVariant("elgo"),
+	})
+	if err != nil {
+		return fail(err)
+	}
+	var session dbus.ObjectPath
+	switch v := results["session_handle"].Value().(type) {
+	case string:
+		session = dbus.ObjectPath(v)
+	case dbus.ObjectPath:
+		session = v
+	default:
+		return fail(errors.New("CreateSession: no session handle"))
+	}
+
+	// Shortcuts are identified by their hotkey, in a stable order so that
+	// the compositor remembers them between runs.
+	var hs []hotkey
+	for h := range keys {
+		hs = append(hs, h)
+	}
+	sort.Slice(hs, func(i, j int) bool { return hs[i].String() < hs[j].String() })
+	actions := map[string]string{}
+	var shortcuts []portalShortcut
+	for _, h := range hs {
+		trigger, ok := portalTrigger(h)
+		if !ok {
+			return fail(fmt.Errorf("%s: unknown key %q", h, h.key))
+		}
+		actions[h.String()] = keys[h]
+		shortcuts = append(shortcuts, portalShortcut{h.String(), map[string]dbus.Variant{
+			"description":       dbus.MakeVariant("elgo " + keys[h]),
+			"preferred_trigger": dbus.MakeVariant(trigger),
+		}})
+	}
+	results, err = portalCall(conn, signals, "BindShortcuts", session, shortcuts, "", map[string]dbus.Variant{
+		"handle_token": dbus.MakeVariant("elgo_bind"),
+	})
+	if err != nil {
+		return fail(err)
+	}
+	var bound []portalShortcut
+	if v, ok := results["shortcuts"]; ok {
+		if err := v.Store(&bound); err != nil {
+			return fail(fmt.Errorf("BindShortcuts: %v", err))
+		}
+	}
+	got := map[string]bool{}
+	for _, s := range bound {
+		got[s.ID] = true
+	}
+	var failed []string
+	for _, h := range hs {
+		if !got[h.String()] {
+			failed = append(failed, fmt.Sprintf("%s: not bound by the desktop", h))
+		}
+	}
+	if len(failed) > 0 {
+		conn.Close()
+		return nil, fmt.Errorf("registering hotkeys:\n  %s", strings.Join(failed, "\n  "))
+	}
+
+	pressed := make(chan string)
+	go func() {
+		<-interrupted.Done()
+		conn.Close()
+	}()
+	go func() {
+		defer close(pressed)
+		for sig := range signals {
+			if sig.Name != portalShortcuts+".Activated" || len(sig.Body) < 2 {
+				continue
+			}
+			if s, _ := sig.Body[0].(dbus.ObjectPath); s != session {
+				continue
+			}
+			id, _ := sig.Body[1].(string)
+			if action, ok := actions[id]; ok {
+				pressed <- action
+			}
+		}
+	}()
+	return pressed, nil
+}
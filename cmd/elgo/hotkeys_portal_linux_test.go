@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// fakePortal implements the parts of the GlobalShortcuts portal elgo uses,
+// binding every shortcut except those in refuse.
+type fakePortal struct {
+	conn     *dbus.Conn
+	refuse   map[string]bool
+	triggers chan map[string]string // shortcut ID to preferred trigger
+}
+
+const fakeSession = dbus.ObjectPath("/org/freedesktop/portal/desktop/session/1_1/elgo")
+
+func (p *fakePortal) respond(handle dbus.ObjectPath, results map[string]dbus.Variant) {
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		p.conn.Emit(handle, portalRequest+".Response", uint32(0), results)
+	}()
+}
+
+func (p *fakePortal) CreateSession(options map[string]dbus.Variant) (dbus.ObjectPath, *dbus.Error) {
+	handle := dbus.ObjectPath("/org/freedesktop/portal/desktop/request/1_1/create")
+	p.respond(handle, map[string]dbus.Variant{"session_handle": dbus.MakeVariant(string(fakeSession))})
+	return handle, nil
+}
+
+func (p *fakePortal) BindShortcuts(session dbus.ObjectPath, shortcuts []portalShortcut, parent string, options map[string]dbus.Variant) (dbus.ObjectPath, *dbus.Error) {
+	handle := dbus.ObjectPath("/org/freedesktop/portal/desktop/request/1_1/bind")
+	triggers := map[string]string{}
+	var bound []portalShortcut
+	for _, s := range shortcuts {
+		triggers[s.ID], _ = s.Properties["preferred_trigger"].Value().(string)
+		if !p.refuse[s.ID] {
+			bound = append(bound, s)
+		}
+	}
+	p.triggers <- triggers
+	p.respond(handle, map[string]dbus.Variant{"shortcuts": dbus.MakeVariant(bound)})
+	return handle, nil
+}
+
+func startFakePortal(t *testing.T, refuse ...string) *fakePortal {
+	t.Helper()
+	privateSessionBus(t)
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	p := &fakePortal{conn: conn, refuse: map[string]bool{}, triggers: make(chan map[string]string, 1)}
+	for _, id := range refuse {
+		p.refuse[id] = true
+	}
+	if err := conn.Export(p, portalPath, portalShortcuts); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := conn.RequestName(portalName, dbus.NameFlagDoNotQueue); err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("RequestName: %v, %v", reply, err)
+	}
+	return p
+}
+
+func TestPortalHotkeys(t *testing.T) {
+	p := startFakePortal(t)
+	pressed, err := registerPortalHotkeys(map[hotkey]string{
+		{modCtrl | modAlt, "l"}:   "toggle",
+		{modSuper, "pageup"}:      "brighter",
+		{modShift | modCtrl, "5"}: "on@50",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"ctrl+alt+l":   "CTRL+ALT+l",
+		"super+pageup": "LOGO+Page_Up",
+		"shift+ctrl+5": "CTRL+SHIFT+5",
+	}
+	got := <-p.triggers
+	for id, trigger := range want {
+		if got[id] != trigger {
+			t.Errorf("trigger for %s = %q, want %q (all: %v)", id, got[id], trigger, got)
+		}
+	}
+
+	p.conn.Emit(portalPath, portalShortcuts+".Activated", fakeSession, "super+pageup", uint64(0), map[string]dbus.Variant{})
+	select {
+	case action := <-pressed:
+		if action != "brighter" {
+			t.Errorf("action %q, want brighter", action)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("no action after Activated")
+	}
+}
+
+func TestPortalHotkeysNotBound(t *testing.T) {
+	startFakePortal(t, "ctrl+alt+up")
+	_, err := registerPortalHotkeys(map[hotkey]string{
+		{modCtrl | modAlt, "l"}:  "toggle",
+		{modCtrl | modAlt, "up"}: "brighter",
+	})
+	if err == nil || !strings.Contains(err.Error(), "ctrl+alt+up: not bound") {
+		t.Errorf("got %v, want ctrl+alt+up not bound", err)
+	}
+}
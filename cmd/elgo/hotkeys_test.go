@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseHotkey(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+	}{
+		{"ctrl+alt+l", "ctrl+alt+l"},
+		{"Alt+Control+Up", "ctrl+alt+up"},
+		{"cmd+shift+F1", "shift+super+f1"},
+	} {
+		h, err := parseHotkey(tt.in)
+		if err != nil {
+			t.Errorf("parseHotkey(%q): %v", tt.in, err)
+			continue
+		}
+		if h.String() != tt.want {
+			t.Errorf("parseHotkey(%q) = %s, want %s", tt.in, h, tt.want)
+		}
+	}
+	for _, bad := range []string{"ctrl+", "hyper+l"} {
+		if _, err := parseHotkey(bad); err == nil {
+			t.Errorf("parseHotkey(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestLoadHotkeyBindings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	defer func(old string) { *configFile = old }(*configFile)
+	*configFile = path
+
+	if err := ioutil.WriteFile(path, []byte("hotkeys:\n  Control+Alt+L: toggle\n  ctrl+alt+w: on@40/3200K\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := loadHotkeyBindings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 2 || m["ctrl+alt+l"] != "toggle" || m["ctrl+alt+w"] != "on@40/3200K" {
+		t.Errorf("got %v", m)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("hotkeys:\n  ctrl+alt+l: explode\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadHotkeyBindings(); err == nil {
+		t.Error("bad action accepted")
+	}
+}
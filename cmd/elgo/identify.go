@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/vsekhar/elgo"
+)
+
+const identifyEndpoint = "identify"
+
+// errNoIdentify is returned by identifyNative for firmware without the
+// identify call.
+var errNoIdentify = errors.New("device can't identify itself")
+
+// identifyNative asks the device at hostName to pulse by itself, as newer
+// firmware can.
+func identifyNative(hostName string) error {
+	_, err := request(http.MethodPost, deviceURL(hostName, identifyEndpoint), nil)
+	var httpErr *elgo.HTTPError
+	if errors.As(err, &httpErr) && (httpErr.Status == http.StatusNotFound || httpErr.Status == http.StatusMethodNotAllowed) {
+		return errNoIdentify
+	}
+	return err
+}
+
+// identify makes a light pulse so it can be told apart from others: by
+// itself if its firmware can, and otherwise by pulsing its power as flash
+// does.
+func identify(args []string) {
+	fs := flag.NewFlagSet("identify", flag.ExitOnError)
+	software := fs.Bool("software", false, "pulse the light from elgo even if the device could do it itself")
+	times := fs.Int("times", 3, "number of pulses when pulsing from elgo")
+	asJSON := jsonFlag(fs, "print how the light was identified as JSON")
+	fs.Parse(args)
+	if *times < 1 {
+		fatal(errors.New("-times must be at least 1"))
+	}
+
+	hostName := resolveHost()
+	done := func(method string) {
+		if !*asJSON {
+			return
+		}
+		out := struct {
+			Host   string        `json:"host"`
+			Method string        `json:"method"` // device, or pulse from elgo
+			Timing *timingReport `json:"timing,omitempty"`
+		}{hostName, method, timingField()}
+		if err := json.NewEncoder(os.Stdout).Encode(out); err != nil {
+			fatal(err)
+		}
+	}
+	if !*software {
+		err := identifyNative(hostName)
+		if err == nil {
+			deviceLog(hostName).debugf("identifying")
+			done("device")
+			return
+		}
+		if err != errNoIdentify {
+			fatal(err)
+		}
+		deviceLog(hostName).debugf("%v, pulsing it instead", err)
+	}
+	longRunning = true
+	const interval = 400 * time.Millisecond
+	ctx, cancel := rampContext(pulseDeadline(*times, interval))
+	defer cancel()
+	pulseDevices(ctx, []device{{Instance: hostName, HostName: hostName}}, *times, interval, "toggle", 0)
+	done("pulse")
+}
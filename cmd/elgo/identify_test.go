@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestIdentifyNative(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		handler http.Handler
+		want    func(error) bool
+	}{
+		{
+			"supported",
+			testLight(false).handler(simDevice{name: "Sim", model: "Elgato Key Light", firmware: "1.0.3", identify: true}),
+			func(err error) bool { return err == nil },
+		},
+		{
+			"404",
+			testLight(false).handler(simDevice{name: "Sim", model: "Elgato Key Light", firmware: "1.0.3"}),
+			func(err error) bool { return err == errNoIdentify },
+		},
+		{
+			"405",
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			}),
+			func(err error) bool { return err == errNoIdentify },
+		},
+		{
+			"500",
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "oops", http.StatusInternalServerError)
+			}),
+			func(err error) bool { return err != nil && err != errNoIdentify },
+		},
+	} {
+		if err := identifyNative(fakeDevice(t, tt.handler)); !tt.want(err) {
+			t.Errorf("%s: identifyNative returned %v", tt.name, err)
+		}
+	}
+}
+
+// identifyCounts serves d, counting identify calls and writes to the light.
+func identifyCounts(t *testing.T, d simDevice) (hostName string, identifies, puts *int32) {
+	h := testLight(false).handler(d)
+	identifies, puts = new(int32), new(int32)
+	hostName = fakeDevice(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost:
+			atomic.AddInt32(identifies, 1)
+		case r.Method == http.MethodPut:
+			atomic.AddInt32(puts, 1)
+		}
+		h.ServeHTTP(w, r)
+	}))
+	return hostName, identifies, puts
+}
+
+func TestIdentifyCommand(t *testing.T) {
+	bin := buildElgo(t)
+
+	hostName, identifies, puts := identifyCounts(t, simDevice{name: "Sim", model: "Elgato Key Light", firmware: "1.0.3", identify: true})
+	if _, code := runElgo(t, bin, "-host", hostName, "identify"); code != 0 {
+		t.Errorf("identify: exit status %d", code)
+	}
+	if *identifies != 1 || *puts != 0 {
+		t.Errorf("with the identify call: %d identify calls and %d writes, want 1 and 0", *identifies, *puts)
+	}
+
+	// Without it, elgo pulses the light itself.
+	hostName, identifies, puts = identifyCounts(t, simDevice{name: "Sim", model: "Elgato Key Light", firmware: "1.0.3"})
+	if _, code := runElgo(t, bin, "-host", hostName, "identify", "-times", "1"); code != 0 {
+		t.Errorf("identify: exit status %d", code)
+	}
+	if *identifies != 1 || *puts < 2 {
+		t.Errorf("without the identify call: %d identify calls and %d writes, want 1 and at least 2", *identifies, *puts)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// info prints the accessory-info of a device: its model, name, serial
+// number and firmware, flagging firmware older than elgo has seen on
+// another device of the same model.
+func info(args []string) {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	asJSON := jsonFlag(fs, "print the information as JSON")
+	fs.Parse(args)
+
+	hostName := resolveHost()
+	i, err := fetchAccessoryInfo(hostName)
+	if err != nil {
+		fatal(err)
+	}
+	// Flag firmware older than that of other devices of the same model.
+	c := loadCache()
+	c.recordInfo(c.find(hostName), i)
+	newest := c.newestFirmware(i.ProductName)
+	if !olderThan(i.FirmwareVersion, newest) {
+		newest = ""
+	}
+	if *asJSON {
+		out := struct {
+			Host string `json:"host"`
+			accessoryInfo
+			NewerFirmware string        `json:"newerFirmwareSeen,omitempty"`
+			Timing        *timingReport `json:"timing,omitempty"`
+		}{hostName, i, newest, timingField()}
+		if err := json.NewEncoder(os.Stdout).Encode(out); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	fmt.Printf("host: %s\n", hostName)
+	fmt.Printf("product: %s\n", i.ProductName)
+	fmt.Printf("name: %s\n", i.DisplayName)
+	fmt.Printf("serial: %s\n", i.SerialNumber)
+	fmt.Printf("firmware: %s (build %d)", i.FirmwareVersion, i.FirmwareBuildNumber)
+	if newest != "" {
+		fmt.Printf(", older than %s on another %s", newest, i.ProductName)
+	}
+	fmt.Println()
+}
+
+// fetchAllInfo fetches the accessory-info of each device concurrently. The
+// info of devices that don't answer is left zero.
+func fetchAllInfo(devices []device) []accessoryInfo {
+	infos := make([]accessoryInfo, len(devices))
+	var wg sync.WaitGroup
+	for i, d := range devices {
+		wg.Add(1)
+		go func(i int, hostName string) {
+			defer wg.Done()
+			info, err := fetchAccessoryInfo(hostName)
+			if err != nil {
+				discoveryLog.debugf("%s: %v", hostName, err)
+				return
+			}
+			infos[i] = info
+		}(i, d.HostName)
+	}
+	wg.Wait()
+	return infos
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// lastState is the state a device was left in by the last successful
+// change made with elgo.
+type lastState struct {
+	State state     `json:"state"`
+	At    time.Time `json:"at"`
+}
+
+func lastPath() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "last.json"), nil
+}
+
+var lastMu sync.Mutex
+
+// loadLast returns the last states by device key (see hostCache.find).
+func loadLast() (map[string]lastState, error) {
+	last := map[string]lastState{}
+	path, err := lastPath()
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return last, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &last); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return last, nil
+}
+
+// recordLast records s as the state of the device at hostName, for elgo
+// last. Failures are logged, since they are never worth failing a change
+// for.
+func recordLast(hostName string, s state) {
+	lastMu.Lock()
+	defer lastMu.Unlock()
+	last, err := loadLast()
+	var path string
+	if err == nil {
+		last[loadCache().find(hostName)] = lastState{State: s, At: time.Now()}
+		path, err = lastPath()
+	}
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+	}
+	if err == nil {
+		var b []byte
+		b, err = json.MarshalIndent(last, "", "  ")
+		if err == nil {
+			err = ioutil.WriteFile(path, b, 0644)
+		}
+	}
+	if err != nil {
+		cmdLog.warnf("recording last state: %v", err)
+	}
+}
+
+// last reapplies the state the device was left in by the last change made
+// with elgo, e.g. after it was reset or changed with its own controls.
+func last(args []string) {
+	fs := flag.NewFlagSet("last", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fatal(errors.New("usage: elgo last"))
+	}
+
+	hostName := resolveHost()
+	states, err := loadLast()
+	if err != nil {
+		fatal(err)
+	}
+	l, ok := states[loadCache().find(hostName)]
+	if !ok {
+		fatal(fmt.Errorf("%s: no recorded state: elgo records the state after each change it makes", hostName))
+	}
+	deviceLog(hostName).debugf("reapplying state from %s", l.At.Format(time.RFC3339))
+	putState(hostName, l.State)
+}
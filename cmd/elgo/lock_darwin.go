@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"time"
+)
+
+// lockEvents returns a channel that receives true when the screen locks and
+// false when it unlocks. The lock state is polled from the window server's
+// session properties, where CGSSessionScreenIsLocked is present while locked.
+func lockEvents() (<-chan bool, error) {
+	locked := func() (bool, error) {
+		out, err := exec.Command("ioreg", "-n", "Root", "-d1", "-a").Output()
+		if err != nil {
+			return false, err
+		}
+		return bytes.Contains(out, []byte("<key>CGSSessionScreenIsLocked</key>")), nil
+	}
+	was, err := locked()
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan bool)
+	go func() {
+		for range time.Tick(time.Second) {
+			is, err := locked()
+			if err != nil {
+				cmdLog.warnf("ioreg: %v", err)
+				continue
+			}
+			if is != was {
+				events <- is
+				was = is
+			}
+		}
+	}()
+	return events, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// lockEvents returns a channel that receives true when the screen locks and
+// false when it unlocks, from the screensaver's ActiveChanged signal on the
+// session bus and logind's Lock and Unlock signals on the system bus.
+func lockEvents() (<-chan bool, error) {
+	events := make(chan bool)
+	session, err := dbus.SessionBus()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range []string{"org.freedesktop.ScreenSaver", "org.gnome.ScreenSaver"} {
+		if err := session.AddMatchSignal(dbus.WithMatchInterface(iface), dbus.WithMatchMember("ActiveChanged")); err != nil {
+			return nil, err
+		}
+	}
+	signals := make(chan *dbus.Signal, 8)
+	session.Signal(signals)
+
+	if system, err := dbus.SystemBus(); err == nil {
+		opts := []dbus.MatchOption{dbus.WithMatchInterface("org.freedesktop.login1.Session")}
+		if id := os.Getenv("XDG_SESSION_ID"); id != "" {
+			var path dbus.ObjectPath
+			err := system.Object("org.freedesktop.login1", "/org/freedesktop/login1").
+				Call("org.freedesktop.login1.Manager.GetSession", 0, id).Store(&path)
+			if err == nil {
+				opts = append(opts, dbus.WithMatchObjectPath(path))
+			}
+		}
+		if err := system.AddMatchSignal(opts...); err == nil {
+			system.Signal(signals)
+		}
+	}
+
+	go func() {
+		for sig := range signals {
+			switch sig.Name {
+			case "org.freedesktop.ScreenSaver.ActiveChanged", "org.gnome.ScreenSaver.ActiveChanged":
+				if len(sig.Body) > 0 {
+					if active, ok := sig.Body[0].(bool); ok {
+						events <- active
+					}
+				}
+			case "org.freedesktop.login1.Session.Lock":
+				events <- true
+			case "org.freedesktop.login1.Session.Unlock":
+				events <- false
+			}
+		}
+		close(events)
+	}()
+	return events, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func lockEvents() (<-chan bool, error) {
+	return nil, fmt.Errorf("screen lock detection is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var logFile = flag.String("log-file", "", "write logs to this file instead of stderr")
+var logFormat = flag.String("log-format", "text", "log format: text or json (one object per line with time, level, component, device, serial and message)")
+var logMaxSize = flag.Int64("log-max-size", 10, "rotate -log-file when it would exceed this many megabytes (0 to never rotate)")
+var logKeep = flag.Int("log-keep", 3, "number of rotated log files to keep")
+var quiet = flag.Bool("q", false, "print nothing but errors, for scripts that rely on the exit status")
+
+// Log levels. Debug messages are only logged with -v, and trace messages,
+// such as request bodies, with -vv. With -q only errors are.
+type logLevel int
+
+const (
+	levelTrace logLevel = iota
+	levelDebug
+	levelInfo
+	levelWarn
+	levelError
+)
+
+func (l logLevel) String() string {
+	return [...]string{"trace", "debug", "info", "warn", "error"}[l]
+}
+
+// Loggers for the components of long-running modes.
+var (
+	discoveryLog = logger{component: "discovery"}
+	daemonLog    = logger{component: "daemon"}
+	apiLog       = logger{component: "api"}
+	enforceLog   = logger{component: "enforce"}
+	requestLog   = logger{component: "request"}
+
+	// cmdLog is for one-shot commands, whose messages need no component.
+	cmdLog = logger{}
+)
+
+// logOut receives JSON log records, bypassing the standard logger's
+// formatting. It is nil in text format.
+var logOut io.Writer
+
+// setupLogging directs the standard logger according to the -log flags. It
+// must be called after flag.Parse.
+func setupLogging() {
+	var w io.Writer = os.Stderr
+	if *logFile != "" {
+		f, err := openRotating(*logFile, *logMaxSize<<20, *logKeep)
+		if err != nil {
+			fatal(err)
+		}
+		w = f
+	}
+	switch *logFormat {
+	case "text":
+		log.SetOutput(w)
+	case "json":
+		logOut = w
+		log.SetFlags(0)
+		log.SetOutput(jsonLines{w})
+	default:
+		fatal(fmt.Errorf("bad -log-format: %s", *logFormat))
+	}
+}
+
+// silence discards everything printed to stdout, for -q. Errors still go
+// to stderr, or to -log-file.
+func silence() {
+	f, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		fatal(err)
+	}
+	os.Stdout = f
+}
+
+// A logger logs messages from one component (e.g. api or enforce), and
+// optionally about one device.
+type logger struct {
+	component string
+	device    string
+	serial    string
+}
+
+// forDevice returns a logger for messages about the device named instance
+// with the given serial number, which may be empty if unknown.
+func (l logger) forDevice(instance, serial string) logger {
+	l.device, l.serial = instance, serial
+	return l
+}
+
+// deviceLog returns cmdLog for messages about the device at hostName.
+func deviceLog(hostName string) logger {
+	return cmdLog.forDevice(hostName, "")
+}
+
+func (l logger) logf(level logLevel, format string, args ...interface{}) {
+	if level == levelTrace && !*veryVerbose || level == levelDebug && !*verbose || level < levelError && *quiet {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if logOut != nil {
+		b, _ := json.Marshal(struct {
+			Time      time.Time `json:"time"`
+			Level     string    `json:"level"`
+			Component string    `json:"component,omitempty"`
+			Device    string    `json:"device,omitempty"`
+			Serial    string    `json:"serial,omitempty"`
+			Message   string    `json:"message"`
+		}{time.Now(), level.String(), l.component, l.device, l.serial, msg})
+		logOut.Write(append(b, '\n'))
+		return
+	}
+	prefix := ""
+	if l.component != "" {
+		prefix = l.component + ": "
+	}
+	if l.device != "" {
+		prefix += l.device + ": "
+	}
+	if level >= levelWarn {
+		prefix += level.String() + ": "
+	}
+	log.Output(3, prefix+msg)
+}
+
+func (l logger) tracef(format string, args ...interface{}) { l.logf(levelTrace, format, args...) }
+func (l logger) debugf(format string, args ...interface{}) { l.logf(levelDebug, format, args...) }
+func (l logger) infof(format string, args ...interface{})  { l.logf(levelInfo, format, args...) }
+func (l logger) warnf(format string, args ...interface{})  { l.logf(levelWarn, format, args...) }
+func (l logger) errorf(format string, args ...interface{}) { l.logf(levelError, format, args...) }
+
+// jsonLines wraps lines from the standard logger, which carry no level or
+// component, in JSON records.
+type jsonLines struct {
+	w io.Writer
+}
+
+func (j jsonLines) Write(p []byte) (int, error) {
+	b, _ := json.Marshal(struct {
+		Time    time.Time `json:"time"`
+		Level   string    `json:"level"`
+		Message string    `json:"message"`
+	}{time.Now(), levelInfo.String(), strings.TrimSuffix(string(p), "\n")})
+	if _, err := j.w.Write(append(b, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// rotatingFile is a log file that is renamed to path.1 (and older files to
+// path.2 and so on, up to keep) when it would grow beyond maxSize bytes.
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	keep    int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func openRotating(path string, maxSize int64, keep int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, keep: keep}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, fi.Size()
+	return nil
+}
+
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	for i := r.keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if r.keep > 0 {
+		os.Rename(r.path, r.path+".1")
+	} else {
+		os.Remove(r.path)
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
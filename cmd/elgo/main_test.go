@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// userEnv is the environment tests were started in, before TestMain
+// replaced the user's directories.
+var userEnv []string
+
+// TestMain keeps the caches, config and state of tests away from the user's,
+// and gives tests all the time they need.
+func TestMain(m *testing.M) {
+	userEnv = os.Environ()
+	dir, err := ioutil.TempDir("", "elgo-test")
+	if err != nil {
+		panic(err)
+	}
+	for _, v := range []string{"HOME", "XDG_CONFIG_HOME", "XDG_CACHE_HOME", "XDG_STATE_HOME", "XDG_DATA_HOME"} {
+		os.Setenv(v, dir)
+	}
+	start = time.Now()
+	*timeout = time.Hour
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// fakeDevice serves h as a device for the rest of the test and returns its
+// host:port.
+func fakeDevice(t *testing.T, h http.Handler) string {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv.Listener.Addr().String()
+}
+
+// testLight returns a simulated device with one light, on or off at 20% and
+// 4700K, accepting the default range.
+func testLight(on bool) *simulator {
+	l := light{Brightness: 20, Temperature: fromKelvin(4700)}
+	if on {
+		l.On = 1
+	}
+	return &simulator{
+		bounds: lightRange{}.orDefault(),
+		state:  state{NumberOfLights: 1, Lights: []light{l}},
+	}
+}
+
+// lightState returns a copy of the current state of sim.
+func (sim *simulator) lightState() state {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	s := sim.state
+	s.Lights = append([]light(nil), sim.state.Lights...)
+	return s
+}
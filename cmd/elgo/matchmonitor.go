@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+)
+
+// A whitePoint is a color in CIE 1960 UCS coordinates, in which distances
+// approximate perceived color differences near white.
+type whitePoint struct{ u, v float64 }
+
+func (a whitePoint) dist(b whitePoint) float64 {
+	return math.Hypot(a.u-b.u, a.v-b.v)
+}
+
+// fromXY converts CIE 1931 xy chromaticity to a whitePoint.
+func fromXY(x, y float64) whitePoint {
+	d := -2*x + 12*y + 3
+	return whitePoint{4 * x / d, 6 * y / d}
+}
+
+// planckian returns the white point of a black body at kelvin, using
+// Krystek's approximation (accurate from 1000K to 15000K).
+func planckian(kelvin float64) whitePoint {
+	t := kelvin
+	return whitePoint{
+		(0.860117757 + 1.54118254e-4*t + 1.28641212e-7*t*t) / (1 + 8.42420235e-4*t + 7.08145163e-7*t*t),
+		(0.317398726 + 4.22806245e-5*t + 4.20481691e-8*t*t) / (1 - 2.89741816e-5*t + 1.61456053e-7*t*t),
+	}
+}
+
+// offLocus returns the white point at kelvin moved tint (Duv) away from the
+// Planckian locus, positive towards green and negative towards magenta.
+func offLocus(kelvin, tint float64) whitePoint {
+	a, b := planckian(kelvin-1), planckian(kelvin+1)
+	du, dv := b.u-a.u, b.v-a.v
+	n := math.Hypot(du, dv)
+	// Rotate the locus direction (towards higher temperatures, which runs
+	// to lower u) a quarter turn to point above the locus.
+	p := planckian(kelvin)
+	return whitePoint{p.u + tint*dv/n, p.v - tint*du/n}
+}
+
+// cctDuv returns the correlated color temperature of w and its signed
+// distance from the Planckian locus.
+func cctDuv(w whitePoint) (kelvin, tint float64) {
+	// Coarse search in mireds, which are spaced more evenly along the locus
+	// than Kelvins, then refine by golden section.
+	best := 0.0
+	for m := 66.0; m <= 1000; m++ {
+		if best == 0 || w.dist(planckian(1e6/m)) < w.dist(planckian(1e6/best)) {
+			best = m
+		}
+	}
+	lo, hi := 1e6/(best+1), 1e6/(best-1)
+	for i := 0; i < 60; i++ {
+		a, b := lo+(hi-lo)*0.382, lo+(hi-lo)*0.618
+		if w.dist(planckian(a)) < w.dist(planckian(b)) {
+			hi = b
+		} else {
+			lo = a
+		}
+	}
+	kelvin = (lo + hi) / 2
+	tint = w.dist(planckian(kelvin))
+	if w.dist(offLocus(kelvin, -tint)) < w.dist(offLocus(kelvin, tint)) {
+		tint = -tint
+	}
+	return kelvin, tint
+}
+
+// matchTemperature returns the device temperature (in device units, between
+// 143 and 344) whose white is closest to target, and the remaining distance
+// in uv, which the device can't correct since it has no tint control.
+func matchTemperature(target whitePoint) (temperature int, residual float64) {
+	for t := 143; t <= 344; t++ {
+		d := target.dist(planckian(float64(kelvinFactor) / float64(t)))
+		if temperature == 0 || d < residual {
+			temperature, residual = t, d
+		}
+	}
+	return temperature, residual
+}
+
+// monitorProfile describes a monitor's white point, either as a color
+// temperature and tint (Duv) or as xy chromaticity coordinates.
+type monitorProfile struct {
+	Kelvin float64 `json:"kelvin,omitempty"`
+	Tint   float64 `json:"tint,omitempty"`
+	X      float64 `json:"x,omitempty"`
+	Y      float64 `json:"y,omitempty"`
+}
+
+func (p monitorProfile) whitePoint() (whitePoint, error) {
+	switch {
+	case p.X != 0 || p.Y != 0:
+		if p.X <= 0 || p.Y <= 0 || p.X+p.Y >= 1 {
+			return whitePoint{}, fmt.Errorf("bad white point x=%v y=%v", p.X, p.Y)
+		}
+		return fromXY(p.X, p.Y), nil
+	case p.Kelvin != 0:
+		if p.Kelvin < 1000 || p.Kelvin > 15000 {
+			return whitePoint{}, fmt.Errorf("bad temperature %vK: must be between 1000 and 15000", p.Kelvin)
+		}
+		if math.Abs(p.Tint) > 0.05 {
+			return whitePoint{}, fmt.Errorf("bad tint %v: must be between -0.05 and 0.05", p.Tint)
+		}
+		return offLocus(p.Kelvin, p.Tint), nil
+	}
+	return whitePoint{}, errors.New("profile needs kelvin (and optionally tint), or x and y")
+}
+
+// iccWhitePoint returns the xy chromaticity of the media white point of an
+// ICC profile. Version 4 profiles store it adapted to D50, so it is adapted
+// back using the profile's chromatic adaptation (chad) tag when present.
+func iccWhitePoint(b []byte) (x, y float64, err error) {
+	if len(b) < 132 || string(b[36:40]) != "acsp" {
+		return 0, 0, errors.New("not an ICC profile")
+	}
+	tags := map[string][]byte{}
+	n := int(binary.BigEndian.Uint32(b[128:]))
+	for i := 0; i < n && 132+12*i+12 <= len(b); i++ {
+		e := b[132+12*i:]
+		off, size := int(binary.BigEndian.Uint32(e[4:])), int(binary.BigEndian.Uint32(e[8:]))
+		if off+size <= len(b) {
+			tags[string(e[:4])] = b[off : off+size]
+		}
+	}
+	s15 := func(t []byte, i int) float64 {
+		return float64(int32(binary.BigEndian.Uint32(t[8+4*i:]))) / 65536
+	}
+	wtpt := tags["wtpt"]
+	if len(wtpt) < 20 {
+		return 0, 0, errors.New("ICC profile has no media white point")
+	}
+	xyz := [3]float64{s15(wtpt, 0), s15(wtpt, 1), s15(wtpt, 2)}
+	if chad := tags["chad"]; b[8] >= 4 && len(chad) >= 44 {
+		var m [9]float64
+		for i := range m {
+			m[i] = s15(chad, i)
+		}
+		if xyz, err = solve3(m, xyz); err != nil {
+			return 0, 0, err
+		}
+	}
+	sum := xyz[0] + xyz[1] + xyz[2]
+	if sum == 0 {
+		return 0, 0, errors.New("ICC profile has a black white point")
+	}
+	return xyz[0] / sum, xyz[1] / sum, nil
+}
+
+// solve3 solves m·r = v for r, where m is a row-major 3x3 matrix.
+func solve3(m [9]float64, v [3]float64) ([3]float64, error) {
+	det := func(a [9]float64) float64 {
+		return a[0]*(a[4]*a[8]-a[5]*a[7]) - a[1]*(a[3]*a[8]-a[5]*a[6]) + a[2]*(a[3]*a[7]-a[4]*a[6])
+	}
+	d := det(m)
+	if d == 0 {
+		return [3]float64{}, errors.New("singular chromatic adaptation matrix")
+	}
+	var r [3]float64
+	for c := 0; c < 3; c++ {
+		a := m
+		for row := 0; row < 3; row++ {
+			a[3*row+c] = v[row]
+		}
+		r[c] = det(a) / d
+	}
+	return r, nil
+}
+
+// matchMonitor sets the selected light to the temperature closest to a
+// monitor's white point, reporting what's left over.
+func matchMonitor(args []string) {
+	fs := flag.NewFlagSet("match-monitor", flag.ExitOnError)
+	profileFile := fs.String("profile", "", `JSON profile file, e.g. {"kelvin": 6500, "tint": 0.002} or {"x": 0.3127, "y": 0.329}`)
+	icc := fs.String("icc", "", "ICC profile to read the white point from")
+	kelvin := fs.Float64("kelvin", 0, "white point color temperature")
+	tint := fs.Float64("tint", 0, "white point distance from the black body curve (Duv), positive towards green")
+	whitePointXY := fs.String("white-point", "", "white point as CIE 1931 x,y")
+	dryRun := fs.Bool("n", false, "only report the match, don't change the light")
+	fs.Parse(args)
+
+	p := monitorProfile{Kelvin: *kelvin, Tint: *tint}
+	switch {
+	case *profileFile != "":
+		b, err := ioutil.ReadFile(*profileFile)
+		if err != nil {
+			fatal(err)
+		}
+		p = monitorProfile{}
+		if err := json.Unmarshal(b, &p); err != nil {
+			fatal(fmt.Errorf("%s: %v", *profileFile, err))
+		}
+	case *icc != "":
+		b, err := ioutil.ReadFile(*icc)
+		if err != nil {
+			fatal(err)
+		}
+		p = monitorProfile{}
+		if p.X, p.Y, err = iccWhitePoint(b); err != nil {
+			fatal(fmt.Errorf("%s: %v", *icc, err))
+		}
+	case *whitePointXY != "":
+		var err error
+		if p.X, p.Y, err = parseXY(*whitePointXY); err != nil {
+			fatal(fmt.Errorf("bad -white-point %q: %v", *whitePointXY, err))
+		}
+	}
+	target, err := p.whitePoint()
+	if err != nil {
+		fatal(fmt.Errorf("%v (use -profile, -icc, -white-point or -kelvin)", err))
+	}
+
+	cct, duv := cctDuv(target)
+	t, residual := matchTemperature(target)
+	fmt.Printf("monitor white: %.0fK, tint %+.4f\n", cct, duv)
+	fmt.Printf("closest light temperature: %dK (%d), residual %.4f uv (%+dK, tint %+.4f uncorrectable)\n",
+		toKelvin(t), t, residual, toKelvin(t)-int(math.Round(cct)), duv)
+	if *dryRun {
+		return
+	}
+
+	hostName := resolveHost()
+	cur := getState(hostName)
+	i, err := selectLight(cur)
+	if err != nil {
+		fatal(err)
+	}
+	s := cur
+	s.Lights[i] = light{ID: cur.Lights[i].ID, On: cur.Lights[i].On, Temperature: t}
+	putState(hostName, s)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestPlanckianIlluminantA(t *testing.T) {
+	// CIE illuminant A is a black body at 2856K.
+	if d := planckian(2856).dist(fromXY(0.44757, 0.40745)); d > 0.0005 {
+		t.Errorf("planckian(2856) is %v from illuminant A", d)
+	}
+}
+
+func TestCCTDuvKnownPoints(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		x, y      float64
+		kelvin    float64
+		tint      float64
+		tolKelvin float64
+	}{
+		{"D65", 0.3127, 0.3290, 6504, 0.0032, 20},
+		{"D50", 0.3457, 0.3585, 5003, 0.0033, 20},
+		{"A", 0.44757, 0.40745, 2856, 0, 5},
+	} {
+		k, tint := cctDuv(fromXY(tt.x, tt.y))
+		if math.Abs(k-tt.kelvin) > tt.tolKelvin {
+			t.Errorf("%s: CCT %.0fK, want %.0fK", tt.name, k, tt.kelvin)
+		}
+		if math.Abs(tint-tt.tint) > 0.0005 {
+			t.Errorf("%s: Duv %.4f, want %.4f", tt.name, tint, tt.tint)
+		}
+	}
+}
+
+func TestOffLocusRoundTrip(t *testing.T) {
+	for _, kelvin := range []float64{2900, 3200, 4500, 5600, 6500, 7000} {
+		for _, tint := range []float64{-0.01, 0, 0.004, 0.01} {
+			k, got := cctDuv(offLocus(kelvin, tint))
+			if math.Abs(k-kelvin) > 1 || math.Abs(got-tint) > 1e-5 {
+				t.Errorf("cctDuv(offLocus(%v, %v)) = %.1f, %.5f", kelvin, tint, k, got)
+			}
+		}
+	}
+}
+
+func TestMatchTemperature(t *testing.T) {
+	for _, want := range []int{143, 200, 250, 312, 344} {
+		temp, residual := matchTemperature(planckian(float64(kelvinFactor) / float64(want)))
+		if temp != want || residual > 1e-9 {
+			t.Errorf("matchTemperature(%dK) = %d, %v; want %d, 0", toKelvin(want), temp, residual, want)
+		}
+	}
+	// Beyond the device's range, the nearest end is the best it can do.
+	if temp, residual := matchTemperature(planckian(10000)); temp != 143 || residual == 0 {
+		t.Errorf("matchTemperature(10000K) = %d, %v; want 143 and a residual", temp, residual)
+	}
+	// Tint is left over.
+	_, residual := matchTemperature(offLocus(5000, 0.005))
+	if math.Abs(residual-0.005) > 0.0005 {
+		t.Errorf("residual for a tint of 0.005 is %v", residual)
+	}
+}
+
+func TestMonitorProfile(t *testing.T) {
+	if _, err := (monitorProfile{Kelvin: 6500, Tint: 0.1}).whitePoint(); err == nil {
+		t.Error("tint 0.1 accepted")
+	}
+	if _, err := (monitorProfile{X: 0.7, Y: 0.4}).whitePoint(); err == nil {
+		t.Error("x+y >= 1 accepted")
+	}
+	if _, err := (monitorProfile{}).whitePoint(); err == nil {
+		t.Error("empty profile accepted")
+	}
+	w, err := monitorProfile{X: 0.3127, Y: 0.3290}.whitePoint()
+	if err != nil || w != fromXY(0.3127, 0.3290) {
+		t.Errorf("D65 profile: %v, %v", w, err)
+	}
+}
+
+func TestICCWhitePoint(t *testing.T) {
+	// A version 2 profile with just a D65 media white point.
+	b := make([]byte, 164)
+	b[8] = 2
+	copy(b[36:], "acsp")
+	binary.BigEndian.PutUint32(b[128:], 1)
+	copy(b[132:], "wtpt")
+	binary.BigEndian.PutUint32(b[136:], 144)
+	binary.BigEndian.PutUint32(b[140:], 20)
+	copy(b[144:], "XYZ ")
+	for i, v := range []float64{0.9505, 1, 1.0890} {
+		binary.BigEndian.PutUint32(b[152+4*i:], uint32(int32(math.Round(v*65536))))
+	}
+	x, y, err := iccWhitePoint(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(x-0.3127) > 0.0002 || math.Abs(y-0.3290) > 0.0002 {
+		t.Errorf("white point %.4f, %.4f, want D65", x, y)
+	}
+	if _, _, err := iccWhitePoint(b[:100]); err == nil {
+		t.Error("truncated profile accepted")
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// regexps is a list of regular expressions from repeated flags. Setting it
+// replaces the defaults.
+type regexps struct {
+	res     []*regexp.Regexp
+	changed bool
+}
+
+func (r *regexps) String() string {
+	var s []string
+	for _, re := range r.res {
+		s = append(s, re.String())
+	}
+	return strings.Join(s, ",")
+}
+
+func (r *regexps) Set(s string) error {
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return err
+	}
+	if !r.changed {
+		r.res = nil
+		r.changed = true
+	}
+	r.res = append(r.res, re)
+	return nil
+}
+
+func (r *regexps) match(s string) bool {
+	for _, re := range r.res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func mustRegexps(ss ...string) *regexps {
+	r := &regexps{}
+	for _, s := range ss {
+		r.res = append(r.res, regexp.MustCompile(s))
+	}
+	return r
+}
+
+// processNames returns the names of running processes.
+func processNames() ([]string, error) {
+	if runtime.GOOS == "linux" {
+		files, err := filepath.Glob("/proc/[0-9]*/comm")
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, f := range files {
+			b, err := ioutil.ReadFile(f)
+			if err == nil {
+				names = append(names, strings.TrimSpace(string(b)))
+			}
+		}
+		return names, nil
+	}
+	out, err := exec.Command("ps", "-axo", "comm=").Output()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			names = append(names, filepath.Base(line))
+		}
+	}
+	return names, nil
+}
+
+// windowTitles returns the titles of open windows.
+func windowTitles() ([]string, error) {
+	var out []byte
+	var err error
+	switch runtime.GOOS {
+	case "linux":
+		// wmctrl -l prints: ID DESKTOP HOST TITLE
+		out, err = exec.Command("wmctrl", "-l").Output()
+		if err != nil {
+			return nil, fmt.Errorf("wmctrl: %v", err)
+		}
+		var titles []string
+		for _, line := range strings.Split(string(out), "\n") {
+			if f := strings.SplitN(line, " ", 4); len(f) == 4 {
+				titles = append(titles, strings.TrimSpace(f[3]))
+			}
+		}
+		return titles, nil
+	case "darwin":
+		out, err = exec.Command("osascript", "-e",
+			`tell application "System Events" to get name of every window of every process whose background only is false`).Output()
+		if err != nil {
+			return nil, fmt.Errorf("osascript: %v", err)
+		}
+		return strings.Split(string(bytes.TrimSpace(out)), ", "), nil
+	}
+	return nil, fmt.Errorf("window titles are not supported on %s", runtime.GOOS)
+}
+
+// inMeeting reports whether a running process or open window matches the
+// rules, and which one.
+func inMeeting(processes, windows *regexps) (bool, string, error) {
+	if len(processes.res) > 0 {
+		names, err := processNames()
+		if err != nil {
+			return false, "", err
+		}
+		for _, n := range names {
+			if processes.match(n) {
+				return true, "process " + n, nil
+			}
+		}
+	}
+	if len(windows.res) > 0 {
+		titles, err := windowTitles()
+		if err != nil {
+			return false, "", err
+		}
+		for _, t := range titles {
+			if windows.match(t) {
+				return true, "window " + t, nil
+			}
+		}
+	}
+	return false, "", nil
+}
+
+// automeeting applies a preset to every device while a meeting client is in
+// a call, restoring each device's previous state afterwards.
+func automeeting(args []string) {
+	fs := flag.NewFlagSet("automeeting", flag.ExitOnError)
+	presetFlag := fs.String("preset", "on@70", "preset to apply during meetings")
+	interval := fs.Duration("interval", 5*time.Second, "polling interval")
+	minHold := fs.Duration("min-hold", time.Minute, "keep the meeting preset at least this long")
+	// Zoom runs CptHost (macOS) or aomhost (Windows/Linux) only while in a
+	// call. Teams and Meet are detected by their call window titles.
+	processes := mustRegexps(`^(CptHost|aomhost)$`)
+	windows := mustRegexps(`^Meet - [a-z]{3}-[a-z]{4}-[a-z]{3}`, `^(Meeting|Call) .*\| Microsoft Teams`)
+	fs.Var(processes, "process", "regular expression matching meeting process names (repeatable)")
+	fs.Var(windows, "window", "regular expression matching meeting window titles (repeatable)")
+	fs.Parse(args)
+
+	p, err := parsePreset(*presetFlag)
+	if err != nil {
+		fatal(err)
+	}
+
+	found := discoverAll()
+	longRunning = true
+
+	var saved snapshot // nil when not in a meeting
+	var since time.Time
+	lastErr := ""
+	for ok := true; ok; ok = sleepOrStop(*interval) {
+		// Detection errors (e.g. wmctrl not installed) are logged once and
+		// otherwise treated as no match.
+		meeting, why, err := inMeeting(processes, windows)
+		if err != nil && err.Error() != lastErr {
+			cmdLog.warnf("meeting detection: %v", err)
+			lastErr = err.Error()
+		}
+		switch {
+		case meeting && saved == nil:
+			cmdLog.infof("meeting detected (%s), applying %s", why, p)
+			saved = capture(found)
+			applyAll(found, p)
+			since = time.Now()
+		case !meeting && saved != nil && time.Since(since) >= *minHold:
+			cmdLog.infof("meeting over, restoring")
+			saved.restore(found)
+			saved = nil
+		}
+	}
+	if saved != nil {
+		saved.restore(found)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+)
+
+// A model is what a model of light can do.
+type model struct {
+	Name    string // without the Elgato prefix, e.g. Key Light Air
+	Color   bool
+	Battery bool
+	Range   lightRange // assumed if the device doesn't report one
+	known   bool
+}
+
+// models are the known models by name, as in the md field of the mDNS TXT
+// record and the productName of accessory-info. All are advertised as
+// 2900K to 7000K.
+var models = []model{
+	{Name: "Key Light", Range: defaultRange},
+	{Name: "Key Light Air", Range: defaultRange},
+	{Name: "Key Light Mini", Battery: true, Range: defaultRange},
+	{Name: "Ring Light", Range: defaultRange},
+	{Name: "Light Strip", Color: true, Range: defaultRange},
+}
+
+// unknownModel is assumed of other models: anything may work, so requests
+// are left to the device to refuse.
+var unknownModel = model{Name: "device", Color: true, Battery: true, Range: defaultRange}
+
+// lookupModel returns the model called name, with or without the Elgato
+// prefix, or unknownModel.
+func lookupModel(name string) model {
+	name = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(name), "Elgato"))
+	for _, m := range models {
+		if strings.EqualFold(m.Name, name) {
+			m.known = true
+			return m
+		}
+	}
+	return unknownModel
+}
+
+// deviceModel returns the model of the device at hostName, as advertised
+// over mDNS or, failing that, in its accessory-info.
+func deviceModel(hostName string) model {
+	c := loadCache()
+	name := c[c.find(hostName)].Model
+	if name == "" {
+		if h, err := cachedInfo(hostName, func(h cachedHost) bool { return h.Product == "" }); err == nil {
+			name = h.Product
+		}
+	}
+	m := lookupModel(name)
+	if !m.known {
+		deviceLog(hostName).debugf("unknown model %q, assuming it supports everything", name)
+	}
+	return m
+}
+
+// colorFlagName returns the flag that asked for a color, for messages.
+func colorFlagName() string {
+	switch {
+	case *colorSpec != "":
+		return "-color"
+	case hue.set:
+		return "-hue"
+	}
+	return "-saturation"
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttBridge publishes the state of the daemon's devices to an MQTT broker
+// and applies commands from it, in the JSON schema of Home Assistant's MQTT
+// lights, announcing each device for Home Assistant's MQTT discovery.
+//
+// Topics, under prefix (elgo by default), are:
+//
+//	elgo/status            online or offline
+//	elgo/ID/state          {"state":"ON","brightness":40,"color_temp":213}
+//	elgo/ID/set            commands, in the same form
+//
+// where ID is the device's MAC address without colons or, failing that, its
+// name. color_temp is in mireds, which are the device's own units.
+type mqttBridge struct {
+	client          paho.Client
+	prefix          string
+	discoveryPrefix string
+	devices         []*tracked
+}
+
+// mqttState is a state or command in Home Assistant's JSON schema.
+type mqttState struct {
+	State      string `json:"state,omitempty"` // ON or OFF
+	Brightness int    `json:"brightness,omitempty"`
+	ColorTemp  int    `json:"color_temp,omitempty"`
+}
+
+// mqttQoS is the quality of service of every message: at least once.
+const mqttQoS = 1
+
+var mqttUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// mqttID returns the ID of t in topics.
+func mqttID(t *tracked) string {
+	if t.dev.MAC != "" {
+		return strings.ToLower(strings.Replace(t.dev.MAC, ":", "", -1))
+	}
+	return strings.ToLower(mqttUnsafe.ReplaceAllString(t.dev.Instance, "_"))
+}
+
+func (b *mqttBridge) topic(t *tracked, name string) string {
+	return fmt.Sprintf("%s/%s/%s", b.prefix, mqttID(t), name)
+}
+
+func (b *mqttBridge) statusTopic() string {
+	return b.prefix + "/status"
+}
+
+// startMQTT connects to broker and bridges devices to it until stopMQTT.
+// Connecting is retried until it succeeds, and lost connections are
+// restored, after which the bridge resubscribes and republishes everything.
+func startMQTT(broker, clientID, prefix, discoveryPrefix string, devices []*tracked) *mqttBridge {
+	b := &mqttBridge{prefix: prefix, discoveryPrefix: discoveryPrefix, devices: devices}
+	opts := paho.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(5*time.Second).
+		SetMaxReconnectInterval(time.Minute).
+		SetWill(b.statusTopic(), "offline", mqttQoS, true).
+		SetOnConnectHandler(b.connected).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			daemonLog.errorf("mqtt: connection lost: %v", err)
+		})
+	b.client = paho.NewClient(opts)
+	for _, t := range devices {
+		t := t
+		t.mu.Lock()
+		t.onChange = append(t.onChange, func(_, s state, _ string) {
+			b.publishState(t, s)
+		})
+		t.mu.Unlock()
+	}
+	b.client.Connect()
+	return b
+}
+
+// connected announces and subscribes to every device, on each connection.
+func (b *mqttBridge) connected(c paho.Client) {
+	daemonLog.infof("mqtt: connected")
+	for _, t := range b.devices {
+		t := t
+		b.publish(b.discoveryTopic(t), b.discoveryConfig(t))
+		c.Subscribe(b.topic(t, "set"), mqttQoS, func(_ paho.Client, m paho.Message) {
+			b.command(t, m.Payload())
+		})
+		s, _ := t.current()
+		b.publishState(t, s)
+	}
+	b.publish(b.statusTopic(), "online")
+}
+
+// publish sends a retained message without waiting for it to be delivered.
+// Messages published while disconnected are dropped, and then republished
+// on reconnecting.
+func (b *mqttBridge) publish(topic string, payload interface{}) {
+	if !b.client.IsConnectionOpen() {
+		return
+	}
+	if v, ok := payload.(string); !ok {
+		j, err := json.Marshal(payload)
+		if err != nil {
+			fatal(err)
+		}
+		payload = j
+	} else {
+		payload = []byte(v)
+	}
+	b.client.Publish(topic, mqttQoS, true, payload)
+}
+
+func (b *mqttBridge) publishState(t *tracked, s state) {
+	if len(s.Lights) == 0 {
+		return
+	}
+	l := s.Lights[0]
+	ms := mqttState{State: "OFF", Brightness: l.Brightness, ColorTemp: l.Temperature}
+	if l.On != 0 {
+		ms.State = "ON"
+	}
+	b.publish(b.topic(t, "state"), ms)
+}
+
+// command applies a command received for t.
+func (b *mqttBridge) command(t *tracked, payload []byte) {
+	var ms mqttState
+	if err := json.Unmarshal(payload, &ms); err != nil {
+		t.log(daemonLog).errorf("mqtt: bad command %s: %v", payload, err)
+		return
+	}
+	l := light{Brightness: ms.Brightness, Temperature: ms.ColorTemp}
+	switch strings.ToUpper(ms.State) {
+	case "ON":
+		l.On = 1
+	case "OFF":
+	case "":
+		// Only changing brightness or temperature.
+		s, _ := t.current()
+		if len(s.Lights) > 0 {
+			l.On = s.Lights[0].On
+		}
+	default:
+		t.log(daemonLog).errorf("mqtt: bad state %q", ms.State)
+		return
+	}
+	if _, err := t.set(l); err != nil {
+		t.log(daemonLog).errorf("mqtt: %v", err)
+	}
+}
+
+func (b *mqttBridge) discoveryTopic(t *tracked) string {
+	return fmt.Sprintf("%s/light/elgo_%s/config", b.discoveryPrefix, mqttID(t))
+}
+
+// discoveryConfig returns the Home Assistant MQTT discovery config of t.
+func (b *mqttBridge) discoveryConfig(t *tracked) map[string]interface{} {
+	r := deviceRange(t.dev.HostName)
+	id := "elgo_" + mqttID(t)
+	dev := map[string]interface{}{
+		"identifiers":  []string{id},
+		"name":         t.dev.Instance,
+		"manufacturer": "Elgato",
+	}
+	if t.dev.MAC != "" {
+		dev["connections"] = [][]string{{"mac", strings.ToLower(t.dev.MAC)}}
+	}
+	return map[string]interface{}{
+		"name":                  nil, // use the device's name
+		"unique_id":             id,
+		"schema":                "json",
+		"state_topic":           b.topic(t, "state"),
+		"command_topic":         b.topic(t, "set"),
+		"availability_topic":    b.statusTopic(),
+		"brightness":            true,
+		"brightness_scale":      r.BrightnessMax,
+		"supported_color_modes": []string{"color_temp"},
+		"min_mireds":            r.TemperatureMin,
+		"max_mireds":            r.TemperatureMax,
+		"device":                dev,
+	}
+}
+
+// stop marks the bridge offline and disconnects.
+func (b *mqttBridge) stop() {
+	if b.client.IsConnectionOpen() {
+		b.client.Publish(b.statusTopic(), mqttQoS, true, "offline").WaitTimeout(time.Second)
+	}
+	b.client.Disconnect(250)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// notifyInterval is the minimum time between notifications. Changes in
+// between (e.g. during a fade) are collapsed into one notification.
+const notifyInterval = 3 * time.Second
+
+// A notifier sends desktop notifications in the background, so that a slow
+// or missing notification service never delays light control.
+type notifier struct {
+	mu      sync.Mutex
+	last    time.Time
+	pending []string // lines waiting for the next notification
+	timer   *time.Timer
+}
+
+func newNotifier() *notifier {
+	return &notifier{}
+}
+
+// events notifies of each event, attributing them to source.
+func (n *notifier) events(events []event, source string) {
+	for _, e := range events {
+		n.notify(fmt.Sprintf("%s (%s)", e, source))
+	}
+}
+
+// notify sends line as a notification, or queues it to be sent with others
+// once notifyInterval has passed since the last one.
+func (n *notifier) notify(line string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.pending = append(n.pending, line)
+	if n.timer != nil {
+		return
+	}
+	wait := notifyInterval - time.Since(n.last)
+	if wait < 0 {
+		wait = 0
+	}
+	n.timer = time.AfterFunc(wait, n.flush)
+}
+
+func (n *notifier) flush() {
+	n.mu.Lock()
+	lines := n.pending
+	n.pending = nil
+	n.timer = nil
+	n.last = time.Now()
+	n.mu.Unlock()
+
+	// Keep only the latest few lines of a burst.
+	if len(lines) > 4 {
+		lines = append([]string{fmt.Sprintf("(%d earlier changes)", len(lines)-3)}, lines[len(lines)-3:]...)
+	}
+	if err := sendNotification("elgo", strings.Join(lines, "\n")); err != nil {
+		cmdLog.debugf("notification: %v", err)
+	}
+}
@@ -0,0 +1,12 @@
+package main
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// sendNotification shows a notification through Notification Center.
+func sendNotification(title, body string) error {
+	script := "display notification " + strconv.Quote(body) + " with title " + strconv.Quote(title)
+	return exec.Command("osascript", "-e", script).Run()
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os/exec"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// sendNotification shows a notification through the freedesktop
+// notification service, falling back to notify-send.
+func sendNotification(title, body string) error {
+	conn, err := dbus.SessionBus()
+	if err == nil {
+		obj := conn.Object("org.freedesktop.Notifications", "/org/freedesktop/Notifications")
+		err = obj.Call("org.freedesktop.Notifications.Notify", 0,
+			"elgo", uint32(0), "", title, body, []string{}, map[string]dbus.Variant{}, int32(-1)).Err
+		if err == nil {
+			return nil
+		}
+	}
+	if _, lookErr := exec.LookPath("notify-send"); lookErr != nil {
+		return err
+	}
+	return exec.Command("notify-send", "--app-name=elgo", title, body).Run()
+}
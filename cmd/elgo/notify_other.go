@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func sendNotification(title, body string) error {
+	return fmt.Errorf("notifications are not supported on %s", runtime.GOOS)
+}
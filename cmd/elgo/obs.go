@@ -0,0 +1,287 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// obs-websocket v5 opcodes and event subscriptions.
+// From: https://github.com/obsproject/obs-websocket/blob/master/docs/generated/protocol.md
+const (
+	obsOpHello           = 0
+	obsOpIdentify        = 1
+	obsOpIdentified      = 2
+	obsOpEvent           = 5
+	obsOpRequest         = 6
+	obsOpRequestResponse = 7
+
+	obsSubscribeScenes  = 1 << 2
+	obsSubscribeOutputs = 1 << 6
+)
+
+type obsMessage struct {
+	Op int             `json:"op"`
+	D  json.RawMessage `json:"d"`
+}
+
+// scenePresets maps OBS scene names to presets, from repeated
+// -scene "NAME=PRESET" flags.
+type scenePresets map[string]preset
+
+func (m scenePresets) String() string {
+	var s []string
+	for k, v := range m {
+		s = append(s, k+"="+v.String())
+	}
+	return strings.Join(s, ",")
+}
+
+func (m scenePresets) Set(s string) error {
+	i := strings.LastIndex(s, "=")
+	if i < 0 {
+		return fmt.Errorf("expected SCENE=PRESET, got %q", s)
+	}
+	p, err := parsePreset(s[i+1:])
+	if err != nil {
+		return err
+	}
+	m[s[:i]] = p
+	return nil
+}
+
+// obsState is what elgo follows in OBS.
+type obsState struct {
+	streaming bool
+	recording bool
+	scene     string
+}
+
+// obsAuth returns the authentication string for password given the salt
+// and challenge from the server's Hello.
+func obsAuth(password, salt, challenge string) string {
+	secret := sha256.Sum256([]byte(password + salt))
+	auth := sha256.Sum256([]byte(base64.StdEncoding.EncodeToString(secret[:]) + challenge))
+	return base64.StdEncoding.EncodeToString(auth[:])
+}
+
+// obsConnect connects and identifies to obs-websocket at url.
+func obsConnect(url, password string) (*websocket.Conn, error) {
+	config, err := websocket.NewConfig(url, "http://localhost/")
+	if err != nil {
+		return nil, err
+	}
+	config.Protocol = []string{"obswebsocket.json"}
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var msg obsMessage
+	if err := websocket.JSON.Receive(ws, &msg); err != nil {
+		ws.Close()
+		return nil, err
+	}
+	if msg.Op != obsOpHello {
+		ws.Close()
+		return nil, fmt.Errorf("expected Hello, got op %d", msg.Op)
+	}
+	var hello struct {
+		RPCVersion     int `json:"rpcVersion"`
+		Authentication *struct {
+			Challenge string `json:"challenge"`
+			Salt      string `json:"salt"`
+		} `json:"authentication"`
+	}
+	if err := json.Unmarshal(msg.D, &hello); err != nil {
+		ws.Close()
+		return nil, err
+	}
+	identify := map[string]interface{}{
+		"rpcVersion":         1,
+		"eventSubscriptions": obsSubscribeScenes | obsSubscribeOutputs,
+	}
+	if a := hello.Authentication; a != nil {
+		identify["authentication"] = obsAuth(password, a.Salt, a.Challenge)
+	}
+	if err := websocket.JSON.Send(ws, map[string]interface{}{"op": obsOpIdentify, "d": identify}); err != nil {
+		ws.Close()
+		return nil, err
+	}
+	if err := websocket.JSON.Receive(ws, &msg); err != nil {
+		ws.Close()
+		return nil, fmt.Errorf("identifying (wrong password?): %v", err)
+	}
+	if msg.Op != obsOpIdentified {
+		ws.Close()
+		return nil, fmt.Errorf("expected Identified, got op %d", msg.Op)
+	}
+	return ws, nil
+}
+
+// obsFollow reads the OBS state from ws, calling update after each change,
+// until the connection fails.
+func obsFollow(ws *websocket.Conn, update func(obsState)) error {
+	for i, r := range []string{"GetStreamStatus", "GetRecordStatus", "GetCurrentProgramScene"} {
+		req := map[string]interface{}{
+			"op": obsOpRequest,
+			"d": map[string]interface{}{
+				"requestType": r,
+				"requestId":   fmt.Sprint(i),
+			},
+		}
+		if err := websocket.JSON.Send(ws, req); err != nil {
+			return err
+		}
+	}
+
+	st := obsState{}
+	for {
+		var msg obsMessage
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			return err
+		}
+		var d struct {
+			// Events
+			EventType string `json:"eventType"`
+			EventData *struct {
+				OutputActive bool   `json:"outputActive"`
+				SceneName    string `json:"sceneName"`
+			} `json:"eventData"`
+
+			// Request responses
+			RequestType  string `json:"requestType"`
+			ResponseData *struct {
+				OutputActive            bool   `json:"outputActive"`
+				CurrentProgramSceneName string `json:"currentProgramSceneName"`
+			} `json:"responseData"`
+		}
+		if err := json.Unmarshal(msg.D, &d); err != nil {
+			return err
+		}
+		switch {
+		case msg.Op == obsOpEvent && d.EventData != nil:
+			switch d.EventType {
+			case "StreamStateChanged":
+				st.streaming = d.EventData.OutputActive
+			case "RecordStateChanged":
+				st.recording = d.EventData.OutputActive
+			case "CurrentProgramSceneChanged":
+				st.scene = d.EventData.SceneName
+			default:
+				continue
+			}
+		case msg.Op == obsOpRequestResponse && d.ResponseData != nil:
+			switch d.RequestType {
+			case "GetStreamStatus":
+				st.streaming = d.ResponseData.OutputActive
+			case "GetRecordStatus":
+				st.recording = d.ResponseData.OutputActive
+			case "GetCurrentProgramScene":
+				st.scene = d.ResponseData.CurrentProgramSceneName
+			}
+		default:
+			continue
+		}
+		update(st)
+	}
+}
+
+// applyAll writes p to every device.
+func applyAll(devices []device, p preset) {
+	wg := &sync.WaitGroup{}
+	for _, d := range devices {
+		wg.Add(1)
+		go func(d device) {
+			defer wg.Done()
+			s, err := p.stateFor(d.HostName)
+			if err == nil {
+				_, err = sendState(d.HostName, s)
+			}
+			if err != nil {
+				cmdLog.warnf("%s: %v", d.Instance, err)
+			}
+		}(d)
+	}
+	wg.Wait()
+}
+
+// obs follows the streaming and recording state of OBS, applying the live
+// preset to every device while either is active and the idle preset
+// otherwise.
+func obs(args []string) {
+	fs := flag.NewFlagSet("obs", flag.ExitOnError)
+	url := fs.String("url", "ws://localhost:4455", "obs-websocket URL")
+	password := fs.String("password", os.Getenv("OBS_PASSWORD"), "obs-websocket password (default $OBS_PASSWORD)")
+	liveFlag := fs.String("live", "on@70/5600K", "preset to apply while streaming or recording")
+	idleFlag := fs.String("idle", "off", "preset to apply otherwise, and on exit")
+	scenes := scenePresets{}
+	fs.Var(scenes, "scene", "preset to apply while live on a scene, as SCENE=PRESET (repeatable)")
+	retry := fs.Duration("retry", 5*time.Second, "delay before reconnecting to OBS")
+	fs.Parse(args)
+
+	live, err := parsePreset(*liveFlag)
+	if err != nil {
+		fatal(err)
+	}
+	idle, err := parsePreset(*idleFlag)
+	if err != nil {
+		fatal(err)
+	}
+
+	found := discoverAll()
+	longRunning = true
+
+	mu := sync.Mutex{}
+	var applied *preset
+	apply := func(p preset) {
+		mu.Lock()
+		defer mu.Unlock()
+		if applied != nil && *applied == p {
+			return
+		}
+		cmdLog.infof("applying %s", p)
+		applyAll(found, p)
+		applied = &p
+	}
+
+	go func() {
+		for {
+			ws, err := obsConnect(*url, *password)
+			if err != nil {
+				cmdLog.warnf("connecting to OBS: %v", err)
+			} else {
+				cmdLog.infof("connected to OBS at %s", *url)
+				err = obsFollow(ws, func(st obsState) {
+					p := idle
+					if st.streaming || st.recording {
+						p = live
+						if sp, ok := scenes[st.scene]; ok {
+							p = sp
+						}
+					}
+					apply(p)
+				})
+				ws.Close()
+				cmdLog.warnf("disconnected from OBS: %v", err)
+			}
+			time.Sleep(*retry)
+		}
+	}()
+
+	ownSignals()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	apply(idle)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// pickSettle is how long to keep listening for other devices after the
+// first is found. Devices on the same network answer a query together, so
+// this is short.
+const pickSettle = 500 * time.Millisecond
+
+// pickDevice returns the address of the device to control when -host isn't
+// given: the only one found, or, if there are several, the one the user
+// picks. Without a terminal to ask on, several devices are an error rather
+// than a guess.
+func pickDevice() string {
+	devs := make(chan device)
+	stop := make(chan struct{})
+	if err := browseMDNS(devs, stop); err != nil {
+		fatal(err)
+	}
+	var found []device
+	seen := map[string]bool{}
+	add := func(d device) {
+		if !seen[d.HostName] {
+			seen[d.HostName] = true
+			found = append(found, d)
+		}
+	}
+	d, ok := <-devs
+	if ok {
+		add(d)
+		settle := time.After(pickSettle)
+	collect:
+		for {
+			select {
+			case d, ok := <-devs:
+				if !ok {
+					break collect
+				}
+				add(d)
+			case <-settle:
+				break collect
+			}
+		}
+	}
+	close(stop)
+	for range devs {
+	}
+	switch len(found) {
+	case 0:
+		noDevice()
+	case 1:
+		return found[0].HostName
+	}
+	sortDevices(found, *sortKey)
+
+	var names []string
+	for _, d := range found {
+		names = append(names, d.Instance)
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stderr.Fd())) {
+		fatal(fmt.Errorf("found %d devices (%s): choose one with -host", len(found), strings.Join(names, ", ")))
+	}
+
+	c := loadCache()
+	def := 0
+	var last time.Time
+	for i, d := range found {
+		if p := c[d.key()].Picked; p.After(last) {
+			def, last = i, p
+		}
+	}
+	for i, d := range found {
+		fmt.Fprintf(os.Stderr, "%2d) %s (%s)\n", i+1, d.Instance, d.HostName)
+	}
+	in := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Fprintf(os.Stderr, "Device [%d]: ", def+1)
+		line, err := in.ReadString('\n')
+		if err != nil {
+			fatal(fmt.Errorf("no device chosen: %v", err))
+		}
+		choice := def
+		if line = strings.TrimSpace(line); line != "" {
+			n, err := strconv.Atoi(line)
+			if err != nil || n < 1 || n > len(found) {
+				fmt.Fprintf(os.Stderr, "enter a number between 1 and %d\n", len(found))
+				continue
+			}
+			choice = n - 1
+		}
+		d := found[choice]
+		h := c[d.key()]
+		h.Picked = time.Now()
+		c[d.key()] = h
+		c.save()
+		return d.HostName
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+
+	"github.com/vsekhar/elgo"
+)
+
+const onlyIfPresentUsage = "if no device is found (or -host doesn't answer), exit successfully without output instead of failing"
+
+var onlyIfPresent = flag.Bool("only-if-present", false, onlyIfPresentUsage)
+
+// noDevice reports that no device was found: an error, unless
+// -only-if-present says it's expected, in which case elgo exits
+// successfully.
+func noDevice() {
+	if *onlyIfPresent {
+		discoveryLog.debugf("no device found (discovery timeout %s), nothing to do", *timeout)
+		exit(0)
+	}
+	fatal(fmt.Errorf("%w (discovery timeout %s)", elgo.ErrNoDeviceFound, *timeout))
+}
+
+// checkPresent exits as noDevice does if -only-if-present is set and the
+// -host device doesn't accept connections, so that only failures of a
+// device that is there are reported.
+func checkPresent() {
+	if !*onlyIfPresent || *host == "" || *replay != "" {
+		return
+	}
+	conn, err := net.DialTimeout("tcp", hostAddr(), requestTimeout())
+	if err != nil {
+		discoveryLog.debugf("%s: %v, nothing to do", hostAddr(), err)
+		exit(0)
+	}
+	conn.Close()
+}
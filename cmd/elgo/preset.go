@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A preset is a light state written as "off", "on", "on@70" (brightness) or
+// "on@70/5600K" (brightness and temperature), or the name of a saved preset.
+type preset struct {
+	On          bool
+	Brightness  int // 0 leaves brightness unchanged
+	Temperature int // Kelvin; 0 leaves temperature unchanged
+}
+
+func parsePreset(s string) (preset, error) {
+	p := preset{}
+	power, rest := s, ""
+	if i := strings.Index(s, "@"); i >= 0 {
+		power, rest = s[:i], s[i+1:]
+	}
+	switch strings.ToLower(power) {
+	case "on":
+		p.On = true
+	case "off":
+		if rest != "" {
+			return preset{}, fmt.Errorf("bad preset %q: off takes no brightness or temperature", s)
+		}
+		return p, nil
+	default:
+		if rest == "" {
+			if saved, err := loadPresets(); err == nil {
+				if v, ok := saved[s]; ok {
+					return parsePreset(v)
+				}
+			}
+		}
+		return preset{}, fmt.Errorf("bad preset %q: must start with on or off, or name a saved preset", s)
+	}
+	if rest == "" {
+		return p, nil
+	}
+	b, t := rest, ""
+	if i := strings.Index(rest, "/"); i >= 0 {
+		b, t = rest[:i], rest[i+1:]
+	}
+	if b != "" {
+		v, err := strconv.Atoi(strings.TrimSuffix(b, "%"))
+		if err != nil || v < 1 {
+			return preset{}, fmt.Errorf("bad preset %q: brightness must be a positive number", s)
+		}
+		p.Brightness = v
+	}
+	if t != "" {
+		v, err := strconv.Atoi(strings.TrimSuffix(strings.ToUpper(t), "K"))
+		if err != nil || v < 1 {
+			return preset{}, fmt.Errorf("bad preset %q: temperature must be a positive number (in Kelvins)", s)
+		}
+		p.Temperature = v
+	}
+	return p, nil
+}
+
+func (p preset) String() string {
+	if !p.On {
+		return "off"
+	}
+	s := "on"
+	if p.Brightness != 0 || p.Temperature != 0 {
+		s += "@"
+		if p.Brightness != 0 {
+			s += strconv.Itoa(p.Brightness)
+		}
+		if p.Temperature != 0 {
+			s += fmt.Sprintf("/%dK", p.Temperature)
+		}
+	}
+	return s
+}
+
+// state returns the request that applies p.
+// stateFor returns the state p sets the device at hostName to, with its
+// brightness and temperature limited to the device's range.
+func (p preset) stateFor(hostName string) (state, error) {
+	l, err := limitLight(hostName, p.Brightness, p.Temperature)
+	if err != nil {
+		return state{}, fmt.Errorf("preset %s: %v", p, err)
+	}
+	if p.On {
+		l.On = 1
+	}
+	return state{NumberOfLights: 1, Lights: []light{l}}, nil
+}
+
+// presetsPath returns the path of the file holding saved presets.
+func presetsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "elgo", "presets.json"), nil
+}
+
+// loadPresets returns saved presets by name. A missing file holds no presets.
+func loadPresets() (map[string]string, error) {
+	m := map[string]string{}
+	path, err := presetsPath()
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return m, nil
+}
+
+// savePreset saves p under name, replacing any preset of that name.
+func savePreset(name string, p preset) error {
+	if l := strings.ToLower(name); name == "" || l == "on" || l == "off" || strings.Contains(name, "@") {
+		return fmt.Errorf("bad preset name %q: must not be empty, on, off or contain @", name)
+	}
+	m, err := loadPresets()
+	if err != nil {
+		return err
+	}
+	m[name] = p.String()
+	path, err := presetsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
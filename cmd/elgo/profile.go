@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+var (
+	cpuProfile = flag.String("cpuprofile", "", "write a CPU profile to this file, for go tool pprof")
+	memProfile = flag.String("memprofile", "", "write a heap profile to this file on exit, for go tool pprof")
+)
+
+var cpuProfileFile *os.File
+
+// startProfiling starts the -cpuprofile. stopProfiling, called by exit,
+// finishes it and writes the -memprofile.
+func startProfiling() {
+	if *cpuProfile == "" {
+		return
+	}
+	f, err := os.Create(*cpuProfile)
+	if err != nil {
+		fatal(err)
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		fatal(err)
+	}
+	cpuProfileFile = f
+}
+
+func stopProfiling() {
+	if cpuProfileFile != nil {
+		pprof.StopCPUProfile()
+		if err := cpuProfileFile.Close(); err != nil {
+			cmdLog.warnf("-cpuprofile: %v", err)
+		}
+		cpuProfileFile = nil
+	}
+	if *memProfile == "" {
+		return
+	}
+	f, err := os.Create(*memProfile)
+	if err != nil {
+		cmdLog.warnf("-memprofile: %v", err)
+		return
+	}
+	defer f.Close()
+	runtime.GC() // for up-to-date statistics
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		cmdLog.warnf("-memprofile: %v", err)
+	}
+}
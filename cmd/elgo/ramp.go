@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// rampStep is the interval between writes during a ramp.
+const rampStep = 40 * time.Millisecond
+
+// rampContext returns a context for a ramp, done at deadline or once
+// interrupted, so that a ramp can be stopped where it has got to.
+func rampContext(deadline time.Time) (context.Context, context.CancelFunc) {
+	return context.WithDeadline(interrupted, deadline)
+}
+
+// ramp writes states stepping from from towards to, which have the same
+// lights, over d, leaving the final write, at to, to the caller. It returns
+// the last state written, or from if none was. If ctx is done first, it
+// stops there and returns ctx.Err().
+func ramp(ctx context.Context, hostName string, from, to state, d time.Duration) (state, error) {
+	n := int(d / rampStep)
+	if n < 1 {
+		n = 1
+	}
+	last := from
+	for k := 0; k < n; k++ {
+		step := state{NumberOfLights: to.NumberOfLights, Lights: make([]light, len(to.Lights))}
+		for j := range to.Lights {
+			step.Lights[j] = between(from.Lights[j], to.Lights[j], k, n)
+		}
+		if _, err := sendState(hostName, step); err != nil {
+			return last, err
+		}
+		last = step
+		t := time.NewTimer(rampStep)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return last, ctx.Err()
+		case <-stopping:
+			t.Stop()
+			return last, errStopping
+		}
+	}
+	return last, nil
+}
+
+// between returns b with the brightness and, if both have one, the
+// temperature k nths of the way from a.
+func between(a, b light, k, n int) light {
+	l := b
+	l.Brightness = a.Brightness + (b.Brightness-a.Brightness)*k/n
+	if a.Temperature != 0 && b.Temperature != 0 {
+		l.Temperature = a.Temperature + (b.Temperature-a.Temperature)*k/n
+	}
+	return l
+}
+
+// describeLight returns the brightness and temperature or color of l, for
+// messages.
+func describeLight(l light) string {
+	switch {
+	case inColor(l):
+		return fmt.Sprintf("%d%%, %s", l.Brightness, describeColor(l))
+	case l.Temperature == 0:
+		return fmt.Sprintf("%d%%", l.Brightness)
+	}
+	return fmt.Sprintf("%d%%, %dK", l.Brightness, toKelvin(l.Temperature))
+}
+
+// stoppedAt prints where a ramp on the device named was stopped by err, and
+// exits with exitInterrupted.
+func stoppedAt(name string, s state, err error) {
+	for i, l := range s.Lights {
+		if len(s.Lights) == 1 {
+			fmt.Printf("%s: stopped at %s\n", name, describeLight(l))
+		} else {
+			fmt.Printf("%s: light %d: stopped at %s\n", name, i, describeLight(l))
+		}
+	}
+	if err == context.DeadlineExceeded {
+		fmt.Fprintf(os.Stderr, "%s: timed out\n", name)
+	}
+	exit(exitInterrupted)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"sync"
+	"time"
+)
+
+// coalesceWrites makes sendState coalesce concurrent writes to a device even
+// without -min-write-interval, keeping at most one write in flight and one
+// waiting. Long-running modes that take requests from clients set it.
+var coalesceWrites bool
+
+var minWriteInterval = flag.Duration("min-write-interval", 0, "leave at least this long between writes to a device, sending only the latest of any writes made in between (0 for no limit)")
+
+// A hostWriter limits the rate of writes to one device.
+type hostWriter struct {
+	sendMu sync.Mutex // held while waiting for and sending a write
+	last   time.Time  // when the last write finished
+
+	mu      sync.Mutex
+	pending *pendingWrite // waiting for sendMu, accepting newer targets
+}
+
+// A pendingWrite is a write waiting its turn. Writes made while it waits
+// are merged into it, and share its result.
+type pendingWrite struct {
+	s    state
+	done chan struct{}
+	r    state
+	err  error
+}
+
+var (
+	writersMu sync.Mutex
+	writers   = map[string]*hostWriter{}
+)
+
+// sendState writes s to the device at hostName and returns the resulting
+// state, no sooner than -min-write-interval after the last write to it.
+// Writes made while waiting are coalesced, so ramps skip to their latest
+// target rather than queueing up behind a slow device.
+func sendState(hostName string, s state) (state, error) {
+	if *minWriteInterval <= 0 && !coalesceWrites {
+		return deviceWrite(hostName, s)
+	}
+	writersMu.Lock()
+	w, ok := writers[hostName]
+	if !ok {
+		w = &hostWriter{}
+		writers[hostName] = w
+	}
+	writersMu.Unlock()
+
+	w.mu.Lock()
+	if p := w.pending; p != nil {
+		p.s = mergeStates(p.s, s)
+		w.mu.Unlock()
+		<-p.done
+		return p.r, p.err
+	}
+	p := &pendingWrite{s: s, done: make(chan struct{})}
+	w.pending = p
+	w.mu.Unlock()
+
+	w.sendMu.Lock()
+	if wait := *minWriteInterval - time.Since(w.last); wait > 0 {
+		sleepOrStop(wait)
+	}
+	w.mu.Lock()
+	w.pending = nil
+	s = p.s
+	w.mu.Unlock()
+	p.r, p.err = deviceWrite(hostName, s)
+	w.last = time.Now()
+	w.sendMu.Unlock()
+	close(p.done)
+	return p.r, p.err
+}
+
+// mergeStates returns the target of writing old then new: new, with any
+// brightness or temperature it leaves unchanged taken from old.
+func mergeStates(old, new state) state {
+	merged := new
+	merged.Lights = append([]light(nil), new.Lights...)
+	for i := range merged.Lights {
+		if i >= len(old.Lights) {
+			break
+		}
+		if merged.Lights[i].Brightness == 0 {
+			merged.Lights[i].Brightness = old.Lights[i].Brightness
+		}
+		if merged.Lights[i].Temperature == 0 {
+			merged.Lights[i].Temperature = old.Lights[i].Temperature
+		}
+	}
+	for i := len(new.Lights); i < len(old.Lights); i++ {
+		merged.Lights = append(merged.Lights, old.Lights[i])
+	}
+	merged.NumberOfLights = len(merged.Lights)
+	return merged
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalesceWrites(t *testing.T) {
+	coalesceWrites = true
+	defer func() { coalesceWrites = false }()
+	sim := testLight(false)
+	sim.latency = 20 * time.Millisecond
+	h := sim.handler(simDevice{name: "Sim", model: "Elgato Key Light", firmware: "1.0.3"})
+	var puts int32
+	hostName := fakeDevice(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			atomic.AddInt32(&puts, 1)
+		}
+		h.ServeHTTP(w, r)
+	}))
+
+	const updates = 100
+	var wg sync.WaitGroup
+	for i := 1; i <= updates; i++ {
+		wg.Add(1)
+		go func(brightness int) {
+			defer wg.Done()
+			s, err := sendState(hostName, state{NumberOfLights: 1, Lights: []light{{On: 1, Brightness: brightness}}})
+			if err != nil {
+				t.Errorf("update to %d%%: %v", brightness, err)
+			} else if len(s.Lights) != 1 || s.Lights[0].On != 1 {
+				t.Errorf("update to %d%%: got %+v", brightness, s)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// One write in flight and one waiting at a time: the first, and then
+	// a handful of merged ones while it and each next write take 20ms.
+	if n := atomic.LoadInt32(&puts); n == 0 || n > updates/10 {
+		t.Errorf("%d updates sent as %d PUTs, want far fewer", updates, n)
+	}
+	if s := sim.lightState(); s.Lights[0].On != 1 || s.Lights[0].Brightness < 1 || s.Lights[0].Brightness > updates {
+		t.Errorf("device left at %+v", s)
+	}
+}
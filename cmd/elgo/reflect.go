@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/ipv4"
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// isElgato reports whether msg asks about or announces the Elgato service,
+// or another of -service. Goodbye packets are ordinary responses with a zero
+// TTL, so they pass too.
+func isElgato(msg *dns.Msg) bool {
+	match := func(name string) bool {
+		name = strings.ToLower(name)
+		for _, s := range services() {
+			s = strings.ToLower(s) + ".local."
+			if name == s || strings.HasSuffix(name, "."+s) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, q := range msg.Question {
+		if match(q.Name) {
+			return true
+		}
+	}
+	for _, rrs := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range rrs {
+			if match(rr.Header().Name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// localAddrs returns the addresses of ifaces, to recognize (and not reflect)
+// packets we sent ourselves.
+func localAddrs(ifaces ...*net.Interface) (map[string]bool, error) {
+	m := map[string]bool{}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok {
+				m[ipnet.IP.String()] = true
+			}
+		}
+	}
+	return m, nil
+}
+
+// reflector relays mDNS traffic for the Elgato service between two
+// interfaces: queries seen on -to are repeated on -from, and responses
+// (including goodbyes) seen on -from are repeated on -to. IPv4 only.
+func reflector(args []string) {
+	fs := flag.NewFlagSet("reflect", flag.ExitOnError)
+	fromName := fs.String("from", "", "interface the lights are on (required)")
+	toName := fs.String("to", "", "interface to make the lights discoverable on (required)")
+	fs.Parse(args)
+
+	// Reflecting between the wrong interfaces, or an interface and itself,
+	// can cause multicast storms, so there are no defaults.
+	if *fromName == "" || *toName == "" {
+		fatal(errors.New("reflect requires both -from and -to interfaces"))
+	}
+	if *fromName == *toName {
+		fatal(errors.New("-from and -to must be different interfaces"))
+	}
+	from, err := net.InterfaceByName(*fromName)
+	if err != nil {
+		fatal(fmt.Errorf("-from: %v", err))
+	}
+	to, err := net.InterfaceByName(*toName)
+	if err != nil {
+		fatal(fmt.Errorf("-to: %v", err))
+	}
+	self, err := localAddrs(from, to)
+	if err != nil {
+		fatal(err)
+	}
+
+	// Other mDNS responders on the host, such as avahi, usually hold the
+	// port already, so share it with them.
+	lc := net.ListenConfig{Control: reusePort}
+	conn, err := lc.ListenPacket(context.Background(), "udp4", fmt.Sprintf("0.0.0.0:%d", mdnsGroup.Port))
+	if err != nil {
+		fatal(err)
+	}
+	p := ipv4.NewPacketConn(conn)
+	for _, iface := range []*net.Interface{from, to} {
+		if err := p.JoinGroup(iface, &net.UDPAddr{IP: mdnsGroup.IP}); err != nil {
+			fatal(fmt.Errorf("joining mDNS group on %s: %v", iface.Name, err))
+		}
+	}
+	if err := p.SetControlMessage(ipv4.FlagInterface, true); err != nil {
+		fatal(err)
+	}
+	if err := p.SetMulticastLoopback(false); err != nil {
+		fatal(err)
+	}
+	cmdLog.infof("reflecting %s between %s and %s", strings.Join(services(), ", "), from.Name, to.Name)
+
+	// Drop repeats of a packet within a second, as a second line of defense
+	// against loops through other reflectors.
+	mu := sync.Mutex{}
+	recent := map[string]time.Time{}
+	seen := func(b []byte) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		now := time.Now()
+		for k, t := range recent {
+			if now.Sub(t) > time.Second {
+				delete(recent, k)
+			}
+		}
+		if _, ok := recent[string(b)]; ok {
+			return true
+		}
+		recent[string(b)] = now
+		return false
+	}
+
+	// Closing the connection stops the read below once interrupted.
+	go func() {
+		<-interrupted.Done()
+		conn.Close()
+	}()
+	buf := make([]byte, 9000)
+	for {
+		n, cm, src, err := p.ReadFrom(buf)
+		if interrupted.Err() != nil {
+			return
+		}
+		if err != nil {
+			fatal(err)
+		}
+		if cm == nil {
+			continue
+		}
+		if udp, ok := src.(*net.UDPAddr); !ok || self[udp.IP.String()] || udp.Port != mdnsGroup.Port {
+			continue
+		}
+		pkt := buf[:n]
+		msg := &dns.Msg{}
+		if err := msg.Unpack(pkt); err != nil || !isElgato(msg) {
+			continue
+		}
+
+		var out *net.Interface
+		switch {
+		case !msg.Response && cm.IfIndex == to.Index:
+			out = from
+		case msg.Response && cm.IfIndex == from.Index:
+			out = to
+		default:
+			continue
+		}
+		if seen(pkt) {
+			continue
+		}
+		kind := "query"
+		if msg.Response {
+			kind = "response"
+		}
+		cmdLog.debugf("%s from %s on %s -> %s", kind, src, ifaceName(cm.IfIndex, from, to), out.Name)
+		if _, err := p.WriteTo(pkt, &ipv4.ControlMessage{IfIndex: out.Index}, mdnsGroup); err != nil {
+			cmdLog.warnf("sending on %s: %v", out.Name, err)
+		}
+	}
+}
+
+func ifaceName(index int, ifaces ...*net.Interface) string {
+	for _, iface := range ifaces {
+		if iface.Index == index {
+			return iface.Name
+		}
+	}
+	return fmt.Sprint(index)
+}
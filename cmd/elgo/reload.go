@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+// daemonConfig is the configuration the daemon can reload without
+// restarting: the saved presets, parsed.
+type daemonConfig struct {
+	presets map[string]preset
+	specs   map[string]string // as saved, for logging changes
+}
+
+// loadDaemonConfig reads and validates the daemon configuration.
+func loadDaemonConfig() (*daemonConfig, error) {
+	saved, err := loadPresets()
+	if err != nil {
+		return nil, err
+	}
+	c := &daemonConfig{presets: map[string]preset{}, specs: saved}
+	for name, spec := range saved {
+		p, err := parsePreset(spec)
+		if err != nil {
+			return nil, fmt.Errorf("preset %s: %v", name, err)
+		}
+		c.presets[name] = p
+	}
+	return c, nil
+}
+
+// liveConfig holds the daemon's current configuration.
+type liveConfig struct {
+	mu  sync.Mutex
+	cur *daemonConfig
+}
+
+func (l *liveConfig) get() *daemonConfig {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.cur
+}
+
+// reload loads the configuration again and, if it is valid, replaces the
+// current one, logging what changed. Invalid configuration is rejected and
+// the current one kept.
+func (l *liveConfig) reload() error {
+	c, err := loadDaemonConfig()
+	if err != nil {
+		daemonLog.errorf("reload: keeping current config: %v", err)
+		return err
+	}
+	l.mu.Lock()
+	old := l.cur
+	l.cur = c
+	l.mu.Unlock()
+
+	var names []string
+	for name := range old.specs {
+		names = append(names, name)
+	}
+	for name := range c.specs {
+		if _, ok := old.specs[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	changes := 0
+	for _, name := range names {
+		was, hadIt := old.specs[name]
+		now, hasIt := c.specs[name]
+		switch {
+		case !hadIt:
+			daemonLog.infof("reload: preset %s added: %s", name, now)
+		case !hasIt:
+			daemonLog.infof("reload: preset %s removed", name)
+		case was != now:
+			daemonLog.infof("reload: preset %s changed: %s -> %s", name, was, now)
+		default:
+			continue
+		}
+		changes++
+	}
+	if changes == 0 {
+		daemonLog.infof("reload: no changes")
+	}
+	return nil
+}
+
+// reloadOnHangup reloads l on each SIGHUP.
+func reloadOnHangup(l *liveConfig) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		l.reload()
+	}
+}
+
+// reload handles POST /reload, reloading the daemon's configuration.
+func (a *api) reload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err := a.config.reload(); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "reloaded"})
+}
+
+// ctl sends commands to a running daemon over its HTTP API.
+func ctl(args []string) {
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:9124", "address of the daemon's HTTP API (its -listen)")
+	fs.Parse(args)
+	if fs.NArg() != 1 || fs.Arg(0) != "reload" {
+		fatal(errors.New("usage: elgo ctl [-addr host:port] reload"))
+	}
+
+	token, err := apiToken()
+	if err != nil {
+		fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/reload", *addr), nil)
+	if err != nil {
+		fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := &http.Client{Timeout: *timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		fatal(err)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		fatal(fmt.Errorf("reload: %s: %s", resp.Status, bytes.TrimSpace(b)))
+	}
+	cmdLog.debugf("reload: %s", bytes.TrimSpace(b))
+}
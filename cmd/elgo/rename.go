@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxDisplayName is the longest display name rename sends. Devices don't
+// report their limit, so this is kept to what the Elgato apps allow.
+const maxDisplayName = 32
+
+// rename sets the display name of a device, as the Elgato apps do.
+func rename(args []string) {
+	fs := flag.NewFlagSet("rename", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fatal(errors.New(`usage: elgo rename NAME, e.g. elgo rename "Desk Left"`))
+	}
+	name := strings.TrimSpace(fs.Arg(0))
+	switch n := utf8.RuneCountInString(name); {
+	case n == 0:
+		fatal(errors.New("name must not be empty"))
+	case n > maxDisplayName:
+		fatal(fmt.Errorf("name is %d characters long; the maximum is %d", n, maxDisplayName))
+	}
+
+	hostName := resolveHost()
+	old, err := fetchAccessoryInfo(hostName)
+	if err != nil {
+		fatal(err)
+	}
+	body, err := json.Marshal(map[string]string{"displayName": name})
+	if err != nil {
+		fatal(err)
+	}
+	if _, err := request(http.MethodPut, deviceURL(hostName, accessoryInfoEndpoint), body); err != nil {
+		fatal(err)
+	}
+	info, err := fetchAccessoryInfo(hostName)
+	if err != nil {
+		fatal(err)
+	}
+	if info.DisplayName != name {
+		fatal(fmt.Errorf("%s: device reports name %q after renaming to %q", hostName, info.DisplayName, name))
+	}
+
+	// Devices found via mDNS are cached under their instance name, which is
+	// usually the display name.
+	c := loadCache()
+	if key := c.find(hostName); c[key].Instance == old.DisplayName {
+		h := c[key]
+		h.Instance = name
+		c[key] = h
+		c.save()
+	}
+	deviceLog(hostName).debugf("renamed %q to %q", old.DisplayName, name)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/vsekhar/elgo"
+)
+
+// failingDevice answers every request with status and body.
+func failingDevice(status int, body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	})
+}
+
+// deviceErrorCases are devices that fail a PUT to the lights, and what
+// the error says.
+var deviceErrorCases = []struct {
+	name    string
+	handler http.Handler
+	message string
+}{
+	{"404", failingDevice(http.StatusNotFound, "404 page not found"), "device returned 404 Not Found on PUT /elgato/lights: 404 page not found"},
+	{"500", failingDevice(http.StatusInternalServerError, "oops"), "device returned 500 Internal Server Error on PUT /elgato/lights: oops"},
+	{"empty 200", failingDevice(http.StatusOK, ""), "PUT lights"},
+}
+
+func TestDeviceErrors(t *testing.T) {
+	*noRetry = true
+	defer func() { *noRetry = false }()
+	for _, tt := range deviceErrorCases {
+		hostName := fakeDevice(t, tt.handler)
+		_, err := writeState(hostName, state{NumberOfLights: 1, Lights: []light{{On: 1}}})
+		if err == nil {
+			t.Errorf("%s: no error", tt.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.message) || !strings.HasPrefix(err.Error(), hostName) {
+			t.Errorf("%s: error %q, want %q from %s", tt.name, err, tt.message, hostName)
+		}
+		if code := exitStatus(err); code != exitDeviceError {
+			t.Errorf("%s: exit status %d, want %d", tt.name, code, exitDeviceError)
+		}
+	}
+
+	var httpErr *elgo.HTTPError
+	_, err := fetchState(fakeDevice(t, failingDevice(http.StatusInternalServerError, strings.Repeat("x", 1000))))
+	if !errors.As(err, &httpErr) || httpErr.Status != http.StatusInternalServerError {
+		t.Errorf("GET with 500: %v, want an HTTPError", err)
+	} else if len(err.Error()) > 400 {
+		t.Errorf("GET with 500 quotes too much of the body: %q", err)
+	}
+	var badResp *elgo.BadResponseError
+	if _, err := fetchState(fakeDevice(t, failingDevice(http.StatusOK, ""))); !errors.As(err, &badResp) {
+		t.Errorf("GET with an empty 200: %v, want a BadResponseError", err)
+	}
+}
+
+func TestDeviceErrorExitStatus(t *testing.T) {
+	bin := buildElgo(t)
+	for _, tt := range deviceErrorCases {
+		_, stderr, code := runElgoOutput(t, bin, "-host", fakeDevice(t, tt.handler), "-no-retry", "on")
+		if code != exitDeviceError {
+			t.Errorf("%s: exit status %d, want %d", tt.name, code, exitDeviceError)
+		}
+		if !strings.Contains(stderr, tt.message) {
+			t.Errorf("%s: printed %q, want %q", tt.name, stderr, tt.message)
+		}
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	sim := testLight(false)
+	srv := httptest.NewUnstartedServer(sim.handler(simDevice{name: "Sim", model: "Elgato Key Light", firmware: "1.0.3"}))
+	var accepted int32
+	srv.Config.ConnState = func(_ net.Conn, s http.ConnState) {
+		if s == http.StateNew {
+			atomic.AddInt32(&accepted, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+	hostName := srv.Listener.Addr().String()
+
+	// connections counts the GotConns that weren't Reused.
+	opened := atomic.LoadInt32(&connections)
+	s, err := fetchState(hostName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Lights[0].On = 1
+	if _, err := writeState(hostName, s); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&connections) - opened; n != 1 {
+		t.Errorf("GET and PUT opened %d connections, want 1", n)
+	}
+	if n := atomic.LoadInt32(&accepted); n != 1 {
+		t.Errorf("device accepted %d connections, want 1", n)
+	}
+}
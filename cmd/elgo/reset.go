@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/vsekhar/elgo"
+)
+
+// The state reset sets, which the config file can change.
+var (
+	resetBrightness  = flag.Int("reset-brightness", 50, "brightness that reset sets")
+	resetTemperature = flag.Int("reset-temperature", 4000, "color temperature in Kelvin that reset sets")
+)
+
+// reset turns every light of a device on at -reset-brightness and
+// -reset-temperature in one write, then reads the device back to confirm,
+// for when a script or the app has left it somewhere odd.
+func reset(args []string) {
+	fs := flag.NewFlagSet("reset", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fatal(errors.New("usage: elgo reset"))
+	}
+
+	hostName := resolveHost()
+	r := deviceRange(hostName)
+	b, err := limitValue(hostName, "-reset-brightness", *resetBrightness, r.BrightnessMin, r.BrightnessMax)
+	if err != nil {
+		fatal(fmt.Errorf("%w: %s: %v", elgo.ErrInvalidBrightness, hostName, err))
+	}
+	min, max := r.kelvin()
+	k, err := limitValue(hostName, "-reset-temperature", *resetTemperature, min, max)
+	if err != nil {
+		fatal(fmt.Errorf("%w: %s: %v", elgo.ErrInvalidTemperature, hostName, err))
+	}
+
+	cur := getState(hostName)
+	want := light{On: 1, Brightness: b, Temperature: r.temperature(k)}
+	s := state{NumberOfLights: len(cur.Lights)}
+	for _, l := range cur.Lights {
+		w := want
+		w.ID = l.ID
+		s.Lights = append(s.Lights, w)
+	}
+	if len(s.Lights) == 0 {
+		s = state{NumberOfLights: 1, Lights: []light{want}}
+	}
+	putState(hostName, s)
+
+	got := getState(hostName)
+	for i, l := range got.Lights {
+		if l.On != 1 || l.Brightness != want.Brightness || l.Temperature != want.Temperature {
+			fatal(fmt.Errorf("%s: light %d is at %s after reset, not %s", hostName, i, describeLight(l), describeLight(want)))
+		}
+	}
+	fmt.Printf("%s: reset to %s\n", hostName, describeLight(want))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// Over Wi-Fi, the first request to a light that has just woken often fails
+// and the next one works, so GETs and PUTs, which are safe to repeat, are
+// retried on transient failures.
+var (
+	retries = flag.Int("retries", 3, "times to retry a GET or PUT that fails with a connection error, timeout or 5xx response")
+	noRetry = flag.Bool("no-retry", false, "don't retry failed requests, except while the device is busy (for debugging)")
+)
+
+// retryBackoff is the delay before the first retry after a transient
+// failure. Each later one doubles it, up to maxBackoff.
+const retryBackoff = 200 * time.Millisecond
+
+// retryable reports whether a request with method may be retried after
+// retry number n (from 0) of a transient failure.
+func retryable(method string, n int) bool {
+	if *noRetry || n >= *retries {
+		return false
+	}
+	return method == http.MethodGet || method == http.MethodPut
+}
+
+// retryDelay returns how long to wait before retry number n (from 0) after
+// a transient failure, with up to half again of jitter so that lights woken
+// together aren't all asked again at once.
+func retryDelay(n int) time.Duration {
+	d := retryBackoff << uint(n)
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d + time.Duration(rand.Int63n(int64(d)/2+1))
+}
+
+// transientStatus reports whether a response with status is worth
+// retrying: a server error that doesn't mean the device is busy.
+func transientStatus(status int) bool {
+	return status >= 500 && !busyStatuses[status]
+}
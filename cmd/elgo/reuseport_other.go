@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package main
+
+import "syscall"
+
+// reusePort does nothing here, so the mDNS port can't be shared.
+func reusePort(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,26 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package main
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// reusePort lets other mDNS responders on the host, such as avahi or
+// mDNSResponder, bind the mDNS port alongside elgo.
+func reusePort(network, address string, c syscall.RawConn) error {
+	var err error
+	cerr := c.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+		if err == nil {
+			err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+		}
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+)
+
+var validateSchema = flag.Bool("validate-schema", false, "warn when device responses don't match the expected schema, to catch firmware changes")
+
+// lightsSchema describes the expected /elgato/lights response. Update it
+// when firmware legitimately changes the response.
+//
+//go:embed lights.schema.json
+var lightsSchema []byte
+
+// schema is the subset of JSON Schema used by lights.schema.json.
+type schema struct {
+	Type       string             `json:"type"`
+	Required   []string           `json:"required"`
+	Properties map[string]*schema `json:"properties"`
+	Items      *schema            `json:"items"`
+	Minimum    *float64           `json:"minimum"`
+	Maximum    *float64           `json:"maximum"`
+	Enum       []interface{}      `json:"enum"`
+}
+
+// jsonType returns the JSON Schema type of a value decoded into an
+// interface{}.
+func jsonType(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// check appends a description of each mismatch between v and s, at path,
+// to problems.
+func (s *schema) check(path string, v interface{}, problems []string) []string {
+	t := jsonType(v)
+	if s.Type != "" && t != s.Type && !(s.Type == "number" && t == "integer") {
+		return append(problems, fmt.Sprintf("%s: expected %s, got %s", path, s.Type, t))
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if e == v {
+				found = true
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s: %v is not one of %v", path, v, s.Enum))
+		}
+	}
+	if n, ok := v.(float64); ok {
+		if s.Minimum != nil && n < *s.Minimum {
+			problems = append(problems, fmt.Sprintf("%s: %v is less than %v", path, n, *s.Minimum))
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			problems = append(problems, fmt.Sprintf("%s: %v is greater than %v", path, n, *s.Maximum))
+		}
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, r := range s.Required {
+			if _, ok := v[r]; !ok {
+				problems = append(problems, fmt.Sprintf("%s.%s: missing", path, r))
+			}
+		}
+		var keys []string
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if p, ok := s.Properties[k]; ok {
+				problems = p.check(path+"."+k, v[k], problems)
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, e := range v {
+				problems = s.Items.check(fmt.Sprintf("%s[%d]", path, i), e, problems)
+			}
+		}
+	}
+	return problems
+}
+
+// checkLightsSchema logs a warning for each way a lights response from
+// hostName doesn't match lightsSchema.
+func checkLightsSchema(hostName string, body []byte) {
+	s := &schema{}
+	if err := json.Unmarshal(lightsSchema, s); err != nil {
+		fatal(fmt.Errorf("bad embedded schema: %v", err))
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		deviceLog(hostName).warnf("response is not JSON: %v", err)
+		return
+	}
+	for _, p := range s.check("response", v, nil) {
+		deviceLog(hostName).warnf("schema mismatch: %s", p)
+	}
+}
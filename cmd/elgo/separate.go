@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+)
+
+var separateWrites = flag.Bool("separate-writes", false, "write power, brightness and temperature in separate requests, reading the state back in between, for firmware that misapplies combined changes (see README)")
+
+// deviceWrite writes s to the device at hostName, with -separate-writes
+// one property at a time.
+func deviceWrite(hostName string, s state) (state, error) {
+	if *separateWrites {
+		return writeSeparately(hostName, s)
+	}
+	return writeState(hostName, s)
+}
+
+// writeSeparately writes s as a sequence of requests that each change one
+// property: power, then brightness, then temperature. Requests for
+// properties s doesn't set are skipped. The state is read back after each
+// request but the last, so that the device has settled before the next.
+func writeSeparately(hostName string, s state) (state, error) {
+	power := state{NumberOfLights: s.NumberOfLights}
+	brightness := state{NumberOfLights: s.NumberOfLights}
+	temperature := state{NumberOfLights: s.NumberOfLights}
+	setsBrightness, setsTemperature := false, false
+	for _, l := range s.Lights {
+		power.Lights = append(power.Lights, light{ID: l.ID, On: l.On})
+		brightness.Lights = append(brightness.Lights, light{ID: l.ID, On: l.On, Brightness: l.Brightness})
+		// A color replaces the temperature, so they are written together.
+		temperature.Lights = append(temperature.Lights, light{ID: l.ID, On: l.On, Temperature: l.Temperature, Hue: l.Hue, Saturation: l.Saturation})
+		setsBrightness = setsBrightness || l.Brightness != 0
+		setsTemperature = setsTemperature || l.Temperature != 0 || l.Hue != nil
+	}
+	parts := []state{power}
+	if setsBrightness {
+		parts = append(parts, brightness)
+	}
+	if setsTemperature {
+		parts = append(parts, temperature)
+	}
+
+	var r state
+	for i, part := range parts {
+		var err error
+		if r, err = writeState(hostName, part); err != nil {
+			return state{}, err
+		}
+		if i < len(parts)-1 {
+			back, err := fetchState(hostName)
+			if err != nil {
+				return state{}, err
+			}
+			deviceLog(hostName).tracef("read back: %+v", back.Lights)
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// apiDevice is the JSON representation of a tracked device in the HTTP API.
+type apiDevice struct {
+	Name      string    `json:"name"`
+	Host      string    `json:"host"`
+	MAC       string    `json:"mac,omitempty"`
+	State     state     `json:"state"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Stale     bool      `json:"stale"`
+}
+
+// api serves the daemon's HTTP API from the cached state of its devices.
+type api struct {
+	devices  []*tracked
+	interval time.Duration
+
+	// The daemon is ready while any device has been read within
+	// readyThreshold, or if readyEmpty and there are no devices.
+	readyThreshold time.Duration
+	readyEmpty     bool
+
+	// triggers allows GET /trigger/ actions; see trigger.
+	triggers map[string]bool
+
+	config *liveConfig
+
+	// token, if set, is required for changes and, if authReads, for reads.
+	token     string
+	authReads bool
+
+	// ui serves the dashboard at /. corsOrigin, if set, lets pages from
+	// that origin ("*" for any) use the API.
+	ui         bool
+	corsOrigin string
+
+	events *eventHub
+}
+
+func (a *api) apiDevice(t *tracked) apiDevice {
+	s, updated := t.current()
+	return apiDevice{
+		Name:      t.dev.Instance,
+		Host:      t.dev.HostName,
+		MAC:       t.dev.MAC,
+		State:     s,
+		UpdatedAt: updated,
+		Stale:     time.Since(updated) > 3*a.interval,
+	}
+}
+
+// find returns the device with the instance name or MAC address name.
+func (a *api) find(name string) *tracked {
+	mac := parseMAC(name)
+	for _, t := range a.devices {
+		if t.dev.Instance == name || mac != "" && t.dev.MAC == mac {
+			return t
+		}
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		apiLog.errorf("%v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// ServeHTTP handles:
+//
+//	GET /lights        all devices
+//	GET /lights/NAME   one device
+//	PUT /lights/NAME   write a state (as sent to the device) to one device
+func (a *api) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/lights"), "/")
+	if name == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		var ds []apiDevice
+		for _, t := range a.devices {
+			ds = append(ds, a.apiDevice(t))
+		}
+		writeJSON(w, http.StatusOK, ds)
+		return
+	}
+
+	t := a.find(name)
+	if t == nil {
+		writeError(w, http.StatusNotFound, "no such device: "+name)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s := state{}
+		if err := json.Unmarshal(body, &s); err != nil || len(s.Lights) == 0 {
+			writeError(w, http.StatusBadRequest, "expected a state with at least one light")
+			return
+		}
+		l, err := limitDeviceLight(t.dev.HostName, s.Lights[0])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if _, err := t.set(l); err != nil {
+			writeError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, a.apiDevice(t))
+}
+
+// deviceHealth is the JSON representation of a device's reachability.
+type deviceHealth struct {
+	Name        string     `json:"name"`
+	Host        string     `json:"host"`
+	Reachable   bool       `json:"reachable"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	AgeSeconds  float64    `json:"ageSeconds,omitempty"` // since LastSuccess
+	Error       string     `json:"error,omitempty"`
+}
+
+// healthz reports that the process is up.
+func (a *api) healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyz reports the reachability of each device, failing with 503 once
+// every device has been unreachable for longer than the threshold.
+func (a *api) readyz(w http.ResponseWriter, r *http.Request) {
+	ready := len(a.devices) == 0 && a.readyEmpty
+	ds := []deviceHealth{}
+	for _, t := range a.devices {
+		_, updated := t.current()
+		h := deviceHealth{Name: t.dev.Instance, Host: t.dev.HostName}
+		if err := t.lastError(); err != nil {
+			h.Error = err.Error()
+		}
+		if !updated.IsZero() {
+			h.LastSuccess = &updated
+			h.AgeSeconds = time.Since(updated).Seconds()
+			h.Reachable = h.Error == ""
+			ready = ready || time.Since(updated) <= a.readyThreshold
+		}
+		ds = append(ds, h)
+	}
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, map[string]interface{}{"ready": ready, "devices": ds})
+}
+
+// serveAPI starts serving the HTTP API on addr.
+func serveAPI(addr string, a *api) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/lights", a.guard(false, a))
+	mux.Handle("/lights/", a.guard(false, a))
+	mux.HandleFunc("/healthz", a.healthz)
+	mux.Handle("/readyz", a.guard(false, http.HandlerFunc(a.readyz)))
+	mux.Handle("/v1/events", a.guard(false, a.events))
+	if len(a.triggers) > 0 {
+		mux.Handle("/trigger/", a.guard(true, http.HandlerFunc(a.trigger)))
+	}
+	if a.config != nil {
+		mux.Handle("/reload", a.guard(true, http.HandlerFunc(a.reload)))
+	}
+	if a.ui {
+		mux.HandleFunc("/", a.dashboard)
+	}
+	var h http.Handler = mux
+	if a.corsOrigin != "" {
+		h = cors(a.corsOrigin, h)
+	}
+	// Shutdown waits for connections that haven't sent a request yet, such
+	// as those a client dials ahead of need, so they must time out well
+	// within the -drain window.
+	srv := &http.Server{Addr: addr, Handler: h, ReadHeaderTimeout: time.Second}
+	go func() {
+		apiLog.infof("serving on %s", addr)
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			fatal(err)
+		}
+	}()
+	return srv
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServePutRange(t *testing.T) {
+	sim := testLight(false)
+	tr := &tracked{dev: device{Instance: "Key Light", HostName: fakeDevice(t, sim)}}
+	if err := tr.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	a := &api{devices: []*tracked{tr}}
+	put := func(body string) int {
+		t.Helper()
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/lights/Key%20Light", strings.NewReader(body)))
+		return w.Code
+	}
+
+	for _, body := range []string{
+		`{"lights":[{"on":1,"brightness":150}]}`,
+		`{"lights":[{"on":1,"brightness":50,"temperature":100}]}`,
+		`{"lights":[{"on":1,"brightness":50,"temperature":400}]}`,
+		`{"lights":[{"on":1,"hue":400,"saturation":50}]}`,
+	} {
+		if code := put(body); code != http.StatusBadRequest {
+			t.Errorf("PUT %s: status %d, want %d", body, code, http.StatusBadRequest)
+		}
+	}
+	if l := sim.lightState().Lights[0]; l.On != 0 {
+		t.Errorf("out-of-range PUTs changed the light: %+v", l)
+	}
+
+	if code := put(`{"lights":[{"on":1,"brightness":50,"temperature":200}]}`); code != http.StatusOK {
+		t.Errorf("in-range PUT: status %d", code)
+	}
+	if l := sim.lightState().Lights[0]; l.On != 1 || l.Brightness != 50 || l.Temperature != 200 {
+		t.Errorf("after in-range PUT: %+v", l)
+	}
+
+	*clampRange = true
+	defer func() { *clampRange = false }()
+	if code := put(`{"lights":[{"on":1,"brightness":150}]}`); code != http.StatusOK {
+		t.Errorf("PUT with -clamp: status %d", code)
+	}
+	if l := sim.lightState().Lights[0]; l.Brightness != defaultRange.BrightnessMax {
+		t.Errorf("brightness %d after PUT of 150 with -clamp, want %d", l.Brightness, defaultRange.BrightnessMax)
+	}
+}
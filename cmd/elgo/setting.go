@@ -0,0 +1,220 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var clampRange = flag.Bool("clamp", false, "clamp out-of-range brightness and temperature to the nearest valid value, with a warning, rather than failing")
+var strictRange = flag.Bool("strict-range", false, "fail on out-of-range brightness and temperature, as by default (overrides -clamp, e.g. from the config file)")
+
+// clamping reports whether out-of-range values are clamped rather than
+// refused.
+func clamping() bool {
+	return *clampRange && !*strictRange
+}
+
+// limitValue returns v for the property what of the device at hostName if
+// it is between min and max. Otherwise, with -clamp, it returns the nearer
+// of them, warning that it did, and without, an error.
+func limitValue(hostName, what string, v, min, max int) (int, error) {
+	if v >= min && v <= max {
+		return v, nil
+	}
+	if !clamping() {
+		return 0, fmt.Errorf("%s must be between %d and %d", what, min, max)
+	}
+	c := clamp(v, min, max)
+	deviceLog(hostName).warnf("%s %d is out of range; using %d", what, v, c)
+	return c, nil
+}
+
+// limitFloat is limitValue for properties that needn't be whole, such as
+// hue.
+func limitFloat(hostName, what string, v, min, max float64) (float64, error) {
+	if v >= min && v <= max {
+		return v, nil
+	}
+	if !clamping() {
+		return 0, fmt.Errorf("%s must be between %g and %g", what, min, max)
+	}
+	c := math.Max(min, math.Min(v, max))
+	deviceLog(hostName).warnf("%s %g is out of range; using %g", what, v, c)
+	return c, nil
+}
+
+// limitLight limits a brightness, and a temperature in Kelvin, to the range
+// of the device at hostName, returning a light with the temperature in
+// device units. Zeros leave a property unchanged, so are left alone.
+func limitLight(hostName string, brightness, kelvin int) (light, error) {
+	l := light{}
+	if brightness == 0 && kelvin == 0 {
+		return l, nil
+	}
+	r := deviceRange(hostName)
+	var err error
+	if brightness != 0 {
+		if l.Brightness, err = limitValue(hostName, "brightness", brightness, r.BrightnessMin, r.BrightnessMax); err != nil {
+			return light{}, err
+		}
+	}
+	if kelvin != 0 {
+		min, max := r.kelvin()
+		if kelvin, err = limitValue(hostName, "temperature (in Kelvins)", kelvin, min, max); err != nil {
+			return light{}, err
+		}
+		l.Temperature = r.temperature(kelvin)
+	}
+	return l, nil
+}
+
+// limitDeviceLight limits the brightness and temperature of l, which are in
+// device units as in a state, and its color if it has one, to the ranges of
+// the device at hostName. Zeros leave a property unchanged, so are left
+// alone.
+func limitDeviceLight(hostName string, l light) (light, error) {
+	r := deviceRange(hostName)
+	var err error
+	if l.Brightness != 0 {
+		if l.Brightness, err = limitValue(hostName, "brightness", l.Brightness, r.BrightnessMin, r.BrightnessMax); err != nil {
+			return light{}, err
+		}
+	}
+	if l.Temperature != 0 {
+		if l.Temperature, err = limitValue(hostName, "temperature (in device units)", l.Temperature, r.TemperatureMin, r.TemperatureMax); err != nil {
+			return light{}, err
+		}
+	}
+	if l.Hue != nil {
+		h, err := limitFloat(hostName, "hue", *l.Hue, 0, 360)
+		if err != nil {
+			return light{}, err
+		}
+		l.Hue = &h
+	}
+	if l.Saturation != nil {
+		sat, err := limitFloat(hostName, "saturation", *l.Saturation, 0, 100)
+		if err != nil {
+			return light{}, err
+		}
+		l.Saturation = &sat
+	}
+	return l, nil
+}
+
+// A setting is a flag for a property with a range, such as brightness. It
+// either sets the property ("50"), changes it by an amount ("+10", "-10"),
+// or changes it by a percentage of the range ("+10%", "-10%"), so that
+// percentage steps feel the same across properties with different ranges.
+//
+// The range depends on the device, so values are only checked against it
+// by within.
+type setting struct {
+	name     string
+	min, max int
+
+	n        int
+	relative bool // n is a change rather than a value
+	percent  bool // n is a percentage of max-min
+}
+
+// settingFlag defines a setting flag for a property between min and max.
+func settingFlag(name string, min, max int, usage string) *setting {
+	s := &setting{name: name, min: min, max: max}
+	flag.Var(s, name, usage)
+	return s
+}
+
+func (s *setting) String() string {
+	if s == nil || !s.isSet() {
+		return ""
+	}
+	v := strconv.Itoa(s.n)
+	if s.relative && s.n >= 0 {
+		v = "+" + v
+	}
+	if s.percent {
+		v += "%"
+	}
+	return v
+}
+
+func (s *setting) Set(v string) error {
+	relative := strings.HasPrefix(v, "+") || strings.HasPrefix(v, "-")
+	percent := strings.HasSuffix(v, "%")
+	n, err := strconv.Atoi(strings.TrimSuffix(v, "%"))
+	switch {
+	case err != nil:
+		return fmt.Errorf("must be a number, optionally with + or - and %%")
+	case percent && !relative:
+		return fmt.Errorf("percentages must start with + or -, e.g. +10%%")
+	case percent && (n < -100 || n > 100):
+		return fmt.Errorf("%s change must be between -100%% and +100%%", s.name)
+	}
+	s.n, s.relative, s.percent = n, relative, percent
+	return nil
+}
+
+// within returns s for a property ranging from min to max, or an error if
+// the value set or the change is outside that range.
+func (s setting) within(min, max int) (*setting, error) {
+	s.min, s.max = min, max
+	switch {
+	case s.relative && !s.percent && (s.n < min-max || s.n > max-min):
+		return nil, fmt.Errorf("%s change must be between -%d and +%d", s.name, max-min, max-min)
+	case !s.relative && s.n != 0 && (s.n < min || s.n > max):
+		return nil, fmt.Errorf("%s must be between %d and %d", s.name, min, max)
+	}
+	return &s, nil
+}
+
+// limit is within for the device at hostName, but with -clamp, clamps an
+// out-of-range value or change instead of failing.
+func (s setting) limit(hostName string, min, max int) (*setting, error) {
+	if !clamping() {
+		return s.within(min, max)
+	}
+	s.min, s.max = min, max
+	switch {
+	case s.relative && !s.percent:
+		s.n, _ = limitValue(hostName, s.name+" change", s.n, min-max, max-min)
+	case !s.relative && s.n != 0:
+		s.n, _ = limitValue(hostName, s.name, s.n, min, max)
+	}
+	return &s, nil
+}
+
+func (s *setting) isSet() bool {
+	return s.n != 0 || s.relative
+}
+
+// absolute returns the value set, or 0 if the setting is unset or relative.
+func (s *setting) absolute() int {
+	if s.relative {
+		return 0
+	}
+	return s.n
+}
+
+// apply returns the new value of the property, given its current value.
+// Changes are clamped to the range.
+func (s *setting) apply(cur int) int {
+	switch {
+	case !s.relative:
+		return s.n
+	case s.percent:
+		return stepPercent(cur, s.n, s.min, s.max)
+	default:
+		return clamp(cur+s.n, s.min, s.max)
+	}
+}
+
+// stepPercent moves cur by pct percent of the range from min to max,
+// clamping the result to the range.
+func stepPercent(cur, pct, min, max int) int {
+	step := math.Round(float64(pct) * float64(max-min) / 100)
+	return clamp(cur+int(step), min, max)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestClamp(t *testing.T) {
+	for _, tt := range []struct{ v, min, max, want int }{
+		{50, 1, 100, 50},
+		{0, 1, 100, 1},
+		{1, 1, 100, 1},
+		{100, 1, 100, 100},
+		{150, 1, 100, 100},
+		{-20, 1, 100, 1},
+		{5, 5, 5, 5},
+	} {
+		if got := clamp(tt.v, tt.min, tt.max); got != tt.want {
+			t.Errorf("clamp(%d, %d, %d) = %d, want %d", tt.v, tt.min, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestStepPercent(t *testing.T) {
+	for _, tt := range []struct{ cur, pct, min, max, want int }{
+		{50, 10, 1, 100, 60}, // 9.9 rounds to 10
+		{50, -10, 1, 100, 40},
+		{95, 10, 1, 100, 100},
+		{5, -10, 1, 100, 1},
+		{4700, 10, 2900, 7000, 5110},
+		{4700, -10, 2900, 7000, 4290},
+		{6900, 10, 2900, 7000, 7000},
+		{2900, 100, 2900, 7000, 7000},
+		{7000, -100, 2900, 7000, 2900},
+		{50, 0, 1, 100, 50},
+		{3, 1, 1, 5, 3}, // 0.04 of a step is nothing
+		{3, 50, 1, 5, 5},
+	} {
+		if got := stepPercent(tt.cur, tt.pct, tt.min, tt.max); got != tt.want {
+			t.Errorf("stepPercent(%d, %d%%, %d, %d) = %d, want %d", tt.cur, tt.pct, tt.min, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestSettingApply(t *testing.T) {
+	for _, tt := range []struct {
+		flag     string
+		min, max int
+		cur      int
+		want     int
+	}{
+		{"50", 1, 100, 20, 50},
+		{"+10", 1, 100, 20, 30},
+		{"-10", 1, 100, 5, 1},
+		{"+10%", 1, 100, 20, 30},
+		{"-500", 2900, 7000, 4700, 4200},
+		{"+10%", 2900, 7000, 4700, 5110},
+		{"+100%", 2900, 7000, 4700, 7000},
+	} {
+		s := &setting{name: "test"}
+		if err := s.Set(tt.flag); err != nil {
+			t.Errorf("Set(%q): %v", tt.flag, err)
+			continue
+		}
+		if s.String() != tt.flag {
+			t.Errorf("Set(%q).String() = %q", tt.flag, s.String())
+		}
+		s, err := s.within(tt.min, tt.max)
+		if err != nil {
+			t.Errorf("%q within %d..%d: %v", tt.flag, tt.min, tt.max, err)
+			continue
+		}
+		if got := s.apply(tt.cur); got != tt.want {
+			t.Errorf("%q applied to %d = %d, want %d", tt.flag, tt.cur, got, tt.want)
+		}
+	}
+}
+
+func TestSettingErrors(t *testing.T) {
+	for _, v := range []string{"", "x", "10%", "+101%", "-200%", "+5.5"} {
+		if err := new(setting).Set(v); err == nil {
+			t.Errorf("Set(%q) accepted", v)
+		}
+	}
+	for _, tt := range []struct {
+		flag     string
+		min, max int
+	}{
+		{"150", 1, 100},
+		{"+100", 1, 100},
+		{"2000", 2900, 7000},
+		{"-5000", 2900, 7000},
+	} {
+		s := &setting{name: "test"}
+		if err := s.Set(tt.flag); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.within(tt.min, tt.max); err == nil {
+			t.Errorf("%q accepted within %d..%d", tt.flag, tt.min, tt.max)
+		}
+	}
+}
+
+func TestLimitLight(t *testing.T) {
+	sim := testLight(false)
+	hostName := fakeDevice(t, sim.handler(simDevice{name: "Sim", model: "Elgato Key Light", firmware: "1.0.3"}))
+	for _, tt := range []struct {
+		b, k  int
+		clamp bool
+		want  light
+		ok    bool
+	}{
+		{0, 0, false, light{}, true},
+		{50, 4000, false, light{Brightness: 50, Temperature: fromKelvin(4000)}, true},
+		{150, 0, false, light{}, false},
+		{0, 2000, false, light{}, false},
+		{150, 0, true, light{Brightness: 100}, true},
+		{50, 9000, true, light{Brightness: 50, Temperature: defaultRange.TemperatureMin}, true},
+		{50, 2000, true, light{Brightness: 50, Temperature: defaultRange.TemperatureMax}, true},
+	} {
+		*clampRange = tt.clamp
+		l, err := limitLight(hostName, tt.b, tt.k)
+		if (err == nil) != tt.ok || l.Brightness != tt.want.Brightness || l.Temperature != tt.want.Temperature {
+			t.Errorf("limitLight(%d, %dK) with -clamp=%v = %+v, %v", tt.b, tt.k, tt.clamp, l, err)
+		}
+	}
+	*clampRange = false
+}
+
+func TestLimitFloat(t *testing.T) {
+	defer func() { *clampRange = false }()
+	if _, err := limitFloat("test", "hue", 400, 0, 360); err == nil {
+		t.Error("hue 400 accepted without -clamp")
+	}
+	*clampRange = true
+	for _, tt := range []struct{ v, want float64 }{{180.5, 180.5}, {400, 360}, {-10, 0}} {
+		if got, err := limitFloat("test", "hue", tt.v, 0, 360); err != nil || got != tt.want {
+			t.Errorf("limitFloat(%g) with -clamp = %g, %v, want %g", tt.v, got, err, tt.want)
+		}
+	}
+	*strictRange = true
+	defer func() { *strictRange = false }()
+	if _, err := limitFloat("test", "hue", 400, 0, 360); err == nil {
+		t.Error("hue 400 accepted with -clamp -strict-range")
+	}
+}
+
+func TestLimitValueQuiet(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	*clampRange = true
+	defer func() { *clampRange, *quiet = false, false }()
+
+	for _, q := range []bool{false, true} {
+		*quiet = q
+		logged.Reset()
+		if v, err := limitValue("test", "brightness", 150, 1, 100); err != nil || v != 100 {
+			t.Errorf("limitValue(150) with -clamp = %d, %v", v, err)
+		}
+		if warned := strings.Contains(logged.String(), "out of range"); warned == q {
+			t.Errorf("with -q=%v: logged %q", q, logged.String())
+		}
+	}
+}
+
+// The ends of the Kelvin range convert to just past the device's range, so
+// what is sent must be clamped to it.
+func TestActKelvinEnds(t *testing.T) {
+	sim := testLight(false)
+	hostName := fakeDevice(t, sim.handler(simDevice{name: "Sim", model: "Elgato Key Light", firmware: "1.0.3"}))
+	defer temperature.Set("0")
+	for _, tt := range []struct {
+		set  string
+		want int
+	}{
+		{"7000", defaultRange.TemperatureMin},
+		{"2900", defaultRange.TemperatureMax},
+		{"-200", defaultRange.TemperatureMax}, // from 2900K
+		{"4000", fromKelvin(4000)},
+		{"+3000", defaultRange.TemperatureMin}, // from 4000K
+	} {
+		if err := temperature.Set(tt.set); err != nil {
+			t.Fatal(err)
+		}
+		act(hostName, "on")
+		if got := sim.lightState().Lights[0].Temperature; got != tt.want {
+			t.Errorf("-temperature %s: device temperature %d, want %d", tt.set, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vsekhar/elgo"
+)
+
+const settingsEndpoint = "lights/settings"
+
+// Values of the powerOnBehavior setting.
+const (
+	powerOnRestore  = 1 // restore the state before power was lost
+	powerOnSettings = 2 // use powerOnBrightness and powerOnTemperature
+)
+
+// maxDurationMs is the longest transition settings accepts.
+const maxDurationMs = 10000
+
+// deviceSettings holds a device's settings as reported, so that fields elgo
+// doesn't know, of any type, are written back unchanged.
+type deviceSettings map[string]json.RawMessage
+
+func fetchSettings(hostName string) (deviceSettings, error) {
+	b, err := request(http.MethodGet, deviceURL(hostName, settingsEndpoint), nil)
+	if err != nil {
+		return nil, err
+	}
+	var s deviceSettings
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, badJSON(hostName, "settings", b, err)
+	}
+	return s, nil
+}
+
+// isNumber reports whether the setting k is a number, the only kind elgo
+// changes.
+func (s deviceSettings) isNumber(k string) bool {
+	_, err := strconv.ParseFloat(string(s[k]), 64)
+	return err == nil
+}
+
+// describe returns the setting k for display, in Kelvin for temperatures.
+func (s deviceSettings) describe(k string) string {
+	v := string(s[k])
+	n, err := strconv.Atoi(v)
+	switch {
+	case err != nil:
+	case k == "powerOnTemperature" && n > 0:
+		return fmt.Sprintf("%dK", toKelvin(n))
+	case k == "powerOnBehavior" && n == powerOnRestore:
+		return v + " (restore last state)"
+	case k == "powerOnBehavior" && n == powerOnSettings:
+		return v + " (use powerOnBrightness and powerOnTemperature)"
+	}
+	return v
+}
+
+// set parses and validates a NAME=VALUE assignment for the device at
+// hostName and applies it to s. Temperatures are given in Kelvin.
+func (s deviceSettings) set(hostName, assignment string) error {
+	i := strings.Index(assignment, "=")
+	if i < 0 {
+		return fmt.Errorf("%q: want NAME=VALUE", assignment)
+	}
+	k, v := assignment[:i], assignment[i+1:]
+	if _, ok := s[k]; !ok {
+		return fmt.Errorf("%s: device has no such setting", k)
+	}
+	if !s.isNumber(k) {
+		return fmt.Errorf("%s: only numeric settings can be changed", k)
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("%s: %q is not a number", k, v)
+	}
+	r := deviceRange(hostName)
+	switch k {
+	case "powerOnBehavior":
+		if n != powerOnRestore && n != powerOnSettings {
+			return fmt.Errorf("%s must be %d (restore last state) or %d (use powerOnBrightness and powerOnTemperature)", k, powerOnRestore, powerOnSettings)
+		}
+	case "powerOnBrightness":
+		if n, err = limitValue(hostName, k, n, r.BrightnessMin, r.BrightnessMax); err != nil {
+			return fmt.Errorf("%w: %v", elgo.ErrInvalidBrightness, err)
+		}
+	case "powerOnTemperature":
+		min, max := r.kelvin()
+		if n, err = limitValue(hostName, k, n, min, max); err != nil {
+			return fmt.Errorf("%w: %s must be between %dK and %dK", elgo.ErrInvalidTemperature, k, min, max)
+		}
+		n = fromKelvin(n)
+	default:
+		if strings.HasSuffix(k, "DurationMs") && (n < 0 || n > maxDurationMs) {
+			return fmt.Errorf("%s must be between 0 and %d", k, maxDurationMs)
+		}
+	}
+	s[k] = json.RawMessage(strconv.Itoa(n))
+	return nil
+}
+
+// settings prints a device's settings, or with "set NAME=VALUE...", changes
+// them.
+func settings(args []string) {
+	fs := flag.NewFlagSet("settings", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() > 0 && (fs.Arg(0) != "set" || fs.NArg() == 1) {
+		fatal(errors.New("usage: elgo settings [set NAME=VALUE...]"))
+	}
+
+	hostName := resolveHost()
+	s, err := fetchSettings(hostName)
+	if err != nil {
+		fatal(err)
+	}
+	if fs.NArg() > 0 {
+		for _, a := range fs.Args()[1:] {
+			if err := s.set(hostName, a); err != nil {
+				fatal(err)
+			}
+		}
+		body, err := json.Marshal(s)
+		if err != nil {
+			fatal(err)
+		}
+		if _, err := request(http.MethodPut, deviceURL(hostName, settingsEndpoint), body); err != nil {
+			fatal(err)
+		}
+		if s, err = fetchSettings(hostName); err != nil {
+			fatal(err)
+		}
+	}
+	var keys []string
+	for k := range s {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Printf("%s: %s\n", k, s.describe(k))
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestSettingsKeepUnknownFields(t *testing.T) {
+	hostName := fakeDevice(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"powerOnBehavior":1,"powerOnBrightness":20,"powerOnTemperature":213,` +
+			`"switchOnDurationMs":100,"mode":"studio","schedule":{"days":[1,2]},"gain":0.5,"label":null}`))
+	}))
+	s, err := fetchSettings(hostName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range []string{"powerOnBrightness=50", "powerOnTemperature=5000"} {
+		if err := s.set(hostName, a); err != nil {
+			t.Errorf("set %s: %v", a, err)
+		}
+	}
+	for _, a := range []string{"mode=1", "schedule=1", "label=1", "nope=1", "powerOnBrightness=x"} {
+		if err := s.set(hostName, a); err == nil {
+			t.Errorf("set %s accepted", a)
+		}
+	}
+	if got := s.describe("powerOnTemperature"); got != "5000K" {
+		t.Errorf("powerOnTemperature is %s, want 5000K", got)
+	}
+	if got := s.describe("mode"); got != `"studio"` {
+		t.Errorf("mode is %s", got)
+	}
+
+	body, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, want map[string]interface{}
+	json.Unmarshal(body, &got)
+	json.Unmarshal([]byte(`{"powerOnBehavior":1,"powerOnBrightness":50,"powerOnTemperature":200,`+
+		`"switchOnDurationMs":100,"mode":"studio","schedule":{"days":[1,2]},"gain":0.5,"label":null}`), &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("written back as %s", body)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// stopping is closed when a long-running mode starts shutting down, so that
+// its loops stop and no new device requests start.
+var stopping = make(chan struct{})
+
+var errStopping = errors.New("shutting down")
+
+// inflight counts device requests in progress, so that shutdown can let them
+// finish.
+var inflight int32
+
+// interrupted is done on the first SIGINT or SIGTERM, unless the command
+// handles them itself (see ownSignals), so that discovery, device requests
+// and loops stop and cleanup such as restoring lights runs before elgo
+// exits with exitInterrupted.
+var interrupted, interrupt = context.WithCancel(context.Background())
+
+var errInterrupted = errors.New("interrupted")
+
+// interrupts receives the signals handled by handleInterrupts.
+var interrupts = make(chan os.Signal, 2)
+
+// handleInterrupts makes the first SIGINT or SIGTERM cancel interrupted
+// and a second exit at once.
+func handleInterrupts() {
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupts
+		cmdLog.warnf("interrupted (signal again to force)")
+		interrupt()
+		<-interrupts
+		os.Exit(exitInterrupted)
+	}()
+}
+
+// ownSignals stops handleInterrupts handling SIGINT and SIGTERM, for
+// commands that handle them themselves.
+func ownSignals() {
+	signal.Stop(interrupts)
+}
+
+// cleanups counts cleanups in progress, whose device requests go ahead
+// even once interrupted.
+var cleanups int32
+
+// cleanup runs f, such as a restore of lights, letting its device requests
+// go ahead even once interrupted.
+func cleanup(f func()) {
+	atomic.AddInt32(&cleanups, 1)
+	defer atomic.AddInt32(&cleanups, -1)
+	f()
+}
+
+// requestContext returns the context device requests are made in: one
+// done once interrupted, except during cleanup.
+func requestContext() context.Context {
+	if atomic.LoadInt32(&cleanups) > 0 {
+		return context.Background()
+	}
+	return interrupted
+}
+
+// sleepOrStop sleeps for d and reports whether to carry on, returning false
+// early if shutdown starts or elgo is interrupted.
+func sleepOrStop(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-stopping:
+		return false
+	case <-requestContext().Done():
+		return false
+	}
+}
+
+// awaitShutdown blocks until SIGINT or SIGTERM, then stops loops, runs
+// cleanup and waits up to drain for in-flight device requests to finish
+// before returning. A second signal exits immediately.
+func awaitShutdown(drain time.Duration, cleanup ...func(ctx context.Context)) {
+	ownSignals()
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	s := <-sig
+	cmdLog.infof("%s: shutting down (signal again to force)", s)
+	go func() {
+		<-sig
+		cmdLog.warnf("forced exit")
+		os.Exit(1)
+	}()
+	close(stopping)
+
+	ctx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	for _, f := range cleanup {
+		f(ctx)
+	}
+	for atomic.LoadInt32(&inflight) > 0 {
+		select {
+		case <-ctx.Done():
+			cmdLog.warnf("drain timeout (%s) with %d requests in flight", drain, atomic.LoadInt32(&inflight))
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
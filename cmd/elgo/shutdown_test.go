@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// freeAddr returns a local address that nothing is listening on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// waitHealthy waits for the daemon API at addr to answer /healthz.
+func waitHealthy(t *testing.T, addr string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := http.Get("http://" + addr + "/healthz")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("daemon at %s never became healthy", addr)
+}
+
+func TestDaemonDrainsOnSIGTERM(t *testing.T) {
+	bin := buildElgo(t)
+	sim := testLight(false)
+	sim.latency = 500 * time.Millisecond
+	hostName := fakeDevice(t, sim.handler(simDevice{name: "Sim", model: "Elgato Key Light", firmware: "1.0.3"}))
+	listen := freeAddr(t)
+	const drain = 3 * time.Second
+
+	var stderr bytes.Buffer
+	daemon := exec.Command(bin, "-host", hostName, "daemon", "-listen", listen, "-history=false", "-interval", "1h", "-drain", drain.String())
+	daemon.Stderr = &stderr
+	if err := daemon.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- daemon.Wait() }()
+	defer func() {
+		daemon.Process.Kill()
+		t.Logf("daemon: %s", stderr.String())
+	}()
+	waitHealthy(t, listen)
+
+	// A write that is still waiting on the device when SIGTERM arrives
+	// should finish.
+	written := make(chan int, 1)
+	go func() {
+		body := strings.NewReader(`{"numberOfLights":1,"lights":[{"on":1}]}`)
+		req, _ := http.NewRequest(http.MethodPut, "http://"+listen+"/lights/"+url.PathEscape(hostName), body)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("PUT during shutdown: %v", err)
+			written <- 0
+			return
+		}
+		resp.Body.Close()
+		written <- resp.StatusCode
+	}()
+	time.Sleep(200 * time.Millisecond)
+	sigtermAt := time.Now()
+	if err := daemon.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Errorf("daemon exited with %v, want status 0", err)
+		}
+		if d := time.Since(sigtermAt); d > drain {
+			t.Errorf("daemon took %s to exit, more than -drain %s", d, drain)
+		}
+	case <-time.After(drain + 2*time.Second):
+		t.Fatal("daemon still running after the drain window")
+	}
+	if code := <-written; code != http.StatusOK {
+		t.Errorf("in-flight PUT answered %d, want 200", code)
+	}
+	if s := sim.lightState(); s.Lights[0].On != 1 {
+		t.Errorf("in-flight write didn't reach the device: %+v", s)
+	}
+	if _, err := http.Get("http://" + listen + "/healthz"); err == nil {
+		t.Error("API still accepting requests after shutdown")
+	}
+}
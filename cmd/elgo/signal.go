@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+)
+
+// A signalStep holds the light lit (the opposite of how it was) or not for
+// a time.
+type signalStep struct {
+	lit bool
+	d   time.Duration
+}
+
+// parsePattern returns the steps that show pattern, of dots and dashes for
+// short and long blinks, gap apart, and spaces for pauses of three gaps.
+func parsePattern(pattern string, dot, dash, gap time.Duration) ([]signalStep, error) {
+	var steps []signalStep
+	for _, c := range pattern {
+		switch c {
+		case '.':
+			steps = append(steps, signalStep{true, dot}, signalStep{false, gap})
+		case '-':
+			steps = append(steps, signalStep{true, dash}, signalStep{false, gap})
+		case ' ':
+			steps = append(steps, signalStep{false, 2 * gap})
+		default:
+			return nil, fmt.Errorf("%q is not ., - or a space", c)
+		}
+	}
+	return steps, nil
+}
+
+// signalCode blinks the light of a device in a pattern, such as "..-" for
+// a failed build, then restores exactly the state it was in before, even
+// if interrupted. A light that is on blinks off.
+func signalCode(args []string) {
+	fs := flag.NewFlagSet("signal", flag.ExitOnError)
+	pattern := fs.String("pattern", "", "blinks to show: . for short, - for long and a space for a pause")
+	dot := fs.Duration("dot", 200*time.Millisecond, "length of a short blink")
+	dash := fs.Duration("dash", 600*time.Millisecond, "length of a long blink")
+	gap := fs.Duration("gap", 200*time.Millisecond, "time between blinks (a pause is three)")
+	repeat := fs.Int("repeat", 1, "number of times to show the pattern, a pause apart")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fatal(errors.New("usage: elgo signal -pattern P [-dot D] [-dash D] [-gap D] [-repeat N]"))
+	}
+	if *pattern == "" {
+		fatal(errors.New("signal needs -pattern"))
+	}
+	if *dot <= 0 || *dash <= 0 || *gap <= 0 {
+		fatal(errors.New("-dot, -dash and -gap must be positive"))
+	}
+	if *repeat < 1 {
+		fatal(errors.New("-repeat must be at least 1"))
+	}
+	once, err := parsePattern(*pattern, *dot, *dash, *gap)
+	if err != nil {
+		fatal(fmt.Errorf("bad -pattern: %v", err))
+	}
+	var steps []signalStep
+	var total time.Duration
+	for i := 0; i < *repeat; i++ {
+		if i > 0 {
+			steps = append(steps, signalStep{false, 2 * *gap})
+		}
+		steps = append(steps, once...)
+	}
+	for len(steps) > 0 && !steps[len(steps)-1].lit {
+		steps = steps[:len(steps)-1]
+	}
+	for _, s := range steps {
+		total += s.d
+	}
+
+	hostName := resolveHost()
+	longRunning = true
+	from, err := fetchState(hostName)
+	if err != nil {
+		fatal(err)
+	}
+	found := []device{{Instance: hostName, HostName: hostName}}
+	saved := snapshot{found[0].key(): from}
+	lit := state{NumberOfLights: len(from.Lights)}
+	for _, l := range from.Lights {
+		lit.Lights = append(lit.Lights, light{ID: l.ID, On: 1 - l.On})
+	}
+
+	ctx, cancel := rampContext(time.Now().Add(total + *requestTimeoutFlag))
+	defer cancel()
+	wasLit := false
+	for _, s := range steps {
+		// Time each step from before its write, so that slow writes don't
+		// stretch the pattern.
+		t := time.NewTimer(s.d)
+		if s.lit != wasLit {
+			next := from
+			if s.lit {
+				next = lit
+			}
+			if _, err := sendState(hostName, next); err != nil {
+				t.Stop()
+				saved.restore(found)
+				fatal(err)
+			}
+			wasLit = s.lit
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			saved.restore(found)
+			return
+		}
+	}
+	saved.restore(found)
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oleksandr/bonjour"
+)
+
+// simulator is a fake light serving the device API from in-memory state.
+type simulator struct {
+	latency  time.Duration
+	failRate float64
+	busyRate float64
+	bounds   lightRange // values outside are ignored, as real devices do
+
+	mu    sync.Mutex
+	state state
+}
+
+func (sim *simulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	requestLog.infof("%s %s %s %s", r.RemoteAddr, r.Method, r.URL.Path, body)
+	time.Sleep(sim.latency)
+	if sim.failRate > 0 && rand.Float64() < sim.failRate {
+		requestLog.infof("injecting failure")
+		http.Error(w, "simulated failure", http.StatusInternalServerError)
+		return
+	}
+	if sim.busyRate > 0 && rand.Float64() < sim.busyRate {
+		requestLog.infof("injecting busy response")
+		http.Error(w, "simulated busy device", http.StatusConflict)
+		return
+	}
+
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		s := state{}
+		if err := json.Unmarshal(body, &s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for i, l := range s.Lights {
+			if i >= len(sim.state.Lights) {
+				break
+			}
+			cur := &sim.state.Lights[i]
+			cur.On = l.On
+			if l.Brightness >= sim.bounds.BrightnessMin && l.Brightness <= sim.bounds.BrightnessMax {
+				cur.Brightness = l.Brightness
+			}
+			if l.Temperature >= sim.bounds.TemperatureMin && l.Temperature <= sim.bounds.TemperatureMax {
+				cur.Temperature = l.Temperature
+			}
+			// Devices with color show a white when sent a temperature,
+			// and a color, reporting no temperature, when sent a hue.
+			if hasColor(*cur) && l.Temperature == 0 && (l.Hue != nil || l.Saturation != nil) {
+				if l.Hue != nil && *l.Hue >= 0 && *l.Hue <= 360 {
+					cur.Hue = l.Hue
+				}
+				if l.Saturation != nil && *l.Saturation >= 0 && *l.Saturation <= 100 {
+					cur.Saturation = l.Saturation
+				}
+				cur.Temperature = 0
+			}
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sim.state)
+}
+
+// simDevice is what a simulator reports about itself besides its lights.
+type simDevice struct {
+	name, model, firmware string
+	bounds                lightRange // reported in accessory-info; zero for none
+	wifi                  *wifiInfo  // nil for none
+	identify              bool       // whether to serve the identify call
+	battery               float64    // battery level in percent; 0 for no battery
+}
+
+// handler returns the device API of sim, as device d.
+func (sim *simulator) handler(d simDevice) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle(fmt.Sprintf(*pathTemplate, lightsEndpoint), sim)
+	settings := map[string]int{
+		"powerOnBehavior":       powerOnRestore,
+		"powerOnBrightness":     20,
+		"powerOnTemperature":    fromKelvin(4700),
+		"switchOnDurationMs":    100,
+		"switchOffDurationMs":   300,
+		"colorChangeDurationMs": 100,
+	}
+	mux.HandleFunc(fmt.Sprintf(*pathTemplate, settingsEndpoint), func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requestLog.infof("%s %s %s %s", r.RemoteAddr, r.Method, r.URL.Path, body)
+		sim.mu.Lock()
+		defer sim.mu.Unlock()
+		if r.Method == http.MethodPut {
+			var changes map[string]int
+			if err := json.Unmarshal(body, &changes); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			for k, v := range changes {
+				if _, ok := settings[k]; ok {
+					settings[k] = v
+				}
+			}
+		}
+		writeJSON(w, http.StatusOK, settings)
+	})
+	displayName := d.name
+	mux.HandleFunc(fmt.Sprintf(*pathTemplate, accessoryInfoEndpoint), func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requestLog.infof("%s %s %s %s", r.RemoteAddr, r.Method, r.URL.Path, body)
+		sim.mu.Lock()
+		defer sim.mu.Unlock()
+		if r.Method == http.MethodPut {
+			var info accessoryInfo
+			if err := json.Unmarshal(body, &info); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if info.DisplayName != "" {
+				displayName = info.DisplayName
+			}
+		}
+		writeJSON(w, http.StatusOK, accessoryInfo{
+			ProductName:         d.model,
+			FirmwareBuildNumber: 218,
+			FirmwareVersion:     d.firmware,
+			SerialNumber:        strings.Replace(fakeMAC(d.name), ":", "", -1),
+			DisplayName:         displayName,
+			WifiInfo:            d.wifi,
+			lightRange:          d.bounds,
+		})
+	})
+	if d.identify {
+		mux.HandleFunc(fmt.Sprintf(*pathTemplate, identifyEndpoint), func(w http.ResponseWriter, r *http.Request) {
+			requestLog.infof("%s %s %s", r.RemoteAddr, r.Method, r.URL.Path)
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			requestLog.infof("identifying")
+		})
+	}
+	if d.battery > 0 {
+		mux.HandleFunc(fmt.Sprintf(*pathTemplate, batteryInfoEndpoint), func(w http.ResponseWriter, r *http.Request) {
+			requestLog.infof("%s %s %s", r.RemoteAddr, r.Method, r.URL.Path)
+			writeJSON(w, http.StatusOK, batteryInfo{
+				PowerSource: powerSourceBattery,
+				Level:       d.battery,
+			})
+		})
+		batterySettings := map[string]interface{}{
+			"bypass": 0,
+			"energySaving": map[string]interface{}{
+				"enable":              0,
+				"minimumBatteryLevel": 15.0,
+				"disableWifi":         0,
+				"adjustBrightness": map[string]interface{}{
+					"enable":     0,
+					"brightness": 10.0,
+				},
+			},
+		}
+		mux.HandleFunc(fmt.Sprintf(*pathTemplate, batterySettingsEndpoint), func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			requestLog.infof("%s %s %s %s", r.RemoteAddr, r.Method, r.URL.Path, body)
+			sim.mu.Lock()
+			defer sim.mu.Unlock()
+			if r.Method == http.MethodPut {
+				var s map[string]interface{}
+				if err := json.Unmarshal(body, &s); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				batterySettings = s
+			}
+			writeJSON(w, http.StatusOK, batterySettings)
+		})
+	}
+	return mux
+}
+
+// fakeMAC returns a stable, locally administered MAC address for name.
+func fakeMAC(name string) string {
+	h := sha256.Sum256([]byte(name))
+	h[0] = h[0]&^1 | 2
+	return fmt.Sprintf("%02X:%02X:%02X:%02X:%02X:%02X", h[0], h[1], h[2], h[3], h[4], h[5])
+}
+
+// simulate serves a fake light and advertises it over mDNS.
+func simulate(args []string) {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	port := fs.Int("port", 9123, "port to serve on")
+	name := fs.String("name", "Elgato Key Light", "mDNS instance name")
+	model := fs.String("model", "Elgato Key Light", "model advertised in the md TXT record")
+	firmware := fs.String("firmware", "1.0.3", "firmware version reported in accessory-info")
+	initial := fs.String("state", "off", "initial state as a preset (brightness and temperature default to 20 and 4700K)")
+	latency := fs.Duration("latency", 0, "delay before each response")
+	failRate := fs.Float64("fail-rate", 0, "fraction of requests (between 0 and 1) to fail with 500")
+	busyRate := fs.Float64("busy-rate", 0, "fraction of requests (between 0 and 1) to answer with 409, as a busy device does")
+	brightnessRange := fs.String("brightness-range", "", "report this brightness range (e.g. 1-100) in accessory-info")
+	temperatureRange := fs.String("temperature-range", "", "report this temperature range in device units (e.g. 143-344) in accessory-info")
+	rssi := fs.Int("rssi", 0, "report this Wi-Fi signal strength in dBm in accessory-info, as recent firmware does (0 for none)")
+	numLights := fs.Int("lights", 1, "number of lights to report, as for a device controlling several")
+	canIdentify := fs.Bool("identify", false, "serve the identify call, as newer firmware does")
+	batteryLevel := fs.Float64("battery", 0, "report this battery level in percent in battery-info, as a Key Light Mini on battery does (0 for no battery)")
+	fs.Parse(args)
+
+	p, err := parsePreset(*initial)
+	if err != nil {
+		fatal(err)
+	}
+	l := light{Brightness: 20, Temperature: fromKelvin(4700)}
+	if p.On {
+		l.On = 1
+	}
+	if p.Brightness != 0 {
+		l.Brightness = p.Brightness
+	}
+	if p.Temperature != 0 {
+		l.Temperature = fromKelvin(p.Temperature)
+	}
+	if strings.Contains(*model, "Light Strip") {
+		h, s := 0.0, 0.0
+		l.Hue, l.Saturation = &h, &s
+	}
+	if *failRate < 0 || *failRate > 1 {
+		fatal(errors.New("-fail-rate must be between 0 and 1"))
+	}
+	if *busyRate < 0 || *busyRate > 1 {
+		fatal(errors.New("-busy-rate must be between 0 and 1"))
+	}
+	if *numLights < 1 {
+		fatal(errors.New("-lights must be at least 1"))
+	}
+	var lr lightRange
+	if *brightnessRange != "" {
+		if lr.BrightnessMin, lr.BrightnessMax, err = parseRange(*brightnessRange); err != nil {
+			fatal(fmt.Errorf("bad -brightness-range: %v", err))
+		}
+	}
+	if *temperatureRange != "" {
+		if lr.TemperatureMin, lr.TemperatureMax, err = parseRange(*temperatureRange); err != nil {
+			fatal(fmt.Errorf("bad -temperature-range: %v", err))
+		}
+	}
+	sim := &simulator{
+		latency:  *latency,
+		failRate: *failRate,
+		busyRate: *busyRate,
+		bounds:   lr.orDefault(),
+		state:    state{NumberOfLights: *numLights},
+	}
+	for i := 0; i < *numLights; i++ {
+		sim.state.Lights = append(sim.state.Lights, l)
+	}
+
+	dev := simDevice{
+		name:     *name,
+		model:    *model,
+		firmware: *firmware,
+		bounds:   lr,
+		identify: *canIdentify,
+		battery:  *batteryLevel,
+	}
+	if *rssi != 0 {
+		dev.wifi = &wifiInfo{SSID: "elgo-sim", FrequencyMHz: 2437, RSSI: *rssi}
+	}
+	mux := sim.handler(dev)
+	go func() {
+		fatal(http.ListenAndServe(":"+strconv.Itoa(*port), mux))
+	}()
+
+	txt := []string{"mf=Elgato", "md=" + *model, "id=" + fakeMAC(*name), "pv=1.0"}
+	srv, err := bonjour.Register(*name, services()[0], "", *port, txt, nil)
+	if err != nil {
+		// Without a routable address, advertise the loopback address so
+		// that at least clients on this host can discover the simulator.
+		hostName, _ := os.Hostname()
+		cmdLog.warnf("advertising on loopback only: %v", err)
+		srv, err = bonjour.RegisterProxy(*name, services()[0], "", *port, hostName, "127.0.0.1", txt, nil)
+		if err != nil {
+			fatal(err)
+		}
+	}
+	cmdLog.infof("simulating %q on port %d", *name, *port)
+
+	ownSignals()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	<-sig
+	srv.Shutdown()
+}
+
+// parseRange parses a range such as 1-100.
+func parseRange(s string) (min, max int, err error) {
+	i := strings.Index(s, "-")
+	if i < 0 {
+		return 0, 0, fmt.Errorf("%q: want MIN-MAX", s)
+	}
+	if min, err = strconv.Atoi(s[:i]); err == nil {
+		max, err = strconv.Atoi(s[i+1:])
+	}
+	if err != nil || min <= 0 || max < min {
+		return 0, 0, fmt.Errorf("%q: want MIN-MAX", s)
+	}
+	return min, max, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// buildElgo builds the elgo command for integration tests.
+func buildElgo(t *testing.T) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("builds elgo")
+	}
+	bin := filepath.Join(t.TempDir(), "elgo")
+	cmd := exec.Command("go", "build", "-o", bin, ".")
+	cmd.Env = userEnv // for the user's build and module caches
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	return bin
+}
+
+// runElgo runs bin with args and returns its stdout and exit status.
+func runElgo(t *testing.T, bin string, args ...string) (string, int) {
+	t.Helper()
+	stdout, stderr, code := runElgoOutput(t, bin, args...)
+	if stderr != "" {
+		t.Logf("elgo %v: %s", args, stderr)
+	}
+	return stdout, code
+}
+
+// runElgoOutput runs bin with args and returns its stdout, stderr and exit
+// status.
+func runElgoOutput(t *testing.T, bin string, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	cmd := exec.Command(bin, args...)
+	var out, errOut bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	err := cmd.Run()
+	if ee, ok := err.(*exec.ExitError); ok {
+		code = ee.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return out.String(), errOut.String(), code
+}
+
+func TestSimulatedDevice(t *testing.T) {
+	bin := buildElgo(t)
+	sim := testLight(false)
+	hostName := fakeDevice(t, sim.handler(simDevice{name: "Sim", model: "Elgato Key Light", firmware: "1.0.3"}))
+
+	if _, code := runElgo(t, bin, "-host", hostName, "toggle"); code != 0 {
+		t.Errorf("toggle on: exit status %d, want 0", code)
+	}
+	if s := sim.lightState(); s.Lights[0].On != 1 {
+		t.Errorf("light off after toggling on: %+v", s)
+	}
+	if _, code := runElgo(t, bin, "-host", hostName, "toggle"); code != exitOff {
+		t.Errorf("toggle off: exit status %d, want %d", code, exitOff)
+	}
+	if _, code := runElgo(t, bin, "-host", hostName, "-brightness", "60", "-temperature", "5000", "on"); code != 0 {
+		t.Errorf("on: exit status %d", code)
+	}
+	if s := sim.lightState(); s.Lights[0].On != 1 || s.Lights[0].Brightness != 60 || toKelvin(s.Lights[0].Temperature) != 5000 {
+		t.Errorf("after on at 60%%, 5000K: %+v", s)
+	}
+
+	out, code := runElgo(t, bin, "-host", hostName, "status", "-json")
+	if code != 0 {
+		t.Fatalf("status: exit status %d", code)
+	}
+	var rows []lightStatus
+	if err := json.Unmarshal([]byte(out), &rows); err != nil {
+		t.Fatalf("status -json: %v: %s", err, out)
+	}
+	if len(rows) != 1 || !rows[0].On || rows[0].Brightness != 60 || rows[0].Kelvin != 5000 {
+		t.Errorf("status -json: %+v", rows)
+	}
+
+	// Values out of the device's range are usage errors.
+	if _, code := runElgo(t, bin, "-host", hostName, "-brightness", "150", "on"); code != 2 {
+		t.Errorf("-brightness 150: exit status %d, want 2", code)
+	}
+}
+
+// Brightness from the config file or environment is a default for when the
+// light is turned on, not a request to adjust it.
+func TestDefaultsDontAdjust(t *testing.T) {
+	bin := buildElgo(t)
+	sim := testLight(false)
+	hostName := fakeDevice(t, sim.handler(simDevice{name: "Sim", model: "Elgato Key Light", firmware: "1.0.3"}))
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(config, []byte("brightness: 40\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, code := runElgo(t, bin, "-config", config, "-host", hostName); code != 0 {
+		t.Errorf("toggle on: exit status %d, want 0", code)
+	}
+	if s := sim.lightState(); s.Lights[0].On != 1 || s.Lights[0].Brightness != 40 {
+		t.Errorf("after toggling on with brightness 40 in the config: %+v", s)
+	}
+
+	os.Setenv("ELGO_BRIGHTNESS", "30")
+	_, code := runElgo(t, bin, "-host", hostName)
+	os.Unsetenv("ELGO_BRIGHTNESS")
+	if code != exitOff {
+		t.Errorf("toggle off: exit status %d, want %d", code, exitOff)
+	}
+	if s := sim.lightState(); s.Lights[0].On != 0 {
+		t.Errorf("still on after toggling with ELGO_BRIGHTNESS set: %+v", s)
+	}
+
+	if _, code := runElgo(t, bin, "-config", config, "-host", hostName, "-brightness", "50"); code != 0 {
+		t.Errorf("adjust: exit status %d, want 0", code)
+	}
+	if s := sim.lightState(); s.Lights[0].On != 0 || s.Lights[0].Brightness != 50 {
+		t.Errorf("after -brightness 50: %+v", s)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+// A snapshot holds the states of devices by key, to restore later.
+type snapshot map[string]state
+
+// capture returns the current states of devices. Devices that can't be read
+// are logged and left out, so they won't be restored.
+func capture(devices []device) snapshot {
+	s := snapshot{}
+	for _, d := range devices {
+		if st, err := fetchState(d.HostName); err != nil {
+			cmdLog.warnf("%s: %v", d.Instance, err)
+		} else {
+			s[d.key()] = st
+		}
+	}
+	return s
+}
+
+// restore writes the captured states back to devices, even once
+// interrupted.
+func (s snapshot) restore(devices []device) {
+	cleanup(func() { s.write(devices) })
+}
+
+func (s snapshot) write(devices []device) {
+	for _, d := range devices {
+		st, ok := s[d.key()]
+		if !ok {
+			continue
+		}
+		if _, err := sendState(d.HostName, st); err != nil {
+			cmdLog.warnf("%s: %v", d.Instance, err)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"time"
+)
+
+var softStart = flag.Duration("soft-start", 0, "when turning a light on, ramp its brightness up from the lowest over this long (e.g. 300ms), for units that flicker when switched straight on to a high brightness")
+
+// rampOn turns on light i of s, which is off, at the lowest brightness and
+// raises it towards target over -soft-start, leaving the final write, at
+// target, to the caller. Interrupted, or at the -timeout, it leaves the
+// light where it got to and exits.
+func rampOn(hostName string, s state, i, target int) {
+	min := deviceRange(hostName).BrightnessMin
+	if target <= min {
+		return
+	}
+	from := state{NumberOfLights: s.NumberOfLights, Lights: append([]light(nil), s.Lights...)}
+	to := state{NumberOfLights: s.NumberOfLights, Lights: append([]light(nil), s.Lights...)}
+	from.Lights[i] = light{ID: s.Lights[i].ID, On: 1, Brightness: min}
+	to.Lights[i] = light{ID: s.Lights[i].ID, On: 1, Brightness: target}
+	deadline := start.Add(*timeout)
+	if longRunning {
+		deadline = time.Now().Add(*softStart + *requestTimeoutFlag)
+	}
+	ctx, cancel := rampContext(deadline)
+	defer cancel()
+	last, err := ramp(ctx, hostName, from, to, *softStart)
+	switch {
+	case ctx.Err() != nil:
+		stoppedAt(hostName, last, ctx.Err())
+	case err == nil, err == errStopping:
+	default:
+		fatal(err)
+	}
+}
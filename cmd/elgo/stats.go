@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// longDuration is a duration flag that also accepts a number of days, e.g.
+// 7d.
+type longDuration time.Duration
+
+func (d *longDuration) String() string { return time.Duration(*d).String() }
+
+func (d *longDuration) Set(s string) error {
+	if n, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil && strings.HasSuffix(s, "d") {
+		*d = longDuration(time.Duration(n) * 24 * time.Hour)
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	*d = longDuration(v)
+	return err
+}
+
+// lightUsage summarizes the use of a device over a period.
+type lightUsage struct {
+	ObservedSeconds   float64 `json:"observedSeconds"`             // time the daemon was watching
+	OnSeconds         float64 `json:"onSeconds"`                   // observed time spent on
+	Toggles           int     `json:"toggles"`                     // of power, on or off
+	AverageBrightness float64 `json:"averageBrightness,omitempty"` // while on, weighted by time
+}
+
+func (u lightUsage) String() string {
+	secs := func(s float64) time.Duration {
+		return (time.Duration(s) * time.Second).Round(time.Minute)
+	}
+	str := fmt.Sprintf("on %s of %s observed, %d toggles", secs(u.OnSeconds), secs(u.ObservedSeconds), u.Toggles)
+	if u.OnSeconds > 0 {
+		str += fmt.Sprintf(", average brightness %.0f%%", u.AverageBrightness)
+	}
+	return str
+}
+
+// deviceStats summarizes the use of a device over a period, and with -per,
+// over each day or week of it.
+type deviceStats struct {
+	Device string `json:"device"`
+	Host   string `json:"host"`
+	MAC    string `json:"mac,omitempty"`
+	lightUsage
+	Periods []periodStats `json:"periods,omitempty"`
+}
+
+// periodStats summarizes the use of a device over the day or week starting
+// at Start.
+type periodStats struct {
+	Start time.Time `json:"start"`
+	lightUsage
+}
+
+// tally accumulates deviceStats from the history of one device.
+type tally struct {
+	deviceStats
+	from, to       time.Time
+	observing      bool
+	on, brightness int
+	last           time.Time // up to which time has been counted
+	lastEntry      time.Time
+	brightnessSecs float64 // brightness times seconds on
+}
+
+// advance counts the time from the last advance to t, clipped to the period.
+func (t *tally) advance(to time.Time) {
+	start, end := t.last, to
+	if start.Before(t.from) {
+		start = t.from
+	}
+	if end.After(t.to) {
+		end = t.to
+	}
+	if t.observing && end.After(start) {
+		secs := end.Sub(start).Seconds()
+		t.ObservedSeconds += secs
+		if t.on != 0 {
+			t.OnSeconds += secs
+			t.brightnessSecs += float64(t.brightness) * secs
+		}
+	}
+	if to.After(t.last) {
+		t.last = to
+	}
+}
+
+func (t *tally) add(e historyEntry) {
+	if e.Field == "observed" && e.Old == 0 && e.New == 1 && t.observing {
+		// The daemon stopped without saying so. The last we know of it is
+		// its last entry.
+		t.advance(t.lastEntry)
+		t.observing = false
+	}
+	t.advance(e.Time)
+	t.lastEntry = e.Time
+	switch e.Field {
+	case "observed":
+		t.observing = e.New == 1
+	case "on":
+		// The daemon also sees changes made from the command line, so only
+		// count those when it isn't running.
+		inPeriod := !e.Time.Before(t.from) && !e.Time.After(t.to)
+		if e.Old != e.New && inPeriod && (e.Source != sourceCLI || !t.observing) {
+			t.Toggles++
+		}
+		t.on = e.New
+	case "brightness":
+		t.brightness = e.New
+	}
+}
+
+// computeStats summarizes entries, in order, over the period from from to
+// to. Only time observed by the daemon (between its "observed" entries) is
+// counted, so time the daemon wasn't running is left out rather than
+// guessed at. A device still observed at the end of the log is counted up
+// to its last entry, which the daemon's heartbeat keeps recent while it
+// runs; if the daemon died, counting up to now would make up the time
+// since.
+func computeStats(entries []historyEntry, from, to time.Time) []deviceStats {
+	tallies := map[string]*tally{}
+	var keys []string
+	for _, e := range entries {
+		// Older entries have no MAC, so they are counted separately.
+		key := device{Instance: e.Device, HostName: e.Host, MAC: e.MAC}.key()
+		t, ok := tallies[key]
+		if !ok {
+			t = &tally{from: from, to: to}
+			tallies[key] = t
+			keys = append(keys, key)
+		}
+		t.Device, t.Host, t.MAC = e.Device, e.Host, e.MAC
+		t.add(e)
+	}
+	var stats []deviceStats
+	for _, key := range keys {
+		t := tallies[key]
+		if t.OnSeconds > 0 {
+			t.AverageBrightness = t.brightnessSecs / t.OnSeconds
+		}
+		stats = append(stats, t.deviceStats)
+	}
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].Device < stats[j].Device })
+	return stats
+}
+
+// periodStart returns the start of the local day, or with days 7 the week
+// starting on Monday, that contains t.
+func periodStart(t time.Time, days int) time.Time {
+	t = t.Local()
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	if days == 7 {
+		start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+	}
+	return start
+}
+
+// addPeriods breaks stats down into periods of days days, the first being
+// the one containing from, each summarized as by computeStats. Periods in
+// which a device wasn't observed are left out.
+func addPeriods(stats []deviceStats, entries []historyEntry, from, to time.Time, days int) {
+	index := map[string]int{}
+	for i, s := range stats {
+		index[device{Instance: s.Device, HostName: s.Host, MAC: s.MAC}.key()] = i
+	}
+	for start := periodStart(from, days); start.Before(to); start = start.AddDate(0, 0, days) {
+		pFrom, pTo := start, start.AddDate(0, 0, days)
+		if pFrom.Before(from) {
+			pFrom = from
+		}
+		if pTo.After(to) {
+			pTo = to
+		}
+		for _, s := range computeStats(entries, pFrom, pTo) {
+			i, ok := index[device{Instance: s.Device, HostName: s.Host, MAC: s.MAC}.key()]
+			if ok && s.ObservedSeconds > 0 {
+				stats[i].Periods = append(stats[i].Periods, periodStats{start, s.lightUsage})
+			}
+		}
+	}
+}
+
+// stats prints how much each device has been used, from the history log
+// kept by the daemon, or with -usage, how each command has been.
+func stats(args []string) {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	since := longDuration(7 * 24 * time.Hour)
+	fs.Var(&since, "since", "summarize this long up to now, e.g. 12h or 7d")
+	deviceName := fs.String("device", "", "only show the device with this name, host or MAC address")
+	asJSON := jsonFlag(fs, "print results as JSON")
+	usage := fs.Bool("usage", false, "show how often each command has been used and how long its requests took, as recorded with -usage-stats, instead")
+	reset := fs.Bool("reset", false, "delete the usage recorded with -usage-stats")
+	per := fs.String("per", "", "also break the summary down per day or week")
+	fs.Parse(args)
+	days := map[string]int{"": 0, "day": 1, "week": 7}[*per]
+	if days == 0 && *per != "" {
+		fatal(fmt.Errorf("-per must be day or week, not %q", *per))
+	}
+	switch {
+	case *reset:
+		resetUsage()
+		return
+	case *usage:
+		printUsage(*asJSON)
+		return
+	}
+
+	var entries []historyEntry
+	if err := readHistory(func(e historyEntry) {
+		if matchDevice(e.event, *deviceName) {
+			entries = append(entries, e)
+		}
+	}); err != nil {
+		fatal(err)
+	}
+	now := time.Now()
+	from := now.Add(-time.Duration(since))
+	results := computeStats(entries, from, now)
+	if days > 0 {
+		addPeriods(results, entries, from, now, days)
+	}
+
+	if *asJSON {
+		if results == nil {
+			results = []deviceStats{}
+		}
+		if err := json.NewEncoder(os.Stdout).Encode(results); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if len(results) == 0 {
+		fmt.Println("no history (run elgo daemon to record it)")
+		return
+	}
+	for _, r := range results {
+		fmt.Printf("%s: %s\n", r.Device, r.lightUsage)
+		for _, p := range r.Periods {
+			label := p.Start.Format("Mon 2006-01-02")
+			if days == 7 {
+				label = "week of " + p.Start.Format("2006-01-02")
+			}
+			fmt.Printf("  %s: %s\n", label, p.lightUsage)
+		}
+	}
+}
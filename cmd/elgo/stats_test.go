@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+type entryAt struct {
+	hours    float64
+	field    string
+	old, new int
+	source   string
+}
+
+// entriesAt returns history entries for one device, each at base plus its
+// offset in hours.
+func entriesAt(base time.Time, entries ...entryAt) []historyEntry {
+	var out []historyEntry
+	for _, e := range entries {
+		at := base.Add(time.Duration(e.hours * float64(time.Hour)))
+		out = append(out, historyEntry{event{at, "Key Light", "key.local", "", e.field, e.old, e.new}, e.source})
+	}
+	return out
+}
+
+func TestComputeStats(t *testing.T) {
+	base := time.Date(2026, 10, 12, 9, 0, 0, 0, time.Local)
+	entries := entriesAt(base,
+		entryAt{0, "observed", 0, 1, sourceDaemon},
+		entryAt{0, "on", 0, 0, sourceDaemon},
+		entryAt{0, "brightness", 40, 40, sourceDaemon},
+		entryAt{1, "on", 0, 1, sourceExternal},
+		entryAt{2, "brightness", 40, 80, sourceDaemon},
+		entryAt{3, "on", 1, 0, sourceCLI}, // the daemon saw it, so not a toggle of its own
+		entryAt{4, "observed", 1, 0, sourceDaemon},
+		// Unobserved: not counted.
+		entryAt{10, "observed", 0, 1, sourceDaemon},
+		entryAt{10, "on", 1, 1, sourceDaemon},
+		entryAt{10, "brightness", 60, 60, sourceDaemon},
+		entryAt{11, "observed", 1, 1, sourceDaemon}, // heartbeat
+		// The daemon died here without a last entry, and was restarted.
+		entryAt{20, "observed", 0, 1, sourceDaemon},
+		entryAt{20, "on", 0, 0, sourceDaemon},
+		entryAt{21, "observed", 1, 1, sourceDaemon},
+		// And died again, leaving the interval open.
+	)
+	stats := computeStats(entries, base, base.Add(48*time.Hour))
+	if len(stats) != 1 {
+		t.Fatalf("stats for %d devices, want 1", len(stats))
+	}
+	got := stats[0]
+	want := lightUsage{
+		ObservedSeconds:   (4 + 1 + 1) * 3600,
+		OnSeconds:         (2 + 1) * 3600,
+		Toggles:           1,
+		AverageBrightness: (40 + 80 + 60) / 3.0,
+	}
+	if got.lightUsage != want {
+		t.Errorf("got %+v, want %+v", got.lightUsage, want)
+	}
+
+	// Only the part of the log within the period counts.
+	stats = computeStats(entries, base.Add(90*time.Minute), base.Add(150*time.Minute))
+	want = lightUsage{ObservedSeconds: 3600, OnSeconds: 3600, AverageBrightness: 60}
+	if stats[0].lightUsage != want {
+		t.Errorf("got %+v, want %+v", stats[0].lightUsage, want)
+	}
+}
+
+func TestStatsPerDay(t *testing.T) {
+	base := time.Date(2026, 10, 12, 22, 0, 0, 0, time.Local) // a Monday
+	entries := entriesAt(base,
+		entryAt{0, "observed", 0, 1, sourceDaemon},
+		entryAt{0, "on", 1, 1, sourceDaemon},
+		entryAt{0, "brightness", 50, 50, sourceDaemon},
+		entryAt{4, "on", 1, 0, sourceExternal},
+		entryAt{5, "observed", 1, 0, sourceDaemon},
+	)
+	from, to := base.Add(-time.Hour), base.Add(48*time.Hour)
+
+	stats := computeStats(entries, from, to)
+	addPeriods(stats, entries, from, to, 1)
+	periods := stats[0].Periods
+	if len(periods) != 2 {
+		t.Fatalf("%d periods, want 2: %+v", len(periods), periods)
+	}
+	for i, want := range []periodStats{
+		{base.Add(-22 * time.Hour), lightUsage{ObservedSeconds: 2 * 3600, OnSeconds: 2 * 3600, AverageBrightness: 50}},
+		{base.Add(2 * time.Hour), lightUsage{ObservedSeconds: 3 * 3600, OnSeconds: 2 * 3600, Toggles: 1, AverageBrightness: 50}},
+	} {
+		if !periods[i].Start.Equal(want.Start) || periods[i].lightUsage != want.lightUsage {
+			t.Errorf("period %d: got %+v, want %+v", i, periods[i], want)
+		}
+	}
+
+	stats = computeStats(entries, from, to)
+	addPeriods(stats, entries, from, to, 7)
+	if p := stats[0].Periods; len(p) != 1 || !p[0].Start.Equal(base.Add(-22*time.Hour)) || p[0].ObservedSeconds != 5*3600 {
+		t.Errorf("per week: %+v", p)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// lightStatus is a row of elgo status: one light of a device.
+type lightStatus struct {
+	Device     string   `json:"device"`
+	Host       string   `json:"host"`
+	MAC        string   `json:"mac,omitempty"`
+	Index      int      `json:"index"` // as for -light-index
+	ID         string   `json:"id,omitempty"`
+	On         bool     `json:"on"`
+	Brightness int      `json:"brightness"`
+	Kelvin     int      `json:"kelvin,omitempty"`
+	Hue        *float64 `json:"hue,omitempty"`
+	Saturation *float64 `json:"saturation,omitempty"`
+	Bypass     *bool    `json:"bypass,omitempty"` // of battery devices, with -json
+	Error      string   `json:"error,omitempty"`  // if the device didn't answer
+}
+
+// newLightStatus returns the row of light l, at index, of d.
+func newLightStatus(d device, index int, l light) lightStatus {
+	r := lightStatus{
+		Device:     d.Instance,
+		Host:       d.HostName,
+		MAC:        d.MAC,
+		Index:      index,
+		ID:         l.ID,
+		On:         l.On != 0,
+		Brightness: l.Brightness,
+		Hue:        l.Hue,
+		Saturation: l.Saturation,
+	}
+	if l.Temperature != 0 {
+		r.Kelvin = toKelvin(l.Temperature)
+	}
+	return r
+}
+
+// statusCommand prints the state of every light of every device found, one light
+// per line, with the index to pass to -light-index.
+func statusCommand(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	asJSON := jsonFlag(fs, "print the lights as JSON")
+	fs.Parse(args)
+
+	var found []device
+	if *count > 0 && *host == "" {
+		found = discoverCount(*count)
+	} else {
+		found = discoverAll()
+	}
+	longRunning = true
+	states := make([]state, len(found))
+	errs := make([]error, len(found))
+	bypass := make([]*bool, len(found))
+	var wg sync.WaitGroup
+	for i, d := range found {
+		wg.Add(1)
+		go func(i int, d device) {
+			defer wg.Done()
+			if states[i], errs[i] = fetchState(d.HostName); errs[i] != nil || !*asJSON || !lookupModel(d.Model).Battery {
+				return
+			}
+			if s, err := fetchBatterySettings(d.HostName); err == nil {
+				if on, ok := s.bypass(); ok {
+					bypass[i] = &on
+				}
+			}
+		}(i, d)
+	}
+	wg.Wait()
+	// Report the others before exiting for a device that didn't answer.
+	defer func() {
+		for _, err := range errs {
+			if err != nil {
+				exit(exitStatus(err))
+			}
+		}
+	}()
+
+	var rows []lightStatus
+	for i, d := range found {
+		if errs[i] != nil {
+			rows = append(rows, lightStatus{Device: d.Instance, Host: d.HostName, MAC: d.MAC, Error: errs[i].Error()})
+			continue
+		}
+		for j, l := range states[i].Lights {
+			r := newLightStatus(d, j, l)
+			r.Bypass = bypass[i]
+			rows = append(rows, r)
+		}
+	}
+	if *asJSON {
+		if rows == nil {
+			rows = []lightStatus{}
+		}
+		if err := json.NewEncoder(os.Stdout).Encode(rows); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	for _, r := range rows {
+		if r.Error != "" {
+			if *quiet {
+				cmdLog.errorf("%s: %s", r.Host, r.Error)
+			}
+			fmt.Printf("%s\t%s\t-\t-\terror: %s\n", r.Device, r.Host, r.Error)
+			continue
+		}
+		id, power, color := "-", "off", "-"
+		if r.ID != "" {
+			id = r.ID
+		}
+		if r.On {
+			power = "on"
+		}
+		switch {
+		case r.Hue != nil && r.Kelvin == 0:
+			color = describeColor(light{Hue: r.Hue, Saturation: r.Saturation})
+		case r.Kelvin != 0:
+			color = strconv.Itoa(r.Kelvin) + "K"
+		}
+		fmt.Printf("%s\t%s\t%d\t%s\t%s\t%d%%\t%s\n", r.Device, r.Host, r.Index, id, power, r.Brightness, color)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// A sceneElement is one color of a scene, which the Light Strip shows for
+// DurationMs after fading to it over TransitionMs.
+type sceneElement struct {
+	Hue          float64 `json:"hue"`
+	Saturation   float64 `json:"saturation"`
+	Brightness   float64 `json:"brightness"`
+	DurationMs   int     `json:"durationMs"`
+	TransitionMs int     `json:"transitionMs"`
+}
+
+// sceneLight is a light showing a scene, in the extended lights payload of
+// the Light Strip.
+type sceneLight struct {
+	On                    int            `json:"on"`
+	ID                    string         `json:"id"`
+	Name                  string         `json:"name"`
+	Brightness            int            `json:"brightness"`
+	NumberOfSceneElements int            `json:"numberOfSceneElements"`
+	Scene                 []sceneElement `json:"scene"`
+}
+
+// sceneIDPrefix prefixes the IDs of scenes applied by elgo.
+const sceneIDPrefix = "com.github.vsekhar.elgo.scene."
+
+// builtinScenes are the scenes elgo provides, cycling through their colors.
+var builtinScenes = map[string][]sceneElement{
+	"sunrise": {
+		{Hue: 5, Saturation: 90, Brightness: 40, DurationMs: 4000, TransitionMs: 4000},
+		{Hue: 25, Saturation: 85, Brightness: 70, DurationMs: 4000, TransitionMs: 4000},
+		{Hue: 45, Saturation: 60, Brightness: 100, DurationMs: 4000, TransitionMs: 4000},
+	},
+	"ocean": {
+		{Hue: 190, Saturation: 90, Brightness: 80, DurationMs: 3000, TransitionMs: 3000},
+		{Hue: 215, Saturation: 95, Brightness: 60, DurationMs: 3000, TransitionMs: 3000},
+		{Hue: 170, Saturation: 70, Brightness: 70, DurationMs: 3000, TransitionMs: 3000},
+	},
+	"forest": {
+		{Hue: 100, Saturation: 80, Brightness: 60, DurationMs: 5000, TransitionMs: 5000},
+		{Hue: 140, Saturation: 70, Brightness: 50, DurationMs: 5000, TransitionMs: 5000},
+	},
+	"rainbow": {
+		{Hue: 0, Saturation: 100, Brightness: 100, DurationMs: 1000, TransitionMs: 1000},
+		{Hue: 60, Saturation: 100, Brightness: 100, DurationMs: 1000, TransitionMs: 1000},
+		{Hue: 120, Saturation: 100, Brightness: 100, DurationMs: 1000, TransitionMs: 1000},
+		{Hue: 180, Saturation: 100, Brightness: 100, DurationMs: 1000, TransitionMs: 1000},
+		{Hue: 240, Saturation: 100, Brightness: 100, DurationMs: 1000, TransitionMs: 1000},
+		{Hue: 300, Saturation: 100, Brightness: 100, DurationMs: 1000, TransitionMs: 1000},
+	},
+}
+
+// loadScenes returns the built-in scenes and those defined in the scenes
+// section of the config file, as lists of colors (see -color) shown as a
+// static gradient:
+//
+//	scenes:
+//	  brand: ["#ff8800", teal, "255,0,128"]
+func loadScenes() (map[string][]sceneElement, error) {
+	scenes := map[string][]sceneElement{}
+	for name, s := range builtinScenes {
+		scenes[name] = s
+	}
+	raw, path, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+	section, ok := raw["scenes"].(map[interface{}]interface{})
+	if raw["scenes"] != nil && !ok {
+		return nil, fmt.Errorf("%s: scenes: want scene names and their colors", path)
+	}
+	for k, v := range section {
+		name := fmt.Sprint(k)
+		colors, ok := v.([]interface{})
+		if !ok || len(colors) == 0 {
+			return nil, fmt.Errorf("%s: scenes: %s: want a list of colors", path, name)
+		}
+		var elements []sceneElement
+		for _, c := range colors {
+			r, g, b, err := parseColor(fmt.Sprint(c))
+			if err != nil {
+				return nil, fmt.Errorf("%s: scenes: %s: %v", path, name, err)
+			}
+			h, s, v := rgbToHSV(r, g, b)
+			elements = append(elements, sceneElement{Hue: math.Round(h), Saturation: math.Round(s), Brightness: math.Round(v)})
+		}
+		scenes[name] = elements
+	}
+	return scenes, nil
+}
+
+// applyScene shows scene on the Light Strip at hostName.
+func applyScene(hostName, name string, elements []sceneElement) error {
+	if m := deviceModel(hostName); !m.Color {
+		return fmt.Errorf("%s: %s does not support scenes (they need a Light Strip)", hostName, m.Name)
+	}
+	s := getState(hostName)
+	if len(s.Lights) == 0 || !hasColor(s.Lights[0]) {
+		return fmt.Errorf("%s: device doesn't support scenes (they need a Light Strip)", hostName)
+	}
+	b, err := json.Marshal(struct {
+		NumberOfLights int          `json:"numberOfLights"`
+		Lights         []sceneLight `json:"lights"`
+	}{1, []sceneLight{{
+		On:                    1,
+		ID:                    sceneIDPrefix + name,
+		Name:                  name,
+		Brightness:            100,
+		NumberOfSceneElements: len(elements),
+		Scene:                 elements,
+	}}})
+	if err != nil {
+		return err
+	}
+	_, err = request(http.MethodPut, deviceURL(hostName, lightsEndpoint), b)
+	return err
+}
+
+// strip controls features of the Light Strip: "strip scene list" lists
+// scenes, and "strip scene apply NAME" shows one.
+func strip(args []string) {
+	fs := flag.NewFlagSet("strip", flag.ExitOnError)
+	fs.Parse(args)
+	const usage = "usage: elgo strip scene list|apply NAME"
+	if fs.NArg() < 2 || fs.Arg(0) != "scene" {
+		fatal(errors.New(usage))
+	}
+	scenes, err := loadScenes()
+	if err != nil {
+		fatal(err)
+	}
+	switch {
+	case fs.Arg(1) == "list" && fs.NArg() == 2:
+		var names []string
+		for name := range scenes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Println(strings.Join(names, "\n"))
+	case fs.Arg(1) == "apply" && fs.NArg() == 3:
+		name := fs.Arg(2)
+		elements, ok := scenes[name]
+		if !ok {
+			fatal(fmt.Errorf("no such scene: %s", name))
+		}
+		if err := applyScene(resolveHost(), name, elements); err != nil {
+			fatal(err)
+		}
+	default:
+		fatal(errors.New(usage))
+	}
+}
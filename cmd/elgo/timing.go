@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var timing = flag.Bool("timing", false, "print how long discovery, each device request and the whole command took, to stderr")
+
+// launched is when elgo started, for the total in the -timing report.
+var launched = time.Now()
+
+// timingJSON is the -json flag of the command being run, if it has one, so
+// that the -timing report can match its output.
+var timingJSON *bool
+
+// jsonFlag defines the -json flag of a command.
+func jsonFlag(fs *flag.FlagSet, usage string) *bool {
+	timingJSON = fs.Bool("json", false, usage)
+	return timingJSON
+}
+
+// timingReport is what -timing prints.
+type timingReport struct {
+	Discovery []discoveryTiming `json:"discovery"`
+	Requests  []requestTiming   `json:"requests"` // each attempt, including retries
+	TotalMs   float64           `json:"totalMs"`
+}
+
+type discoveryTiming struct {
+	Method string  `json:"method"` // mDNS browse, scan or cache
+	Ms     float64 `json:"ms"`
+}
+
+type requestTiming struct {
+	Method      string   `json:"method"`
+	URL         string   `json:"url"`
+	Reused      bool     `json:"reused"`                // connection, so no DNS or connect
+	DNSMs       *float64 `json:"dnsMs,omitempty"`       // nil if there was no lookup
+	ConnectMs   *float64 `json:"connectMs,omitempty"`   // nil if there was no new connection
+	FirstByteMs *float64 `json:"firstByteMs,omitempty"` // nil if there was no response
+	TotalMs     float64  `json:"totalMs"`
+}
+
+var (
+	timingMu sync.Mutex
+	timings  timingReport
+	// timingShown is set once the report is part of a command's output.
+	timingShown bool
+)
+
+// noteDiscovery records, for -timing, that finding devices by method took
+// d.
+func noteDiscovery(method string, d time.Duration) {
+	if !*timing {
+		return
+	}
+	timingMu.Lock()
+	defer timingMu.Unlock()
+	timings.Discovery = append(timings.Discovery, discoveryTiming{method, ms(d)})
+}
+
+// traceTimes collects the phases of one request attempt from httptrace.
+type traceTimes struct {
+	mu                        sync.Mutex
+	dnsStart, dnsDone         time.Time
+	connectStart, connectDone time.Time
+	firstByte                 time.Time
+	reused                    bool
+}
+
+// hook adds callbacks recording into t to ct, keeping its GotConn.
+func (t *traceTimes) hook(ct *httptrace.ClientTrace) {
+	at := func(p *time.Time) func() {
+		return func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if p.IsZero() {
+				*p = time.Now()
+			}
+		}
+	}
+	ct.DNSStart = func(httptrace.DNSStartInfo) { at(&t.dnsStart)() }
+	ct.DNSDone = func(httptrace.DNSDoneInfo) { at(&t.dnsDone)() }
+	ct.ConnectStart = func(string, string) { at(&t.connectStart)() }
+	ct.ConnectDone = func(string, string, error) { at(&t.connectDone)() }
+	ct.GotFirstResponseByte = at(&t.firstByte)
+	got := ct.GotConn
+	ct.GotConn = func(info httptrace.GotConnInfo) {
+		got(info)
+		t.mu.Lock()
+		t.reused = info.Reused
+		t.mu.Unlock()
+	}
+}
+
+// noteRequestTiming records, for -timing, an attempt at method u sent at
+// sent, with its phases in t.
+func noteRequestTiming(t *traceTimes, method string, u *url.URL, sent time.Time) {
+	if !*timing {
+		return
+	}
+	total := time.Since(sent)
+	t.mu.Lock()
+	r := requestTiming{Method: method, URL: u.Host + u.Path, Reused: t.reused, TotalMs: ms(total)}
+	phase := func(from, to time.Time) *float64 {
+		if from.IsZero() || to.IsZero() {
+			return nil
+		}
+		v := ms(to.Sub(from))
+		return &v
+	}
+	r.DNSMs = phase(t.dnsStart, t.dnsDone)
+	r.ConnectMs = phase(t.connectStart, t.connectDone)
+	r.FirstByteMs = phase(sent, t.firstByte)
+	t.mu.Unlock()
+	timingMu.Lock()
+	defer timingMu.Unlock()
+	timings.Requests = append(timings.Requests, r)
+}
+
+// report returns the timings so far, with the total until now.
+func (r timingReport) report() timingReport {
+	r.TotalMs = ms(time.Since(launched))
+	if r.Discovery == nil {
+		r.Discovery = []discoveryTiming{}
+	}
+	if r.Requests == nil {
+		r.Requests = []requestTiming{}
+	}
+	return r
+}
+
+// timingField returns the -timing report for the timing field of a
+// command's -json output, which then replaces the report at exit, or nil
+// without -timing.
+func timingField() *timingReport {
+	if !*timing {
+		return nil
+	}
+	timingMu.Lock()
+	defer timingMu.Unlock()
+	timingShown = true
+	r := timings.report()
+	return &r
+}
+
+// reportTiming prints the -timing report to stderr, unless it was in the
+// command's output, as JSON if the command was given -json.
+func reportTiming() {
+	if !*timing {
+		return
+	}
+	timingMu.Lock()
+	defer timingMu.Unlock()
+	if timingShown {
+		return
+	}
+	timings = timings.report()
+	if timingJSON != nil && *timingJSON {
+		json.NewEncoder(os.Stderr).Encode(timings)
+		return
+	}
+	if len(timings.Discovery) == 0 {
+		fmt.Fprintln(os.Stderr, "timing: discovery: none")
+	}
+	for _, d := range timings.Discovery {
+		fmt.Fprintf(os.Stderr, "timing: discovery: %s %.1fms\n", d.Method, d.Ms)
+	}
+	for _, r := range timings.Requests {
+		var phases []string
+		if r.Reused {
+			phases = append(phases, "reused connection")
+		}
+		if r.DNSMs != nil {
+			phases = append(phases, fmt.Sprintf("DNS %.1fms", *r.DNSMs))
+		}
+		if r.ConnectMs != nil {
+			phases = append(phases, fmt.Sprintf("connect %.1fms", *r.ConnectMs))
+		}
+		if r.FirstByteMs != nil {
+			phases = append(phases, fmt.Sprintf("first byte %.1fms", *r.FirstByteMs))
+		} else {
+			phases = append(phases, "no response")
+		}
+		phases = append(phases, fmt.Sprintf("total %.1fms", r.TotalMs))
+		fmt.Fprintf(os.Stderr, "timing: %s %s: %s\n", r.Method, r.URL, strings.Join(phases, ", "))
+	}
+	fmt.Fprintf(os.Stderr, "timing: total %.1fms\n", timings.TotalMs)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTimingJSON(t *testing.T) {
+	bin := buildElgo(t)
+	sim := testLight(false)
+	hostName := fakeDevice(t, sim.handler(simDevice{name: "Sim", model: "Elgato Key Light", firmware: "1.0.3"}))
+
+	for _, args := range [][]string{
+		{"on", "-json"},
+		{"info", "-json"},
+		{"identify", "-json", "-times", "1"},
+	} {
+		stdout, stderr, code := runElgoOutput(t, bin, append([]string{"-timing", "-host", hostName}, args...)...)
+		if code != 0 {
+			t.Errorf("%v: exit status %d: %s", args, code, stderr)
+			continue
+		}
+		var out struct {
+			Timing *timingReport `json:"timing"`
+		}
+		if err := json.Unmarshal([]byte(stdout), &out); err != nil {
+			t.Errorf("%v: %v in %q", args, err, stdout)
+			continue
+		}
+		if out.Timing == nil || len(out.Timing.Requests) == 0 || out.Timing.TotalMs <= 0 {
+			t.Errorf("%v: timing %+v in %s", args, out.Timing, stdout)
+		}
+		if strings.Contains(stderr, "totalMs") || strings.Contains(stderr, "timing:") {
+			t.Errorf("%v: timing reported again on stderr: %s", args, stderr)
+		}
+	}
+
+	// Without an object to hold them, the timings go to stderr.
+	_, stderr, _ := runElgoOutput(t, bin, "-timing", "-host", hostName, "status", "-json")
+	if !strings.Contains(stderr, `"totalMs"`) {
+		t.Errorf("status -json: no timing on stderr: %s", stderr)
+	}
+}
+
+// Commands that fail still report timing and stop profiling on the way out.
+func TestTimingOnFatal(t *testing.T) {
+	bin := buildElgo(t)
+	hostName := fakeDevice(t, testLight(false))
+	profile := filepath.Join(t.TempDir(), "cpu.prof")
+
+	_, stderr, code := runElgoOutput(t, bin, "-timing", "-cpuprofile", profile, "-host", hostName, "rename")
+	if code != 1 || !strings.Contains(stderr, "usage: elgo rename") {
+		t.Fatalf("rename without a name: exit status %d: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "timing:") {
+		t.Errorf("no timing report: %s", stderr)
+	}
+	if fi, err := os.Stat(profile); err != nil || fi.Size() == 0 {
+		t.Errorf("CPU profile not written: %v", err)
+	}
+}
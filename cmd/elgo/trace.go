@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+var traceFile = flag.String("trace", "", "append each device request and response to this file as a line of JSON, for reporting device quirks")
+
+// A traceEntry is one exchange with a device, as written by -trace.
+type traceEntry struct {
+	Time     time.Time      `json:"time"` // when the request was sent
+	Ms       float64        `json:"ms"`
+	Request  tracedRequest  `json:"request"`
+	Response *tracedMessage `json:"response,omitempty"` // nil if there was none
+	Error    string         `json:"error,omitempty"`
+}
+
+type tracedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+type tracedMessage struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// tracer is an http.RoundTripper that appends each exchange to a -trace
+// file. Device requests carry no credentials, so nothing is redacted. A
+// trace that can't be written is warned about once, and never fails a
+// request.
+type tracer struct {
+	next http.RoundTripper
+
+	mu     sync.Mutex
+	f      *os.File
+	failed bool
+}
+
+// newTracer returns a tracer appending to path in front of next, or next
+// itself if path can't be opened.
+func newTracer(path string, next http.RoundTripper) http.RoundTripper {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		cmdLog.warnf("not tracing: %v", err)
+		return next
+	}
+	return &tracer{next: next, f: f}
+}
+
+func (t *tracer) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+	e := traceEntry{
+		Time: time.Now(),
+		Request: tracedRequest{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Header: req.Header,
+			Body:   string(reqBody),
+		},
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err == nil {
+		var respBody []byte
+		respBody, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+		e.Response = &tracedMessage{Status: resp.StatusCode, Header: resp.Header, Body: string(respBody)}
+	}
+	e.Ms = ms(time.Since(e.Time))
+	if err != nil {
+		e.Error = err.Error()
+	}
+	t.write(e)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (t *tracer) write(e traceEntry) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, err := t.f.Write(append(b, '\n')); err != nil && !t.failed {
+		t.failed = true
+		cmdLog.warnf("tracing to %s: %v", t.f.Name(), err)
+	}
+}
+
+// traceCmd handles elgo trace replay, which re-issues the PUTs of a -trace
+// file, in order, to the device, to reproduce what a device did.
+func traceCmd(args []string) {
+	const usage = "usage: elgo trace replay [-realtime] FILE"
+	if len(args) == 0 || args[0] != "replay" {
+		fatal(errors.New(usage))
+	}
+	fs := flag.NewFlagSet("trace replay", flag.ExitOnError)
+	realtime := fs.Bool("realtime", false, "keep the time between requests as recorded")
+	fs.Parse(args[1:])
+	if fs.NArg() != 1 {
+		fatal(errors.New(usage))
+	}
+	entries := readTrace(fs.Arg(0))
+
+	hostName := resolveHost()
+	var last time.Time
+	n, failed := 0, 0
+	for _, e := range entries {
+		if e.Request.Method != http.MethodPut {
+			continue
+		}
+		if *realtime && !last.IsZero() {
+			if !sleepOrStop(e.Time.Sub(last)) {
+				break
+			}
+		}
+		last = e.Time
+		u, err := url.Parse(e.Request.URL)
+		if err != nil {
+			fatal(fmt.Errorf("%s: %v", fs.Arg(0), err))
+		}
+		u.Host = hostName
+		n++
+		respJson, err := request(http.MethodPut, u.String(), []byte(e.Request.Body))
+		if err != nil {
+			if interrupted.Err() != nil {
+				fatal(err)
+			}
+			cmdLog.warnf("%s %s: %v", e.Request.Method, u.Path, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s %s: %s\n", e.Request.Method, u.Path, bytes.TrimSpace(respJson))
+	}
+	if failed > 0 {
+		fatal(fmt.Errorf("%d of %d requests failed", failed, n))
+	}
+}
+
+// readTrace returns the entries of the -trace file at path.
+func readTrace(path string) []traceEntry {
+	f, err := os.Open(path)
+	if err != nil {
+		fatal(err)
+	}
+	defer f.Close()
+	var entries []traceEntry
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for line := 1; s.Scan(); line++ {
+		if len(bytes.TrimSpace(s.Bytes())) == 0 {
+			continue
+		}
+		var e traceEntry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			fatal(fmt.Errorf("%s:%d: %v", path, line, err))
+		}
+		entries = append(entries, e)
+	}
+	if err := s.Err(); err != nil {
+		fatal(fmt.Errorf("%s: %v", path, err))
+	}
+	return entries
+}
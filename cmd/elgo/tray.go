@@ -0,0 +1,217 @@
+//go:build linux || windows || (darwin && cgo)
+// +build linux windows darwin,cgo
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"fyne.io/systray"
+)
+
+// trayIcon draws a lamp icon: a filled disc when on, a ring when off.
+func trayIcon(on bool) []byte {
+	const size = 32
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	fill := color.NRGBA{0xff, 0xc8, 0x3c, 0xff}
+	if !on {
+		fill = color.NRGBA{0x80, 0x80, 0x80, 0xff}
+	}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			dx, dy := x-size/2, y-size/2
+			d := dx*dx + dy*dy
+			if d < 14*14 && (on || d >= 10*10) {
+				img.Set(x, y, fill)
+			}
+		}
+	}
+	b := &bytes.Buffer{}
+	png.Encode(b, img)
+	if runtime.GOOS != "windows" {
+		return b.Bytes()
+	}
+	// Windows wants an ICO, which may hold a PNG as its only image.
+	ico := &bytes.Buffer{}
+	binary.Write(ico, binary.LittleEndian, []uint16{0, 1, 1})
+	binary.Write(ico, binary.LittleEndian, []uint8{size, size, 0, 0})
+	binary.Write(ico, binary.LittleEndian, []uint16{1, 32})
+	binary.Write(ico, binary.LittleEndian, []uint32{uint32(b.Len()), 6 + 16})
+	ico.Write(b.Bytes())
+	return ico.Bytes()
+}
+
+// tray shows a system tray icon reflecting whether any light is on, with a
+// menu to control every device found at startup. Saved presets are listed
+// alongside fixed brightness and temperature levels and are reloaded while
+// running, so presets saved from the CLI appear without a restart.
+func tray(args []string) {
+	fs := flag.NewFlagSet("tray", flag.ExitOnError)
+	interval := fs.Duration("interval", 10*time.Second, "device polling interval, to keep the icon and cached state fresh")
+	fs.Parse(args)
+
+	found := discoverAll()
+	longRunning = true
+	var devices []*tracked
+	for _, d := range found {
+		t := &tracked{dev: d}
+		if err := t.refresh(); err != nil {
+			cmdLog.warnf("%s: %v", d.Instance, err)
+		}
+		devices = append(devices, t)
+	}
+
+	anyOn := func() bool {
+		for _, t := range devices {
+			if s, _ := t.current(); len(s.Lights) > 0 && s.Lights[0].On != 0 {
+				return true
+			}
+		}
+		return false
+	}
+	var iconMu sync.Mutex
+	updateIcon := func() {
+		iconMu.Lock()
+		defer iconMu.Unlock()
+		on := anyOn()
+		systray.SetIcon(trayIcon(on))
+		if on {
+			systray.SetTooltip("elgo: on")
+		} else {
+			systray.SetTooltip("elgo: off")
+		}
+	}
+
+	// do applies f to the first light of every device.
+	do := func(what string, f func(t *tracked) error) {
+		for _, t := range devices {
+			go func(t *tracked) {
+				if err := f(t); err != nil {
+					cmdLog.warnf("%s: %s: %v", t.dev.Instance, what, err)
+				}
+			}(t)
+		}
+	}
+	setField := func(what string, f func(l *light)) {
+		do(what, func(t *tracked) error {
+			s, _ := t.current()
+			if len(s.Lights) == 0 {
+				return errNoLights
+			}
+			l := light{On: s.Lights[0].On}
+			f(&l)
+			_, err := t.set(l)
+			return err
+		})
+	}
+	click := func(item *systray.MenuItem, f func()) {
+		go func() {
+			for range item.ClickedCh {
+				f()
+			}
+		}()
+	}
+
+	onReady := func() {
+		systray.SetTitle("elgo")
+		updateIcon()
+		for _, t := range devices {
+			t.onChange = append(t.onChange, func(old, new state, source string) { updateIcon() })
+			go t.poll(*interval)
+		}
+
+		click(systray.AddMenuItem("Toggle", "Toggle every light"), func() {
+			do("toggle", func(t *tracked) error {
+				_, err := t.toggle()
+				return err
+			})
+		})
+		bMenu := systray.AddMenuItem("Brightness", "")
+		for _, b := range []int{25, 50, 75, 100} {
+			b := b
+			click(bMenu.AddSubMenuItem(fmt.Sprintf("%d%%", b), ""), func() {
+				setField("brightness", func(l *light) { l.Brightness = b })
+			})
+		}
+		tMenu := systray.AddMenuItem("Temperature", "")
+		for _, k := range []int{3000, 4000, 5000, 6500} {
+			k := k
+			click(tMenu.AddSubMenuItem(fmt.Sprintf("%dK", k), ""), func() {
+				setField("temperature", func(l *light) { l.Temperature = fromKelvin(k) })
+			})
+		}
+
+		pMenu := systray.AddMenuItem("Presets", "Presets saved with elgo tui")
+		items := map[string]*systray.MenuItem{}
+		syncPresets := func() {
+			saved, err := loadPresets()
+			if err != nil {
+				cmdLog.warnf("%v", err)
+				return
+			}
+			var names []string
+			for name := range saved {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				item, ok := items[name]
+				if !ok {
+					name := name
+					item = pMenu.AddSubMenuItem(name, "")
+					items[name] = item
+					click(item, func() {
+						p, err := parsePreset(name)
+						if err != nil {
+							cmdLog.warnf("%v", err)
+							return
+						}
+						do(name, func(t *tracked) error {
+							s, err := p.stateFor(t.dev.HostName)
+							if err == nil {
+								_, err = t.set(s.Lights[0])
+							}
+							return err
+						})
+					})
+				}
+				item.SetTooltip(saved[name])
+				item.Show()
+			}
+			for name, item := range items {
+				if _, ok := saved[name]; !ok {
+					item.Hide()
+				}
+			}
+			if len(saved) == 0 {
+				pMenu.Disable()
+			} else {
+				pMenu.Enable()
+			}
+		}
+		syncPresets()
+		go func() {
+			for sleepOrStop(*interval) {
+				syncPresets()
+			}
+		}()
+
+		systray.AddSeparator()
+		click(systray.AddMenuItem("Quit", ""), systray.Quit)
+		go func() {
+			<-interrupted.Done()
+			systray.Quit()
+		}()
+	}
+	systray.Run(onReady, nil)
+}
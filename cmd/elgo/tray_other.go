@@ -0,0 +1,16 @@
+//go:build !linux && !windows && (!darwin || !cgo)
+// +build !linux
+// +build !windows
+// +build !darwin !cgo
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// tray is unavailable here: macOS needs cgo for the menu bar.
+func tray(args []string) {
+	fatal(fmt.Errorf("tray is not supported on %s (without cgo)", runtime.GOOS))
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// parseTriggers parses the -triggers allowlist: a comma-separated list of
+// toggle, on, off, preset (any preset) and preset/NAME (one preset).
+func parseTriggers(s string) (map[string]bool, error) {
+	allowed := map[string]bool{}
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		switch {
+		case t == "":
+		case t == "toggle", t == "on", t == "off", t == "preset", strings.HasPrefix(t, "preset/") && len(t) > len("preset/"):
+			allowed[t] = true
+		default:
+			return nil, fmt.Errorf("bad trigger %q: must be toggle, on, off, preset or preset/NAME", t)
+		}
+	}
+	return allowed, nil
+}
+
+// triggerResult is the JSON result of a trigger for one device.
+type triggerResult struct {
+	Name        string `json:"name"`
+	On          bool   `json:"on"`
+	Brightness  int    `json:"brightness,omitempty"`
+	Temperature int    `json:"temperature,omitempty"` // Kelvin
+	Error       string `json:"error,omitempty"`
+}
+
+// trigger handles GET requests that act on devices, for clients such as
+// browser bookmarks and the Stream Deck "Website" action that can't send
+// PUTs:
+//
+//	GET /trigger/toggle[?device=NAME]
+//	GET /trigger/on[?device=NAME&brightness=70&temperature=4000]
+//	GET /trigger/off[?device=NAME]
+//	GET /trigger/preset/NAME[?device=NAME]
+//
+// The device may be given by name, address or MAC address; without it,
+// every device is changed. Only actions in the -triggers
+// allowlist are served. With -api-token, the token may be given as a token
+// query parameter, since these clients can't set headers.
+func (a *api) trigger(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/trigger/"), "/")
+	allowed := a.triggers[action]
+	if strings.HasPrefix(action, "preset/") {
+		allowed = allowed || a.triggers["preset"]
+	}
+	if !allowed {
+		writeError(w, http.StatusForbidden, "trigger not allowed: "+action)
+		return
+	}
+
+	q := r.URL.Query()
+	// limit returns the light to set a device to, limited to its range; it
+	// is nil for toggles.
+	var limit func(t *tracked) (light, error)
+	switch action {
+	case "toggle":
+	case "on", "off":
+		on, b, k := 0, 0, 0
+		if action == "on" {
+			on = 1
+			var err error
+			if v := q.Get("brightness"); v != "" {
+				if b, err = strconv.Atoi(v); err != nil || b < 1 {
+					writeError(w, http.StatusBadRequest, "brightness must be a positive number")
+					return
+				}
+			}
+			if v := q.Get("temperature"); v != "" {
+				if k, err = strconv.Atoi(v); err != nil || k < 1 {
+					writeError(w, http.StatusBadRequest, "temperature must be a positive number (in Kelvins)")
+					return
+				}
+			}
+		}
+		limit = func(t *tracked) (light, error) {
+			l, err := limitLight(t.dev.HostName, b, k)
+			l.On = on
+			return l, err
+		}
+	default:
+		// Saved presets come from the daemon's config, so that they change
+		// on reload. Others must be given in full, e.g. on@50.
+		name := strings.TrimPrefix(action, "preset/")
+		p, ok := a.config.get().presets[name]
+		if !ok {
+			if l := strings.ToLower(name); l != "on" && l != "off" && !strings.Contains(name, "@") {
+				writeError(w, http.StatusNotFound, "no such preset: "+name)
+				return
+			}
+			var err error
+			if p, err = parsePreset(name); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		limit = func(t *tracked) (light, error) {
+			s, err := p.stateFor(t.dev.HostName)
+			if err != nil {
+				return light{}, err
+			}
+			return s.Lights[0], nil
+		}
+	}
+
+	// Every device is checked before any is changed.
+	name := q.Get("device")
+	targets := []*tracked{}
+	lights := []light{}
+	for _, t := range a.devices {
+		if name != "" && !t.dev.matches(name) {
+			continue
+		}
+		targets = append(targets, t)
+		if limit != nil {
+			l, err := limit(t)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("%s: %v", t.dev.Instance, err))
+				return
+			}
+			lights = append(lights, l)
+		}
+	}
+	status := http.StatusOK
+	results := []triggerResult{}
+	for i, t := range targets {
+		res := triggerResult{Name: t.dev.Instance}
+		var s state
+		var err error
+		if limit == nil {
+			s, err = t.toggle()
+		} else {
+			s, err = t.set(lights[i])
+		}
+		if err != nil {
+			res.Error = err.Error()
+			status = http.StatusBadGateway
+		} else if len(s.Lights) > 0 {
+			res.On = s.Lights[0].On != 0
+			res.Brightness = s.Lights[0].Brightness
+			if s.Lights[0].Temperature != 0 {
+				res.Temperature = toKelvin(s.Lights[0].Temperature)
+			}
+		}
+		results = append(results, res)
+	}
+	if len(results) == 0 && name != "" {
+		writeError(w, http.StatusNotFound, "no such device: "+name)
+		return
+	}
+	apiLog.infof("trigger %s: %d device(s)", action, len(results))
+	writeJSON(w, status, map[string]interface{}{"action": action, "devices": results})
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTriggerRange(t *testing.T) {
+	sim := testLight(false)
+	tr := &tracked{dev: device{Instance: "Key Light", HostName: fakeDevice(t, sim.handler(simDevice{name: "Sim", model: "Elgato Key Light", firmware: "1.0.3"}))}}
+	if err := tr.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	a := &api{devices: []*tracked{tr}, triggers: map[string]bool{"on": true}}
+	trigger := func(query string) int {
+		w := httptest.NewRecorder()
+		a.trigger(w, httptest.NewRequest(http.MethodGet, "/trigger/on?"+query, nil))
+		return w.Code
+	}
+
+	for _, q := range []string{"brightness=150", "temperature=2000", "brightness=x"} {
+		if code := trigger(q); code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", q, code, http.StatusBadRequest)
+		}
+	}
+	if s := sim.lightState(); s.Lights[0].On != 0 {
+		t.Errorf("refused triggers changed the device: %+v", s)
+	}
+
+	*clampRange = true
+	defer func() { *clampRange = false }()
+	if code := trigger("brightness=150&temperature=9000"); code != http.StatusOK {
+		t.Errorf("with -clamp: status %d", code)
+	}
+	if l := sim.lightState().Lights[0]; l.On != 1 || l.Brightness != 100 || l.Temperature != defaultRange.TemperatureMin {
+		t.Errorf("with -clamp: %+v, want on at 100 and 7000K", l)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// Keys decoded from terminal input. Printable keys are passed through as
+// their rune.
+const (
+	keyUp rune = -1 - iota
+	keyDown
+	keyLeft
+	keyRight
+	keyEsc
+)
+
+// readKeys decodes keys from r, including arrow key escape sequences,
+// and sends them on keys until r fails.
+func readKeys(r *bufio.Reader, keys chan<- rune) {
+	defer close(keys)
+	for {
+		c, _, err := r.ReadRune()
+		if err != nil {
+			return
+		}
+		if c != 0x1b {
+			keys <- c
+			continue
+		}
+		// A lone escape is followed by nothing, an arrow by "[A" etc.
+		if r.Buffered() < 2 {
+			keys <- keyEsc
+			continue
+		}
+		b1, _ := r.ReadByte()
+		b2, _ := r.ReadByte()
+		if b1 != '[' && b1 != 'O' {
+			continue
+		}
+		switch b2 {
+		case 'A':
+			keys <- keyUp
+		case 'B':
+			keys <- keyDown
+		case 'C':
+			keys <- keyRight
+		case 'D':
+			keys <- keyLeft
+		}
+	}
+}
+
+// slider renders v in [min, max] as a bar of width characters.
+func slider(v, min, max, width int) string {
+	n := 0
+	if max > min {
+		n = clamp((v-min)*width/(max-min), 0, width)
+	}
+	return "[" + strings.Repeat("#", n) + strings.Repeat("-", width-n) + "]"
+}
+
+// tuiModel is the state of the terminal UI.
+type tuiModel struct {
+	devices []*tracked
+	errs    []error
+	edits   []*light // local changes not yet written, per device
+	sel     int      // selected device
+	field   int      // 0 for brightness, 1 for temperature
+	prompt  *string  // preset name being typed, if any
+	status  string
+}
+
+// view returns the light shown for device i: its pending edit, if any, or
+// its last known state.
+func (m *tuiModel) view(i int) (light, bool) {
+	if m.edits[i] != nil {
+		return *m.edits[i], true
+	}
+	s, _ := m.devices[i].current()
+	if len(s.Lights) == 0 {
+		return light{}, false
+	}
+	return s.Lights[0], true
+}
+
+func (m *tuiModel) render() string {
+	b := &strings.Builder{}
+	b.WriteString("\x1b[H\x1b[2J")
+	b.WriteString("elgo: tab next light, up/down select slider, left/right adjust, space power, s save preset, q quit\r\n\r\n")
+	for i, t := range m.devices {
+		cursor := "  "
+		if i == m.sel {
+			cursor = "> "
+		}
+		l, ok := m.view(i)
+		power := "?"
+		if ok && l.On != 0 {
+			power = "on"
+		} else if ok {
+			power = "off"
+		}
+		fmt.Fprintf(b, "%s%s (%s)\r\n", cursor, t.dev.Instance, power)
+		if m.errs[i] != nil {
+			fmt.Fprintf(b, "    error: %v\r\n", m.errs[i])
+		}
+		if !ok {
+			b.WriteString("\r\n")
+			continue
+		}
+		k := 0
+		if l.Temperature != 0 {
+			k = toKelvin(l.Temperature)
+		}
+		rows := []string{
+			fmt.Sprintf("Brightness  %s %3d%%", slider(l.Brightness, 1, 100, 40), l.Brightness),
+			fmt.Sprintf("Temperature %s %dK", slider(k, 2900, 7000, 40), k),
+		}
+		for f, r := range rows {
+			if i == m.sel && f == m.field {
+				r = "\x1b[7m" + r + "\x1b[0m"
+			}
+			fmt.Fprintf(b, "    %s\r\n", r)
+		}
+		b.WriteString("\r\n")
+	}
+	if m.prompt != nil {
+		fmt.Fprintf(b, "save preset as: %s", *m.prompt)
+	} else {
+		b.WriteString(m.status)
+	}
+	return b.String()
+}
+
+// tui shows the lights found at startup with sliders for brightness and
+// temperature. Changes are written once keys have been idle for -debounce,
+// so holding a key doesn't flood the device.
+func tui(args []string) {
+	fs := flag.NewFlagSet("tui", flag.ExitOnError)
+	interval := fs.Duration("interval", 2*time.Second, "polling interval")
+	debounce := fs.Duration("debounce", 200*time.Millisecond, "delay after the last key before writing changes")
+	brightnessStep := fs.Int("brightness-step", 5, "brightness change per key press")
+	temperatureStep := fs.Int("temperature-step", 100, "temperature change per key press (in Kelvins)")
+	fs.Parse(args)
+
+	in, out := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !term.IsTerminal(in) || !term.IsTerminal(out) {
+		fatal(errors.New("tui: stdin and stdout must be a terminal"))
+	}
+
+	found := discoverAll()
+	longRunning = true
+	m := &tuiModel{
+		errs:  make([]error, len(found)),
+		edits: make([]*light, len(found)),
+	}
+	type result struct {
+		i   int
+		err error
+	}
+	results := make(chan result)
+	for i, d := range found {
+		t := &tracked{dev: d}
+		m.devices = append(m.devices, t)
+		go func(i int) {
+			for {
+				results <- result{i, t.refresh()}
+				time.Sleep(*interval)
+			}
+		}(i)
+	}
+
+	old, err := term.MakeRaw(in)
+	if err != nil {
+		fatal(err)
+	}
+	// Use the alternate screen and hide the cursor, restoring both on exit.
+	os.Stdout.WriteString("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		os.Stdout.WriteString("\x1b[?25h\x1b[?1049l")
+		term.Restore(in, old)
+	}()
+
+	keys := make(chan rune)
+	go readKeys(bufio.NewReader(os.Stdin), keys)
+	var pending <-chan time.Time
+	written := make(chan result)
+	edit := func(f func(l light) light) {
+		l, ok := m.view(m.sel)
+		if !ok {
+			return
+		}
+		l = f(l)
+		m.edits[m.sel] = &l
+		pending = time.After(*debounce)
+	}
+
+	for {
+		os.Stdout.WriteString(m.render())
+		select {
+		case r := <-results:
+			m.errs[r.i] = r.err
+		case r := <-written:
+			m.errs[r.i] = r.err
+		case <-pending:
+			pending = nil
+			for i, l := range m.edits {
+				if l == nil {
+					continue
+				}
+				m.edits[i] = nil
+				// Keep showing the edit until the write is observed.
+				m.devices[i].observe(state{NumberOfLights: 1, Lights: []light{*l}}, sourceManual)
+				go func(i int, l light) {
+					_, err := m.devices[i].set(l)
+					written <- result{i, err}
+				}(i, *l)
+			}
+		case k, ok := <-keys:
+			if !ok {
+				return
+			}
+			if m.prompt != nil {
+				switch {
+				case k == '\r' || k == '\n':
+					name := *m.prompt
+					m.prompt = nil
+					l, ok := m.view(m.sel)
+					if !ok {
+						m.status = "no state to save"
+						break
+					}
+					p := preset{On: l.On != 0, Brightness: l.Brightness}
+					if l.Temperature != 0 {
+						p.Temperature = toKelvin(l.Temperature)
+					}
+					if err := savePreset(name, p); err != nil {
+						m.status = err.Error()
+					} else {
+						m.status = fmt.Sprintf("saved preset %q (%s)", name, p)
+					}
+				case k == keyEsc || k == 3:
+					m.prompt = nil
+					m.status = ""
+				case k == 0x7f || k == 0x08:
+					if s := *m.prompt; s != "" {
+						s = string([]rune(s)[:len([]rune(s))-1])
+						m.prompt = &s
+					}
+				case k >= ' ':
+					s := *m.prompt + string(k)
+					m.prompt = &s
+				}
+				continue
+			}
+			switch k {
+			case 'q', 3, keyEsc:
+				return
+			case '\t':
+				m.sel = (m.sel + 1) % len(m.devices)
+			case keyUp, 'k':
+				m.field = 0
+			case keyDown, 'j':
+				m.field = 1
+			case keyLeft, 'h', keyRight, 'l':
+				sign := 1
+				if k == keyLeft || k == 'h' {
+					sign = -1
+				}
+				edit(func(l light) light {
+					if m.field == 0 {
+						l.Brightness = clamp(l.Brightness+sign**brightnessStep, 1, 100)
+					} else if l.Temperature != 0 {
+						l.Temperature = defaultRange.temperature(toKelvin(l.Temperature) + sign**temperatureStep)
+					}
+					return l
+				})
+			case ' ':
+				edit(func(l light) light {
+					l.On = 1 - l.On
+					return l
+				})
+			case 's':
+				s := ""
+				m.prompt = &s
+			}
+		}
+	}
+}
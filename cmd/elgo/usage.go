@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+var usageStats = flag.Bool("usage-stats", false, "record how often each command is used and how long its device requests take, in a local file shown by elgo stats -usage (nothing is sent anywhere)")
+
+// commandUsage is the use of one command, as recorded with -usage-stats.
+type commandUsage struct {
+	Runs           int       `json:"runs"`
+	Failures       int       `json:"failures"`       // runs that exited with an error
+	Requests       int       `json:"requests"`       // to devices, including retries
+	RequestSeconds float64   `json:"requestSeconds"` // spent on those requests
+	LastRun        time.Time `json:"lastRun"`
+}
+
+// usageCommand is the command being run, for recordUsage.
+var usageCommand string
+
+// Device requests made by this run, for recordUsage.
+var usageRequests, usageRequestNanos int64
+
+// noteRequest counts a device request that took d.
+func noteRequest(d time.Duration) {
+	atomic.AddInt64(&usageRequests, 1)
+	atomic.AddInt64(&usageRequestNanos, int64(d))
+}
+
+func usagePath() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "usage.json"), nil
+}
+
+// loadUsage returns the recorded use of each command, by name.
+func loadUsage() (map[string]*commandUsage, error) {
+	usage := map[string]*commandUsage{}
+	path, err := usagePath()
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return usage, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &usage); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return usage, nil
+}
+
+// recordUsage adds this run of usageCommand to the usage file, with
+// -usage-stats. Failures are logged, since they are never worth failing a
+// command for.
+func recordUsage(ok bool) {
+	if !*usageStats || usageCommand == "" || usageCommand == "stats" {
+		return
+	}
+	usage, err := loadUsage()
+	var path string
+	if err == nil {
+		u := usage[usageCommand]
+		if u == nil {
+			u = &commandUsage{}
+			usage[usageCommand] = u
+		}
+		u.Runs++
+		if !ok {
+			u.Failures++
+		}
+		u.Requests += int(atomic.LoadInt64(&usageRequests))
+		u.RequestSeconds += time.Duration(atomic.LoadInt64(&usageRequestNanos)).Seconds()
+		u.LastRun = time.Now()
+		path, err = usagePath()
+	}
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+	}
+	if err == nil {
+		var b []byte
+		b, err = json.MarshalIndent(usage, "", "  ")
+		if err == nil {
+			err = ioutil.WriteFile(path, b, 0644)
+		}
+	}
+	if err != nil {
+		cmdLog.warnf("recording usage: %v", err)
+	}
+}
+
+// printUsage prints the recorded use of each command, most used first.
+func printUsage(asJSON bool) {
+	usage, err := loadUsage()
+	if err != nil {
+		fatal(err)
+	}
+	if asJSON {
+		if err := json.NewEncoder(os.Stdout).Encode(usage); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if len(usage) == 0 {
+		fmt.Println("no usage recorded (set usage-stats: true in the config file to record it)")
+		return
+	}
+	var names []string
+	for name := range usage {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if usage[names[i]].Runs != usage[names[j]].Runs {
+			return usage[names[i]].Runs > usage[names[j]].Runs
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		u := usage[name]
+		fmt.Printf("%s: %d runs, %d failed", name, u.Runs, u.Failures)
+		if u.Requests > 0 {
+			avg := time.Duration(u.RequestSeconds / float64(u.Requests) * float64(time.Second))
+			fmt.Printf(", %d requests averaging %s", u.Requests, avg.Round(time.Millisecond/10))
+		}
+		fmt.Printf(", last %s\n", u.LastRun.Format("2006-01-02 15:04"))
+	}
+}
+
+// resetUsage deletes the recorded usage.
+func resetUsage() {
+	path, err := usagePath()
+	if err == nil {
+		err = os.Remove(path)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		fatal(err)
+	}
+}
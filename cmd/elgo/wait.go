@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var waitFor = flag.Duration("wait-for-device", 0, "keep retrying discovery (or the -host device) for up to this long until a device appears, then act with the full -timeout")
+
+// waitForDevices retries discovery, or contacting the -host device, until a
+// device appears or -wait-for-device passes. If first is set, it returns as
+// soon as one device is found; otherwise it returns all devices found in the
+// first round that finds any. Each round gets the full -timeout, and so does
+// whatever follows.
+func waitForDevices(first bool) []device {
+	deadline := time.Now().Add(*waitFor)
+	for {
+		start = time.Now()
+		var found []device
+		if *host != "" {
+			d := device{Instance: *host, HostName: hostAddr()}
+			if _, err := fetchState(d.HostName); err == nil {
+				found = append(found, d)
+			} else {
+				deviceLog(d.HostName).debugf("%v", err)
+			}
+		} else {
+			devs := make(chan device)
+			stop := make(chan struct{})
+			var once sync.Once
+			stopOnce := func() { once.Do(func() { close(stop) }) }
+			if err := browseMDNS(devs, stop); err != nil {
+				fatal(err)
+			}
+			timer := time.AfterFunc(time.Until(deadline), stopOnce)
+			for d := range devs {
+				found = append(found, d)
+				if first {
+					stopOnce()
+				}
+			}
+			timer.Stop()
+			if first && len(found) > 1 {
+				found = found[:1]
+			}
+		}
+		if len(found) > 0 {
+			start = time.Now()
+			return found
+		}
+		left := time.Until(deadline)
+		if left <= 0 {
+			fatal(fmt.Errorf("no device appeared within %s", *waitFor))
+		}
+		cmdLog.debugf("still waiting for a device (%s left)", left.Round(time.Second))
+		if *host != "" {
+			time.Sleep(time.Second)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vsekhar/elgo"
+)
+
+// wakeWait is how long wake waits for the device to reappear without
+// -wait-for-device.
+const wakeWait = 30 * time.Second
+
+// magicPacket returns the Wake-on-LAN packet for mac: six 0xff bytes, then
+// the MAC sixteen times.
+func magicPacket(mac net.HardwareAddr) []byte {
+	p := bytes.Repeat([]byte{0xff}, 6)
+	for i := 0; i < 16; i++ {
+		p = append(p, mac...)
+	}
+	return p
+}
+
+// sendMagicPacket sends the Wake-on-LAN packet for mac to addr (e.g. the
+// broadcast address and port 9).
+func sendMagicPacket(mac net.HardwareAddr, addr string) error {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(magicPacket(mac))
+	return err
+}
+
+// wake sends a Wake-on-LAN packet to a device that has dropped off the
+// network, waits for it to reappear via mDNS, and then, if given on, off or
+// toggle, acts on it.
+func wake(args []string) {
+	fs := flag.NewFlagSet("wake", flag.ExitOnError)
+	macFlag := fs.String("mac", "", "MAC address of the device (default that cached for -host)")
+	broadcast := fs.String("broadcast", "255.255.255.255:9", "address and port to send the magic packet to")
+	fs.Parse(args)
+	command := ""
+	switch {
+	case fs.NArg() > 1:
+		fatal(errors.New("usage: elgo wake [-mac MAC] [on|off|toggle]"))
+	case fs.NArg() == 1:
+		command = strings.ToLower(fs.Arg(0))
+		if command != "on" && command != "off" && command != "toggle" {
+			fatal(fmt.Errorf("bad command: %s", fs.Arg(0)))
+		}
+	}
+
+	mac := parseMAC(*macFlag)
+	if *macFlag == "" && *host != "" {
+		c := loadCache()
+		mac = parseMAC(c.find(hostAddr()))
+	}
+	if mac == "" {
+		if *macFlag != "" {
+			fatal(fmt.Errorf("bad -mac: %s", *macFlag))
+		}
+		fatal(errors.New("-mac is needed, unless -host is a device whose MAC elgo has seen"))
+	}
+	hw, _ := net.ParseMAC(mac)
+
+	wait := *waitFor
+	if wait == 0 {
+		wait = wakeWait
+	}
+	deadline := time.Now().Add(wait)
+	var hostName string
+	for hostName == "" {
+		if err := sendMagicPacket(hw, *broadcast); err != nil {
+			fatal(err)
+		}
+		discoveryLog.debugf("sent magic packet for %s to %s", mac, *broadcast)
+		start = time.Now()
+		hostName = findMAC(mac, deadline)
+		if hostName == "" && time.Now().After(deadline) {
+			fatal(fmt.Errorf("%w: %s didn't wake within %s", elgo.ErrNoDeviceFound, mac, wait))
+		}
+	}
+	*host = hostName
+	start = time.Now()
+	if command == "" {
+		fmt.Println(hostName)
+		return
+	}
+	applyXY()
+	applyColor()
+	if l := act(hostName, command); command == "toggle" && l.On == 0 {
+		exit(exitOff)
+	}
+}
+
+// findMAC browses mDNS for one round, or until deadline, and returns the
+// address of the device with mac, or "" if it wasn't found.
+func findMAC(mac string, deadline time.Time) string {
+	devs := make(chan device)
+	stop := make(chan struct{})
+	var once sync.Once
+	stopOnce := func() { once.Do(func() { close(stop) }) }
+	if err := browseMDNS(devs, stop); err != nil {
+		fatal(err)
+	}
+	timer := time.AfterFunc(time.Until(deadline), stopOnce)
+	defer timer.Stop()
+	hostName := ""
+	for d := range devs {
+		if d.MAC == mac && hostName == "" {
+			hostName = d.HostName
+			stopOnce()
+		}
+	}
+	return hostName
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// event describes a change to one property of a light.
+type event struct {
+	Time   time.Time `json:"time"`
+	Device string    `json:"device"`
+	Host   string    `json:"host"`
+	MAC    string    `json:"mac,omitempty"`
+	Field  string    `json:"field"`
+	Old    int       `json:"old"`
+	New    int       `json:"new"`
+}
+
+func (e event) String() string {
+	return fmt.Sprintf("%s: %s %d -> %d", e.Device, e.Field, e.Old, e.New)
+}
+
+// diffStates returns an event for each property of the first light that
+// differs between old and new. Temperatures are reported in Kelvin.
+func diffStates(d device, old, new state) []event {
+	if len(old.Lights) == 0 || len(new.Lights) == 0 {
+		return nil
+	}
+	o, n := old.Lights[0], new.Lights[0]
+	now := time.Now()
+	var events []event
+	add := func(field string, oldValue, newValue int) {
+		if oldValue != newValue {
+			events = append(events, event{now, d.Instance, d.HostName, d.MAC, field, oldValue, newValue})
+		}
+	}
+	add("on", o.On, n.On)
+	add("brightness", o.Brightness, n.Brightness)
+	if o.Temperature != 0 && n.Temperature != 0 {
+		add("temperature", toKelvin(o.Temperature), toKelvin(n.Temperature))
+	}
+	if hasColor(o) && hasColor(n) {
+		add("hue", int(math.Round(*o.Hue)), int(math.Round(*n.Hue)))
+		if o.Saturation != nil && n.Saturation != nil {
+			add("saturation", int(math.Round(*o.Saturation)), int(math.Round(*n.Saturation)))
+		}
+	}
+	return events
+}
+
+// pollDevice sends an event on events for each change observed on d until
+// shutdown.
+func pollDevice(d device, interval time.Duration, events chan<- event) {
+	var prev *state
+	for ok := true; ok; ok = sleepOrStop(interval) {
+		s, err := fetchState(d.HostName)
+		if err == errStopping {
+			return
+		} else if err != nil {
+			cmdLog.warnf("%s: %v", d.Instance, err)
+		} else {
+			if prev != nil {
+				for _, e := range diffStates(d, *prev, s) {
+					events <- e
+				}
+			}
+			prev = &s
+		}
+	}
+}
+
+// runHooks runs command for each event received on events, one at a time.
+func runHooks(command string, debounce time.Duration, events <-chan event) {
+	debounceEvents(debounce, events, func(e event) { runHook(command, e) })
+}
+
+// debounceEvents calls f for each event received on events, in order. With
+// a debounce, changes arriving within it of each other are collapsed into
+// one event per field of each device, from its value before the first to
+// its value after the last, and fields that ended where they started are
+// dropped.
+func debounceEvents(debounce time.Duration, events <-chan event, f func(event)) {
+	if debounce <= 0 {
+		for e := range events {
+			f(e)
+		}
+		return
+	}
+	for e := range events {
+		burst := []event{e}
+		timer := time.NewTimer(debounce)
+		open := true
+		for open {
+			select {
+			case next, ok := <-events:
+				if !ok {
+					open = false
+					break
+				}
+				burst = mergeEvent(burst, next)
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(debounce)
+			case <-timer.C:
+				open = false
+			}
+		}
+		timer.Stop()
+		for _, e := range burst {
+			if e.Old != e.New {
+				f(e)
+			}
+		}
+	}
+}
+
+// mergeEvent adds e to burst, updating the event for the same field of the
+// same device if there is one.
+func mergeEvent(burst []event, e event) []event {
+	for i, b := range burst {
+		if b.Host == e.Host && b.Field == e.Field {
+			e.Old = b.Old
+			burst[i] = e
+			return burst
+		}
+	}
+	return append(burst, e)
+}
+
+// runHook runs command with e in its environment and as JSON on its stdin.
+func runHook(command string, e event) {
+	j, err := json.Marshal(e)
+	if err != nil {
+		fatal(err)
+	}
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		"ELGO_DEVICE="+e.Device,
+		"ELGO_FIELD="+e.Field,
+		"ELGO_OLD="+strconv.Itoa(e.Old),
+		"ELGO_NEW="+strconv.Itoa(e.New),
+	)
+	cmd.Stdin = bytes.NewReader(j)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		cmdLog.warnf("exec %q: %v", command, err)
+	}
+}
+
+// watch polls every device found during discovery and prints each change.
+func watch(args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	interval := fs.Duration("interval", 2*time.Second, "polling interval")
+	execCommand := fs.String("exec", "", "command to run (with sh -c) on each change, with the event in ELGO_DEVICE, ELGO_FIELD, ELGO_OLD and ELGO_NEW and as JSON on stdin")
+	debounce := fs.Duration("debounce", 0, "collapse changes to each property of a device within this window into one -exec invocation (by default, run it for every change)")
+	notify := fs.Bool("notify", false, "show a desktop notification for each change")
+	drain := fs.Duration("drain", 5*time.Second, "on SIGINT or SIGTERM, wait this long for in-flight requests")
+	fs.Parse(args)
+
+	found := discoverAll()
+	longRunning = true
+	var n *notifier
+	if *notify {
+		n = newNotifier()
+	}
+	for _, d := range found {
+		events := make(chan event, 16)
+		go pollDevice(d, *interval, events)
+		var hookEvents chan event
+		if *execCommand != "" {
+			hookEvents = make(chan event, 16)
+			go runHooks(*execCommand, *debounce, hookEvents)
+		}
+		go func() {
+			for e := range events {
+				fmt.Println(e)
+				if n != nil {
+					n.events([]event{e}, sourceExternal)
+				}
+				if hookEvents != nil {
+					hookEvents <- e
+				}
+			}
+		}()
+	}
+	awaitShutdown(*drain)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDebounceEvents(t *testing.T) {
+	ev := func(host, field string, old, new int) event {
+		return event{Device: host, Host: host, Field: field, Old: old, New: new}
+	}
+	// One poll of a, with the power and brightness changed at once, then
+	// more changes to a and one to b.
+	in := []event{
+		ev("a", "on", 0, 1),
+		ev("a", "brightness", 20, 30),
+		ev("a", "brightness", 30, 40),
+		ev("b", "on", 1, 0),
+		ev("a", "temperature", 4000, 5000),
+		ev("a", "temperature", 5000, 4000),
+	}
+	run := func(debounce time.Duration) []event {
+		events := make(chan event)
+		go func() {
+			for _, e := range in {
+				events <- e
+			}
+			close(events)
+		}()
+		var got []event
+		debounceEvents(debounce, events, func(e event) { got = append(got, e) })
+		return got
+	}
+
+	if got := run(0); !reflect.DeepEqual(got, in) {
+		t.Errorf("without debounce, ran %v, want every event %v", got, in)
+	}
+	want := []event{
+		ev("a", "on", 0, 1),
+		ev("a", "brightness", 20, 40),
+		ev("b", "on", 1, 0),
+	}
+	if got := run(time.Hour); !reflect.DeepEqual(got, want) {
+		t.Errorf("with debounce, ran %v, want %v", got, want)
+	}
+}
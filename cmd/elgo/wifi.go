@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+var errNoWifiInfo = errors.New("this device or firmware doesn't report Wi-Fi information")
+
+// wifiInfo is the wifi-info in the accessory-info of recent firmware.
+type wifiInfo struct {
+	SSID         string `json:"ssid"`
+	FrequencyMHz int    `json:"frequencyMHz"`
+	RSSI         int    `json:"rssi"` // dBm
+}
+
+// signal describes the RSSI in words.
+func (w wifiInfo) signal() string {
+	switch {
+	case w.RSSI >= -50:
+		return "excellent"
+	case w.RSSI >= -60:
+		return "good"
+	case w.RSSI >= -70:
+		return "fair"
+	}
+	return "weak"
+}
+
+func (w wifiInfo) band() string {
+	switch {
+	case w.FrequencyMHz == 0:
+		return "unknown"
+	case w.FrequencyMHz < 3000:
+		return "2.4 GHz"
+	case w.FrequencyMHz < 5925:
+		return "5 GHz"
+	}
+	return "6 GHz"
+}
+
+// wifi prints the network, signal strength and band a device is connected
+// with.
+func wifi(args []string) {
+	fs := flag.NewFlagSet("wifi", flag.ExitOnError)
+	asJSON := jsonFlag(fs, "print the Wi-Fi information as JSON")
+	fs.Parse(args)
+
+	hostName := resolveHost()
+	i, err := fetchAccessoryInfo(hostName)
+	if err != nil {
+		fatal(err)
+	}
+	if i.WifiInfo == nil {
+		fatal(fmt.Errorf("%s: %v", hostName, errNoWifiInfo))
+	}
+	w := *i.WifiInfo
+	if *asJSON {
+		out := struct {
+			wifiInfo
+			Timing *timingReport `json:"timing,omitempty"`
+		}{w, timingField()}
+		if err := json.NewEncoder(os.Stdout).Encode(out); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	fmt.Printf("ssid: %s\n", w.SSID)
+	fmt.Printf("signal: %d dBm (%s)\n", w.RSSI, w.signal())
+	fmt.Printf("band: %s\n", w.band())
+}
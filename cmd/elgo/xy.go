@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/vsekhar/elgo"
+)
+
+var xy = flag.String("xy", "", "set color temperature to the correlated color temperature of a CIE 1931 x,y chromaticity (e.g. 0.3127,0.3290 for D65)")
+
+// maxDuv is how far (in CIE 1960 uv) a chromaticity may be from the
+// Planckian locus before its correlated color temperature is a poor match.
+const maxDuv = 0.05
+
+// parseXY parses a CIE 1931 chromaticity given as x,y.
+func parseXY(s string) (x, y float64, err error) {
+	parts := strings.Split(s, ",")
+	var err1, err2 error
+	if len(parts) == 2 {
+		x, err1 = strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		y, err2 = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	}
+	switch {
+	case len(parts) != 2 || err1 != nil || err2 != nil:
+		return 0, 0, errors.New("want x,y")
+	case x <= 0 || y <= 0 || x+y >= 1:
+		return 0, 0, errors.New("not a chromaticity: x and y must be positive and add up to less than 1")
+	}
+	return x, y, nil
+}
+
+// mccamy returns the correlated color temperature of the CIE 1931
+// chromaticity x, y using McCamy's cubic approximation, which is within a
+// few Kelvin of the exact value from 2856K to 6500K, and within a few
+// percent out to 2000K and 12500K.
+func mccamy(x, y float64) float64 {
+	n := (x - 0.3320) / (y - 0.1858)
+	return -449*n*n*n + 3525*n*n - 6823.3*n + 5520.33
+}
+
+// applyXY sets the temperature setting to the correlated color temperature
+// of -xy, warning if the chromaticity is too far from white for a color
+// temperature to match it well.
+func applyXY() {
+	if *xy == "" {
+		return
+	}
+	if temperature.isSet() || *rawTemperature != 0 {
+		fatal(errors.New("-xy, -temperature and -raw-temperature are mutually exclusive"))
+	}
+	x, y, err := parseXY(*xy)
+	if err != nil {
+		fatal(fmt.Errorf("%w: bad -xy %q: %v", elgo.ErrInvalidTemperature, *xy, err))
+	}
+	k := mccamy(x, y)
+	// McCamy's formula is meaningless far from the locus, e.g. for greens
+	// and purples below it.
+	if k < 1000 || k > 25000 || math.IsNaN(k) {
+		cmdLog.warnf("-xy %s is not near any white; using the nearest color temperature the device supports", *xy)
+	} else if d := fromXY(x, y).dist(planckian(k)); d > maxDuv {
+		cmdLog.warnf("-xy %s is %.3f from the nearest white (%.0fK); the light can't match its tint", *xy, d, k)
+	}
+	// Anything out of range is clamped per device by clampXY.
+	switch {
+	case math.IsNaN(k) || k < 1:
+		k = 1
+	case k > kelvinFactor:
+		k = kelvinFactor
+	}
+	temperature.n = int(math.Round(k))
+}
+
+// clampXY limits t, set by -xy, to between min and max Kelvin, warning
+// if that changes it, and reports the color temperature applied.
+func clampXY(hostName string, t setting, min, max int) setting {
+	switch {
+	case t.n < min:
+		deviceLog(hostName).warnf("-xy %s (%dK) is out of range; using %dK", *xy, t.n, min)
+		t.n = min
+	case t.n > max:
+		deviceLog(hostName).warnf("-xy %s (%dK) is out of range; using %dK", *xy, t.n, max)
+		t.n = max
+	default:
+		deviceLog(hostName).infof("-xy %s is %dK", *xy, t.n)
+	}
+	return t
+}
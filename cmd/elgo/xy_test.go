@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseXY(t *testing.T) {
+	x, y, err := parseXY("0.3127, 0.3290")
+	if err != nil || x != 0.3127 || y != 0.3290 {
+		t.Errorf("parseXY(D65) = %v, %v, %v", x, y, err)
+	}
+	for _, s := range []string{"", "0.3", "0.3,0.3,0.3", "a,b", "0,0.3", "-0.1,0.3", "0.6,0.4"} {
+		if _, _, err := parseXY(s); err == nil {
+			t.Errorf("parseXY(%q) accepted", s)
+		}
+	}
+}
+
+func TestMcCamy(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		x, y   float64
+		kelvin float64
+	}{
+		{"D65", 0.3127, 0.3290, 6504},
+		{"D50", 0.3457, 0.3585, 5003},
+		{"A", 0.44757, 0.40745, 2856},
+	} {
+		if k := mccamy(tt.x, tt.y); math.Abs(k-tt.kelvin) > 5 {
+			t.Errorf("mccamy(%s) = %.0fK, want %.0fK", tt.name, k, tt.kelvin)
+		}
+	}
+	// Along the locus, it agrees with the exact conversion to within about
+	// 10K over the range it is good for.
+	for k := 2900.0; k <= 6500; k += 100 {
+		w := planckian(k)
+		d := 2*w.u - 8*w.v + 4
+		x, y := 3*w.u/d, 2*w.v/d
+		if got := mccamy(x, y); math.Abs(got-k) > 15 {
+			t.Errorf("mccamy of planckian(%.0f) = %.0fK", k, got)
+		}
+	}
+}
+
+func TestClampXY(t *testing.T) {
+	for _, tt := range []struct{ n, want int }{
+		{6504, 6504},
+		{2900, 2900},
+		{2000, 2900},
+		{12000, 7000},
+	} {
+		if got := clampXY("test", setting{name: "temperature", n: tt.n}, 2900, 7000); got.n != tt.want {
+			t.Errorf("clampXY(%dK) = %dK, want %dK", tt.n, got.n, tt.want)
+		}
+	}
+}
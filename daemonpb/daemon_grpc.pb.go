@@ -0,0 +1,285 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package daemonpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// DaemonClient is the client API for Daemon service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type DaemonClient interface {
+	// ListDevices returns every device the daemon tracks.
+	ListDevices(ctx context.Context, in *ListDevicesRequest, opts ...grpc.CallOption) (*ListDevicesResponse, error)
+	// GetState returns one device, as last observed.
+	GetState(ctx context.Context, in *GetStateRequest, opts ...grpc.CallOption) (*Device, error)
+	// SetState writes a light to a device and returns the device.
+	SetState(ctx context.Context, in *SetStateRequest, opts ...grpc.CallOption) (*Device, error)
+	// Toggle flips the power of a device and returns the device.
+	Toggle(ctx context.Context, in *ToggleRequest, opts ...grpc.CallOption) (*Device, error)
+	// WatchEvents streams a snapshot of the devices and then each change to
+	// them, like GET /v1/events.
+	WatchEvents(ctx context.Context, in *WatchEventsRequest, opts ...grpc.CallOption) (Daemon_WatchEventsClient, error)
+}
+
+type daemonClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDaemonClient(cc grpc.ClientConnInterface) DaemonClient {
+	return &daemonClient{cc}
+}
+
+func (c *daemonClient) ListDevices(ctx context.Context, in *ListDevicesRequest, opts ...grpc.CallOption) (*ListDevicesResponse, error) {
+	out := new(ListDevicesResponse)
+	err := c.cc.Invoke(ctx, "/elgo.daemon.v1.Daemon/ListDevices", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *daemonClient) GetState(ctx context.Context, in *GetStateRequest, opts ...grpc.CallOption) (*Device, error) {
+	out := new(Device)
+	err := c.cc.Invoke(ctx, "/elgo.daemon.v1.Daemon/GetState", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *daemonClient) SetState(ctx context.Context, in *SetStateRequest, opts ...grpc.CallOption) (*Device, error) {
+	out := new(Device)
+	err := c.cc.Invoke(ctx, "/elgo.daemon.v1.Daemon/SetState", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *daemonClient) Toggle(ctx context.Context, in *ToggleRequest, opts ...grpc.CallOption) (*Device, error) {
+	out := new(Device)
+	err := c.cc.Invoke(ctx, "/elgo.daemon.v1.Daemon/Toggle", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *daemonClient) WatchEvents(ctx context.Context, in *WatchEventsRequest, opts ...grpc.CallOption) (Daemon_WatchEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Daemon_ServiceDesc.Streams[0], "/elgo.daemon.v1.Daemon/WatchEvents", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &daemonWatchEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Daemon_WatchEventsClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type daemonWatchEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *daemonWatchEventsClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// DaemonServer is the server API for Daemon service.
+// All implementations must embed UnimplementedDaemonServer
+// for forward compatibility
+type DaemonServer interface {
+	// ListDevices returns every device the daemon tracks.
+	ListDevices(context.Context, *ListDevicesRequest) (*ListDevicesResponse, error)
+	// GetState returns one device, as last observed.
+	GetState(context.Context, *GetStateRequest) (*Device, error)
+	// SetState writes a light to a device and returns the device.
+	SetState(context.Context, *SetStateRequest) (*Device, error)
+	// Toggle flips the power of a device and returns the device.
+	Toggle(context.Context, *ToggleRequest) (*Device, error)
+	// WatchEvents streams a snapshot of the devices and then each change to
+	// them, like GET /v1/events.
+	WatchEvents(*WatchEventsRequest, Daemon_WatchEventsServer) error
+	mustEmbedUnimplementedDaemonServer()
+}
+
+// UnimplementedDaemonServer must be embedded to have forward compatible implementations.
+type UnimplementedDaemonServer struct {
+}
+
+func (UnimplementedDaemonServer) ListDevices(context.Context, *ListDevicesRequest) (*ListDevicesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDevices not implemented")
+}
+func (UnimplementedDaemonServer) GetState(context.Context, *GetStateRequest) (*Device, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetState not implemented")
+}
+func (UnimplementedDaemonServer) SetState(context.Context, *SetStateRequest) (*Device, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetState not implemented")
+}
+func (UnimplementedDaemonServer) Toggle(context.Context, *ToggleRequest) (*Device, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Toggle not implemented")
+}
+func (UnimplementedDaemonServer) WatchEvents(*WatchEventsRequest, Daemon_WatchEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchEvents not implemented")
+}
+func (UnimplementedDaemonServer) mustEmbedUnimplementedDaemonServer() {}
+
+// UnsafeDaemonServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DaemonServer will
+// result in compilation errors.
+type UnsafeDaemonServer interface {
+	mustEmbedUnimplementedDaemonServer()
+}
+
+func RegisterDaemonServer(s grpc.ServiceRegistrar, srv DaemonServer) {
+	s.RegisterService(&Daemon_ServiceDesc, srv)
+}
+
+func _Daemon_ListDevices_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDevicesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DaemonServer).ListDevices(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/elgo.daemon.v1.Daemon/ListDevices",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DaemonServer).ListDevices(ctx, req.(*ListDevicesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Daemon_GetState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DaemonServer).GetState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/elgo.daemon.v1.Daemon/GetState",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DaemonServer).GetState(ctx, req.(*GetStateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Daemon_SetState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetStateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DaemonServer).SetState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/elgo.daemon.v1.Daemon/SetState",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DaemonServer).SetState(ctx, req.(*SetStateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Daemon_Toggle_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ToggleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DaemonServer).Toggle(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/elgo.daemon.v1.Daemon/Toggle",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DaemonServer).Toggle(ctx, req.(*ToggleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Daemon_WatchEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DaemonServer).WatchEvents(m, &daemonWatchEventsServer{stream})
+}
+
+type Daemon_WatchEventsServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type daemonWatchEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *daemonWatchEventsServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Daemon_ServiceDesc is the grpc.ServiceDesc for Daemon service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Daemon_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "elgo.daemon.v1.Daemon",
+	HandlerType: (*DaemonServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListDevices",
+			Handler:    _Daemon_ListDevices_Handler,
+		},
+		{
+			MethodName: "GetState",
+			Handler:    _Daemon_GetState_Handler,
+		},
+		{
+			MethodName: "SetState",
+			Handler:    _Daemon_SetState_Handler,
+		},
+		{
+			MethodName: "Toggle",
+			Handler:    _Daemon_Toggle_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchEvents",
+			Handler:       _Daemon_WatchEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "daemon.proto",
+}
@@ -0,0 +1,9 @@
+// Package daemonpb is the gRPC API of the elgo daemon, served with
+// elgo daemon -grpc-listen. For example:
+//
+//	conn, err := grpc.Dial("localhost:9125", grpc.WithInsecure())
+//	...
+//	devices, err := daemonpb.NewDaemonClient(conn).ListDevices(ctx, &daemonpb.ListDevicesRequest{})
+package daemonpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative daemon.proto
@@ -0,0 +1,389 @@
+// Package elgo discovers Elgato lights via mDNS and controls them through
+// their local HTTP API. The elgo command (in cmd/elgo) is built on the same
+// protocol.
+package elgo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oleksandr/bonjour"
+)
+
+// Service is the mDNS service type advertised by Elgato lights.
+const Service = "_elg._tcp"
+
+// Device is a light found via mDNS.
+type Device struct {
+	Instance string // mDNS instance name, e.g. "Elgato Key Light 1A2B"
+	HostName string // host:port of the HTTP API
+	IP       net.IP // nil if unknown
+}
+
+// Light is the state of one light of a device. Zero Brightness and
+// Temperature are left unchanged when writing.
+type Light struct {
+	On          int `json:"on"`                    // 1 or 0
+	Brightness  int `json:"brightness,omitempty"`  // 1 to 100
+	Temperature int `json:"temperature,omitempty"` // 143 (7000K) to 344 (2900K)
+}
+
+// State is the state of all the lights of a device.
+type State struct {
+	NumberOfLights int     `json:"numberOfLights"`
+	Lights         []Light `json:"lights"`
+}
+
+// Errors returned by Discover and by requests to devices. Test for them
+// with errors.Is, e.g. to retry when a device is unreachable:
+//
+//	if errors.Is(err, elgo.ErrDeviceUnreachable) { ... }
+var (
+	// ErrNoDeviceFound means no device answered discovery.
+	ErrNoDeviceFound = errors.New("elgo: no device found")
+	// ErrDeviceUnreachable means a device could not be connected to or
+	// didn't respond. The underlying network error is also in the chain.
+	ErrDeviceUnreachable = errors.New("elgo: device unreachable")
+	// ErrInvalidBrightness means a Light's Brightness is out of range.
+	ErrInvalidBrightness = errors.New("elgo: invalid brightness")
+	// ErrInvalidTemperature means a Light's Temperature is out of range.
+	ErrInvalidTemperature = errors.New("elgo: invalid temperature")
+)
+
+// An HTTPError is a response from a device with a status other than 200 OK.
+// Get it from an error with errors.As. Its message leaves out the body,
+// which may be long.
+type HTTPError struct {
+	Status int    // HTTP status code, e.g. 400
+	Body   []byte // response body, if any
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status))
+}
+
+// A BadResponseError is a response from a device that isn't the JSON
+// expected, such as the HTML error page of a device that is still booting.
+// Get it from an error with errors.As. Its message quotes the start of the
+// body on one line.
+type BadResponseError struct {
+	Status      int    // HTTP status code, or 0 if not known
+	ContentType string // Content-Type header, if any
+	Body        []byte // response body
+	Err         error  // from decoding the body, if any
+}
+
+// maxExcerpt is how much of a response body a BadResponseError quotes.
+const maxExcerpt = 100
+
+func (e *BadResponseError) Error() string {
+	var about []string
+	if e.Status != 0 {
+		about = append(about, fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)))
+	}
+	if e.ContentType != "" {
+		about = append(about, e.ContentType)
+	}
+	s := "unexpected response"
+	if len(about) > 0 {
+		s += " (" + strings.Join(about, ", ") + ")"
+	}
+	body := strings.Join(strings.Fields(string(e.Body)), " ")
+	switch {
+	case body == "":
+		body = "(empty)"
+	case len(body) > maxExcerpt:
+		body = body[:maxExcerpt] + "..."
+	}
+	return s + ": " + body
+}
+
+func (e *BadResponseError) Unwrap() error { return e.Err }
+
+// unreachableError is ErrDeviceUnreachable, for a host, wrapping err.
+type unreachableError struct {
+	host string
+	err  error
+}
+
+func (e *unreachableError) Error() string {
+	return fmt.Sprintf("%s unreachable: %v", e.host, e.err)
+}
+
+func (e *unreachableError) Is(target error) bool { return target == ErrDeviceUnreachable }
+func (e *unreachableError) Unwrap() error        { return e.err }
+
+// Validate checks that l's Brightness and Temperature are in range (or
+// zero, to leave them unchanged), returning ErrInvalidBrightness or
+// ErrInvalidTemperature if not.
+func (l Light) Validate() error {
+	if l.Brightness != 0 && (l.Brightness < 1 || l.Brightness > 100) {
+		return fmt.Errorf("%w %d (must be 1 to 100)", ErrInvalidBrightness, l.Brightness)
+	}
+	if l.Temperature != 0 && (l.Temperature < 143 || l.Temperature > 344) {
+		return fmt.Errorf("%w %d (must be 143 to 344)", ErrInvalidTemperature, l.Temperature)
+	}
+	return nil
+}
+
+// FromKelvin converts a color temperature in Kelvin to device units.
+func FromKelvin(kelvin int) int { return 1000000 / kelvin }
+
+// ToKelvin converts a color temperature in device units to Kelvin.
+func ToKelvin(temperature int) int { return 1000000 / temperature }
+
+type discoverConfig struct {
+	timeout time.Duration
+	iface   *net.Interface
+	service string
+}
+
+// A DiscoverOption configures Discover.
+type DiscoverOption func(*discoverConfig)
+
+// WithTimeout limits discovery to d (by default 5s). Discovery also stops
+// when its context is done. For example, to wait up to 10 seconds:
+//
+//	devices, err := elgo.Discover(ctx, elgo.WithTimeout(10*time.Second))
+func WithTimeout(d time.Duration) DiscoverOption {
+	return func(c *discoverConfig) { c.timeout = d }
+}
+
+// WithInterface restricts discovery to iface (by default, all multicast
+// interfaces). For example:
+//
+//	iface, err := net.InterfaceByName("eth0")
+//	...
+//	devices, err := elgo.Discover(ctx, elgo.WithInterface(iface))
+func WithInterface(iface *net.Interface) DiscoverOption {
+	return func(c *discoverConfig) { c.iface = iface }
+}
+
+// WithService browses for service instead of Service, for compatible
+// third-party lights. For example:
+//
+//	devices, err := elgo.Discover(ctx, elgo.WithService("_elg._tcp"))
+func WithService(service string) DiscoverOption {
+	return func(c *discoverConfig) { c.service = service }
+}
+
+// A resolver browses for a service, sending each entry it finds on entries
+// until it exits. Like bonjour.Resolver, it may only take the exit between
+// sends, and it exits by itself if Browse fails.
+type resolver interface {
+	Browse(service, domain string, entries chan<- *bonjour.ServiceEntry) error
+	exit()
+}
+
+type bonjourResolver struct {
+	*bonjour.Resolver
+}
+
+func (r bonjourResolver) exit() {
+	r.Exit <- true
+}
+
+// newResolver returns a resolver on iface, or on all interfaces if nil.
+// Tests replace it.
+var newResolver = func(iface *net.Interface) (resolver, error) {
+	r, err := bonjour.NewResolver(iface)
+	if err != nil {
+		return nil, err
+	}
+	return bonjourResolver{r}, nil
+}
+
+// Discover returns the devices that answer within the timeout, or
+// ErrNoDeviceFound if none do.
+func Discover(ctx context.Context, opts ...DiscoverOption) ([]Device, error) {
+	c := discoverConfig{timeout: 5 * time.Second, service: Service}
+	for _, o := range opts {
+		o(&c)
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	r, err := newResolver(c.iface)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(chan *bonjour.ServiceEntry)
+	// A failed Browse has already stopped the resolver (bonjour.Resolver
+	// sends itself Exit and then closes the channel), so it mustn't be
+	// sent another.
+	if err := r.Browse(c.service, "", entries); err != nil {
+		return nil, err
+	}
+	var found []Device
+	for {
+		select {
+		case e := <-entries:
+			found = append(found, Device{
+				Instance: UnescapeInstance(e.Instance),
+				HostName: net.JoinHostPort(e.HostName, strconv.Itoa(e.Port)),
+				IP:       e.AddrIPv4,
+			})
+		case <-ctx.Done():
+			// The resolver only sees Exit between sending entries, so keep
+			// receiving them until it has.
+			exited := make(chan struct{})
+			go func() {
+				r.exit()
+				close(exited)
+			}()
+			for {
+				select {
+				case <-entries:
+				case <-exited:
+					if len(found) == 0 {
+						return nil, ErrNoDeviceFound
+					}
+					return found, nil
+				}
+			}
+		}
+	}
+}
+
+// UnescapeInstance removes DNS escaping (e.g. `Key\ Light` or `Key\032Light`)
+// from an mDNS instance name.
+func UnescapeInstance(s string) string {
+	b := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			if i+2 < len(s) && isDigit(s[i]) && isDigit(s[i+1]) && isDigit(s[i+2]) {
+				n, _ := strconv.Atoi(s[i : i+3])
+				b.WriteByte(byte(n))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+type requestConfig struct {
+	retries int
+	client  *http.Client
+}
+
+// A RequestOption configures a request to a device.
+type RequestOption func(*requestConfig)
+
+// WithRetries retries a request that fails because the device is
+// unreachable or answers with a server error (5xx), up to n more times, one
+// second apart. Other errors, such as a 400 for a bad request, would only
+// fail again, so are returned at once. For example:
+//
+//	s, err := d.SetState(ctx, elgo.State{Lights: []elgo.Light{{On: 1}}}, elgo.WithRetries(3))
+func WithRetries(n int) RequestOption {
+	return func(c *requestConfig) { c.retries = n }
+}
+
+// WithHTTPClient sends requests with client instead of
+// http.DefaultClient, e.g. to set a timeout or transport. For example:
+//
+//	s, err := d.State(ctx, elgo.WithHTTPClient(&http.Client{Timeout: 2 * time.Second}))
+func WithHTTPClient(client *http.Client) RequestOption {
+	return func(c *requestConfig) { c.client = client }
+}
+
+// State returns the current state of the device's lights.
+func (d Device) State(ctx context.Context, opts ...RequestOption) (State, error) {
+	return d.do(ctx, http.MethodGet, nil, opts)
+}
+
+// SetState writes s to the device and returns the resulting state. Lights
+// out of range are rejected without contacting the device.
+func (d Device) SetState(ctx context.Context, s State, opts ...RequestOption) (State, error) {
+	for _, l := range s.Lights {
+		if err := l.Validate(); err != nil {
+			return State{}, err
+		}
+	}
+	if s.NumberOfLights == 0 {
+		s.NumberOfLights = len(s.Lights)
+	}
+	body, err := json.Marshal(s)
+	if err != nil {
+		return State{}, err
+	}
+	return d.do(ctx, http.MethodPut, body, opts)
+}
+
+func (d Device) do(ctx context.Context, method string, body []byte, opts []RequestOption) (State, error) {
+	c := requestConfig{client: http.DefaultClient}
+	for _, o := range opts {
+		o(&c)
+	}
+	var err error
+	for attempt := 0; ; attempt++ {
+		var s State
+		if s, err = d.request(ctx, c.client, method, body); err == nil || attempt >= c.retries || !retryable(err) {
+			return s, err
+		}
+		select {
+		case <-ctx.Done():
+			return State{}, ctx.Err()
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+// retryDelay is the time between attempts of a request. Tests shorten it.
+var retryDelay = time.Second
+
+// retryable reports whether a request that failed with err might succeed
+// if sent again: if the device couldn't be reached or had a server error.
+func retryable(err error) bool {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Status >= 500
+	}
+	return errors.Is(err, ErrDeviceUnreachable)
+}
+
+func (d Device) request(ctx context.Context, client *http.Client, method string, body []byte) (State, error) {
+	url := fmt.Sprintf("http://%s/elgato/lights", d.HostName)
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return State{}, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return State{}, ctx.Err()
+		}
+		return State{}, &unreachableError{d.HostName, err}
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return State{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return State{}, fmt.Errorf("%s: %w", d.HostName, &HTTPError{resp.StatusCode, b})
+	}
+	s := State{}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return State{}, fmt.Errorf("%s: %w", d.HostName, &BadResponseError{resp.StatusCode, resp.Header.Get("Content-Type"), b, err})
+	}
+	return s, nil
+}
@@ -0,0 +1,297 @@
+package elgo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/oleksandr/bonjour"
+)
+
+func TestValidate(t *testing.T) {
+	for _, tt := range []struct {
+		l    Light
+		want error
+	}{
+		{Light{On: 1}, nil},
+		{Light{Brightness: 1, Temperature: 143}, nil},
+		{Light{Brightness: 100, Temperature: 344}, nil},
+		{Light{Brightness: 101}, ErrInvalidBrightness},
+		{Light{Brightness: -1}, ErrInvalidBrightness},
+		{Light{Temperature: 142}, ErrInvalidTemperature},
+		{Light{Temperature: 345}, ErrInvalidTemperature},
+	} {
+		if err := tt.l.Validate(); !errors.Is(err, tt.want) || (err == nil) != (tt.want == nil) {
+			t.Errorf("%+v: Validate() = %v, want %v", tt.l, err, tt.want)
+		}
+	}
+}
+
+func TestUnescapeInstance(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{`Elgato Key Light`, "Elgato Key Light"},
+		{`Elgato\ Key\ Light`, "Elgato Key Light"},
+		{`Key\032Light`, "Key Light"},
+		{`Desk\.Left`, "Desk.Left"},
+		{`Key\+12`, "Key+12"},
+		{`trailing\`, `trailing\`},
+	} {
+		if got := UnescapeInstance(tt.in); got != tt.want {
+			t.Errorf("UnescapeInstance(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// fakeLight serves a device that answers each request with the next of
+// responses (repeating the last), and counts the requests.
+type fakeLight struct {
+	responses []fakeResponse
+	requests  int32
+	lastBody  []byte
+}
+
+type fakeResponse struct {
+	status      int
+	contentType string
+	body        string
+}
+
+func (f *fakeLight) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := int(atomic.AddInt32(&f.requests, 1)) - 1
+	if n >= len(f.responses) {
+		n = len(f.responses) - 1
+	}
+	f.lastBody, _ = ioutil.ReadAll(r.Body)
+	resp := f.responses[n]
+	if resp.contentType != "" {
+		w.Header().Set("Content-Type", resp.contentType)
+	}
+	w.WriteHeader(resp.status)
+	w.Write([]byte(resp.body))
+}
+
+const okState = `{"numberOfLights":1,"lights":[{"on":1,"brightness":40,"temperature":200}]}`
+
+func TestRequests(t *testing.T) {
+	defer func(d time.Duration) { retryDelay = d }(retryDelay)
+	retryDelay = time.Millisecond
+
+	ok := fakeResponse{http.StatusOK, "application/json", okState}
+	for _, tt := range []struct {
+		name      string
+		responses []fakeResponse
+		retries   int
+		check     func(error) bool
+		requests  int32
+	}{
+		{"ok", []fakeResponse{ok}, 0, func(err error) bool { return err == nil }, 1},
+		{"bad request not retried", []fakeResponse{{http.StatusBadRequest, "", ""}}, 2, func(err error) bool {
+			var e *HTTPError
+			return errors.As(err, &e) && e.Status == http.StatusBadRequest
+		}, 1},
+		{"server error retried", []fakeResponse{{http.StatusServiceUnavailable, "", "busy"}, ok}, 2, func(err error) bool { return err == nil }, 2},
+		{"server error until out of retries", []fakeResponse{{http.StatusInternalServerError, "", ""}}, 2, func(err error) bool {
+			var e *HTTPError
+			return errors.As(err, &e) && e.Status == http.StatusInternalServerError
+		}, 3},
+		{"bad response not retried", []fakeResponse{{http.StatusOK, "text/html", "<html>booting</html>"}}, 2, func(err error) bool {
+			var e *BadResponseError
+			return errors.As(err, &e) && e.ContentType == "text/html" && e.Err != nil
+		}, 1},
+	} {
+		f := &fakeLight{responses: tt.responses}
+		srv := httptest.NewServer(f)
+		d := Device{HostName: srv.Listener.Addr().String()}
+		s, err := d.State(context.Background(), WithRetries(tt.retries))
+		srv.Close()
+		if !tt.check(err) {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if err == nil && (len(s.Lights) != 1 || s.Lights[0].Brightness != 40) {
+			t.Errorf("%s: state %+v", tt.name, s)
+		}
+		if f.requests != tt.requests {
+			t.Errorf("%s: %d requests, want %d", tt.name, f.requests, tt.requests)
+		}
+	}
+}
+
+func TestUnreachable(t *testing.T) {
+	defer func(d time.Duration) { retryDelay = d }(retryDelay)
+	retryDelay = time.Millisecond
+
+	// A listener that is closed at once refuses connections.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var attempts int32
+	client := &http.Client{Transport: countingTransport{&attempts}}
+	d := Device{HostName: l.Addr().String()}
+	l.Close()
+	_, err = d.State(context.Background(), WithRetries(2), WithHTTPClient(client))
+	if !errors.Is(err, ErrDeviceUnreachable) {
+		t.Errorf("got %v, want ErrDeviceUnreachable", err)
+	}
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		t.Errorf("%v does not wrap the network error", err)
+	}
+	if attempts != 3 {
+		t.Errorf("%d attempts with 2 retries, want 3", attempts)
+	}
+
+	// Cancelling is not the device being unreachable.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := d.State(ctx, WithRetries(2)); !errors.Is(err, context.Canceled) || errors.Is(err, ErrDeviceUnreachable) {
+		t.Errorf("cancelled: got %v, want context.Canceled", err)
+	}
+}
+
+// countingTransport is http.DefaultTransport, counting the requests sent.
+type countingTransport struct {
+	n *int32
+}
+
+func (c countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	atomic.AddInt32(c.n, 1)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestSetState(t *testing.T) {
+	f := &fakeLight{responses: []fakeResponse{{http.StatusOK, "application/json", okState}}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	d := Device{HostName: srv.Listener.Addr().String()}
+
+	if _, err := d.SetState(context.Background(), State{Lights: []Light{{On: 1, Brightness: 150}}}); !errors.Is(err, ErrInvalidBrightness) {
+		t.Errorf("brightness 150: got %v, want ErrInvalidBrightness", err)
+	}
+	if f.requests != 0 {
+		t.Errorf("invalid state sent to the device")
+	}
+
+	if _, err := d.SetState(context.Background(), State{Lights: []Light{{On: 1, Brightness: 40}}}); err != nil {
+		t.Fatal(err)
+	}
+	var sent State
+	if err := json.Unmarshal(f.lastBody, &sent); err != nil {
+		t.Fatal(err)
+	}
+	if sent.NumberOfLights != 1 || len(sent.Lights) != 1 || sent.Lights[0].Brightness != 40 {
+		t.Errorf("sent %s", f.lastBody)
+	}
+}
+
+// fakeResolver sends entries for the service it was created for, like
+// bonjour.Resolver: one at a time, taking an exit only between them.
+type fakeResolver struct {
+	entries   []*bonjour.ServiceEntry
+	browseErr error
+	service   string // browsed for
+	exits     chan struct{}
+
+	exitedStopped bool // exit was called after Browse failed
+}
+
+func (r *fakeResolver) Browse(service, domain string, entries chan<- *bonjour.ServiceEntry) error {
+	r.service = service
+	if r.browseErr != nil {
+		return r.browseErr
+	}
+	go func() {
+		for _, e := range r.entries {
+			select {
+			case entries <- e:
+			case <-r.exits:
+				return
+			}
+		}
+		<-r.exits
+	}()
+	return nil
+}
+
+func (r *fakeResolver) exit() {
+	if r.browseErr != nil {
+		r.exitedStopped = true // bonjour.Resolver would panic
+		return
+	}
+	r.exits <- struct{}{}
+}
+
+func useResolver(t *testing.T, r *fakeResolver) {
+	t.Helper()
+	old := newResolver
+	newResolver = func(*net.Interface) (resolver, error) { return r, nil }
+	t.Cleanup(func() { newResolver = old })
+}
+
+func TestDiscover(t *testing.T) {
+	entry := func(instance, host string, port int) *bonjour.ServiceEntry {
+		e := bonjour.NewServiceEntry(instance, Service, "local.")
+		e.HostName, e.Port, e.AddrIPv4 = host, port, net.IPv4(192, 168, 1, 20)
+		return e
+	}
+	r := &fakeResolver{
+		entries: []*bonjour.ServiceEntry{entry(`Key\ Light`, "key-light.local.", 9123), entry(`Ring\032Light`, "ring.local.", 9124)},
+		exits:   make(chan struct{}),
+	}
+	useResolver(t, r)
+	found, err := Discover(context.Background(), WithTimeout(50*time.Millisecond), WithService("_other._tcp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.service != "_other._tcp" {
+		t.Errorf("browsed for %q, want the WithService service", r.service)
+	}
+	if len(found) != 2 || found[0].Instance != "Key Light" || found[0].HostName != "key-light.local.:9123" || found[1].Instance != "Ring Light" || !found[0].IP.Equal(net.IPv4(192, 168, 1, 20)) {
+		t.Errorf("found %+v", found)
+	}
+}
+
+func TestDiscoverErrors(t *testing.T) {
+	browseErr := errors.New("no multicast")
+	for _, tt := range []struct {
+		name string
+		r    *fakeResolver
+		want error
+	}{
+		{"nothing found", &fakeResolver{exits: make(chan struct{})}, ErrNoDeviceFound},
+		// The resolver stops by itself when Browse fails, so it must get
+		// no exit.
+		{"browse fails", &fakeResolver{browseErr: browseErr, exits: make(chan struct{})}, browseErr},
+	} {
+		useResolver(t, tt.r)
+		start := time.Now()
+		if _, err := Discover(context.Background(), WithTimeout(50*time.Millisecond)); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+		if d := time.Since(start); d > 5*time.Second {
+			t.Errorf("%s: took %s", tt.name, d)
+		}
+		if tt.r.exitedStopped {
+			t.Errorf("%s: exit sent to a stopped resolver", tt.name)
+		}
+	}
+
+	// Discovery stops with its context, before the timeout.
+	useResolver(t, &fakeResolver{exits: make(chan struct{})})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := Discover(ctx, WithTimeout(time.Hour)); !errors.Is(err, ErrNoDeviceFound) {
+		t.Errorf("cancelled: got %v, want ErrNoDeviceFound", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("cancelled discovery took %s", d)
+	}
+}
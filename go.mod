@@ -3,7 +3,17 @@ module github.com/vsekhar/elgo
 go 1.16
 
 require (
-	github.com/miekg/dns v1.1.41 // indirect
+	fyne.io/systray v1.10.0
+	github.com/eclipse/paho.mqtt.golang v1.3.5
+	github.com/godbus/dbus/v5 v5.0.4
+	github.com/golang/protobuf v1.4.2
+	github.com/jezek/xgb v1.0.0
+	github.com/miekg/dns v1.1.41
 	github.com/oleksandr/bonjour v0.0.0-20210301155756-30f43c61b915
-	golang.org/x/net v0.0.0-20210410081132-afb366fc7cd1 // indirect
+	golang.org/x/net v0.0.0-20210410081132-afb366fc7cd1
+	golang.org/x/sys v0.0.0-20210330210617-4fbd30eecc44
+	golang.org/x/term v0.0.0-20210317153231-de623e64d2a6
+	google.golang.org/grpc v1.38.0
+	google.golang.org/protobuf v1.25.0
+	gopkg.in/yaml.v2 v2.4.0
 )